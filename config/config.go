@@ -1,110 +1,186 @@
 package config
 
 import (
-	"fmt"
-	"log"
-	"os"
-	"strconv"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// TextQuestion seeds the question bank, see handlers.SeedQuestions
 type TextQuestion struct {
-	Question string
-	Answer   string
+	Question     string   `yaml:"question"`
+	Answer       string   `yaml:"answer"`
+	Alternatives []string `yaml:"alternatives"` // Other accepted answers
+	Language     string   `yaml:"language"`     // Defaults to default_language
+	Difficulty   string   `yaml:"difficulty"`   // "easy", "medium" or "hard", defaults to medium
+	Normalize    []string `yaml:"normalize"`    // Normalization steps of answers, all when unset, [] for none
+	Tolerance    int      `yaml:"tolerance"`    // Typos forgiven in longer answers, 0-3
 }
 
 type CaptchaConfig struct {
-	TextQuestions []TextQuestion
-	Colors        []string
-	ButtonText    string
-	MathOperators []string
+	TextQuestions []TextQuestion `yaml:"questions"`
+	Colors        []string       `yaml:"colors"`
+	ButtonText    string         `yaml:"button_text"`
+	MathOperators []string       `yaml:"math_operators"`
+
+	// Expired captchas are cleaned up in the background
+	SweepInterval    time.Duration `yaml:"sweep_interval"`
+	TimeoutIsAttempt bool          `yaml:"timeout_is_attempt"` // An expired captcha counts as a failed attempt
 }
 
-type Config struct {
-    BotToken    string
-    MongoURI    string
-    MongoDBName string
-    AdminID     int64
-    Debug       bool
-    Captcha     CaptchaConfig
+// Defaults are used for admin settings that were never changed with /set
+type Defaults struct {
+	MaxAttempts     int           `yaml:"max_attempts"`
+	CaptchaType     string        `yaml:"captcha_type"` // "random", "math", "text", "button", "image"
+	CaptchaTTL      time.Duration `yaml:"captcha_ttl"`
+	BlockDuration   time.Duration `yaml:"block_duration"`  // 0 - block forever
+	NotifyUnblock   bool          `yaml:"notify_unblock"`  // Tell users when their block expires
+	AutoForward     bool          `yaml:"auto_forward"`
+	WelcomeMessage  string        `yaml:"welcome_message"`
+	VerifiedMessage string        `yaml:"verified_message"`
 }
 
-func Load() *Config {
-	if err := godotenv.Load(); err != nil {
-        log.Printf("Warning: .env file not found: %v", err)
-    }
-
-    adminID, _ := strconv.ParseInt(os.Getenv("ADMIN_ID"), 10, 64)
-    debug, _ := strconv.ParseBool(os.Getenv("DEBUG"))
-    
-    return &Config{
-        BotToken:    os.Getenv("BOT_TOKEN"),
-        MongoURI:    os.Getenv("MONGO_URI"),
-        MongoDBName: getEnv("MONGO_DB_NAME", "telegram_bot"),
-        AdminID:     adminID,
-        Debug:       debug,
-        Captcha:     loadCaptchaConfig(),
-    }
+// GroupConfig controls gatekeeping of new members in groups
+type GroupConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	FailAction string `yaml:"fail_action"` // "kick" - the user can join again, "ban" - for block_duration
 }
 
-func loadCaptchaConfig() CaptchaConfig {
-	config := CaptchaConfig{}
-	
-	// Loading questions
-	questionCount := 0
-	for i := 1; ; i++ {
-		qKey := fmt.Sprintf("CAPTCHA_Q%d_QUESTION", i)
-		aKey := fmt.Sprintf("CAPTCHA_Q%d_ANSWER", i)
-		
-		question := os.Getenv(qKey)
-		answer := os.Getenv(aKey)
-		
-		if question == "" || answer == "" {
-			break
-		}
-		
-		config.TextQuestions = append(config.TextQuestions, TextQuestion{
-			Question: question,
-			Answer:   answer,
-		})
-		questionCount++
+// RetentionConfig controls the cleanup job. A zero period turns a policy off.
+// The config file sets the periods in days, see UnmarshalYAML
+type RetentionConfig struct {
+	Interval       time.Duration // How often the cleanup runs
+	Messages       time.Duration // Stored messages older than this are purged
+	InactiveUsers  time.Duration // Never verified users inactive this long are deleted
+	StaleCaptchas  time.Duration // Captchas that expired this long ago are cleared
+	CompactBlocked time.Duration // Users blocked forever this long ago are moved to the blacklist
+}
+
+// StaffConfig is a staff member defined in the config file. Like the owner,
+// they cannot be changed with /addmod and /removemod
+type StaffConfig struct {
+	ID   int64  `yaml:"id"`
+	Role string `yaml:"role"` // "owner", "moderator" or "viewer"
+}
+
+// WebhookConfig is used when updates are received with a webhook instead of
+// long polling. Without a certificate the listener speaks plain HTTP and TLS is
+// expected to be terminated by a reverse proxy or load balancer
+type WebhookConfig struct {
+	URL            string `yaml:"url"`    // Public URL Telegram sends updates to
+	Listen         string `yaml:"listen"` // Address of the local listener
+	Path           string `yaml:"path"`   // Path updates are accepted on, defaults to the path of URL
+	SecretToken    string `yaml:"secret"` // Compared with the X-Telegram-Bot-Api-Secret-Token header
+	CertFile       string `yaml:"tls_cert"`
+	KeyFile        string `yaml:"tls_key"`
+	UploadCert     bool   `yaml:"upload_cert"` // Send CertFile to Telegram, needed for self-signed certificates
+	MaxConnections int    `yaml:"max_connections"`
+	Register       bool   `yaml:"register"` // Call setWebhook on startup, can be left to a single replica
+	DropPending    bool   `yaml:"drop_pending"`
+}
+
+// TLS reports whether the listener terminates TLS itself
+func (w WebhookConfig) TLS() bool {
+	return w.CertFile != "" && w.KeyFile != ""
+}
+
+type Config struct {
+    BotToken        string          `yaml:"bot_token"`
+    UpdateMode      string          `yaml:"update_mode"` // "polling" or "webhook"
+    Webhook         WebhookConfig   `yaml:"webhook"`
+    Storage         string          `yaml:"storage"` // "mongo" or "memory"
+    MongoURI        string          `yaml:"mongo_uri"`
+    MongoDBName     string          `yaml:"mongo_db_name"`
+    AdminID         int64           `yaml:"admin_id"`         // The owner
+    Staff           []StaffConfig   `yaml:"staff"`            // More staff members next to the owner
+    Debug           bool            `yaml:"debug"`            // Log raw Bot API traffic, including message content
+    LogLevel        string          `yaml:"log_level"`        // "debug", "info", "warn" or "error"
+    LogFormat       string          `yaml:"log_format"`       // "text" or "json"
+    LogContent      bool            `yaml:"log_content"`      // Log message bodies and names, redacted by default
+    ShutdownTimeout time.Duration   `yaml:"shutdown_timeout"` // How long the whole shutdown may take
+    Workers         int             `yaml:"workers"`          // Updates handled at the same time
+    QueueSize       int             `yaml:"queue_size"`       // Updates waiting for a worker before receiving slows down
+    CallbackSecret  string          `yaml:"callback_secret"`  // Signs button data, derived from BotToken when empty
+    CallbackTTL     time.Duration   `yaml:"callback_ttl"`     // How long buttons of admin cards stay valid
+    MetricsListen   string          `yaml:"metrics_listen"`   // Address of the /metrics listener, disabled when empty
+    DefaultLanguage string          `yaml:"default_language"` // Locale of users whose language is unknown and of staff texts
+    Captcha         CaptchaConfig   `yaml:"captcha"`
+    Defaults        Defaults        `yaml:"defaults"`
+    Group           GroupConfig     `yaml:"group"`
+    Retention       RetentionConfig `yaml:"retention"`
+}
+
+// Load reads the config file, puts the environment variables on top of it and
+// checks the result. The error lists every invalid or missing setting
+func Load() (*Config, error) {
+	// The .env file is optional, a config file can be used instead
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("Error reading the .env file", "error", err)
 	}
-	
-	// Loading colors
-	if colors := os.Getenv("CAPTCHA_COLORS"); colors != "" {
-		config.Colors = splitCommaSeparated(colors)
+
+	cfg := defaultConfig()
+	r := &report{}
+
+	if path := configFile(); path != "" {
+		loadFile(path, cfg, r)
 	}
-	
-	// Loading button text
-	config.ButtonText = os.Getenv("CAPTCHA_BUTTON_TEXT")
-	
-	// Loading mathematical operators
-	if operators := os.Getenv("CAPTCHA_MATH_OPS"); operators != "" {
-		config.MathOperators = splitCommaSeparated(operators)
+	applyEnv(cfg, r)
+
+	cfg.DefaultLanguage = strings.ToLower(cfg.DefaultLanguage)
+	if cfg.Webhook.Path == "" {
+		if u, err := url.Parse(cfg.Webhook.URL); err == nil && u.Path != "" {
+			cfg.Webhook.Path = u.Path
+		} else {
+			cfg.Webhook.Path = "/"
+		}
 	}
-	
-	return config
+
+	validate(cfg, r)
+
+	return cfg, r.err()
 }
 
-func splitCommaSeparated(s string) []string {
-	parts := strings.Split(s, ",")
-	result := make([]string, 0, len(parts))
-	
-	for _, part := range parts {
-		trimmed := strings.TrimSpace(part)
-		if trimmed != "" {
-			result = append(result, trimmed)
-		}
+func defaultConfig() *Config {
+	return &Config{
+		UpdateMode:      "polling",
+		Storage:         "mongo",
+		MongoDBName:     "telegram_bot",
+		LogLevel:        "info",
+		LogFormat:       "text",
+		ShutdownTimeout: 8 * time.Second,
+		Workers:         8,
+		QueueSize:       1000,
+		CallbackTTL:     48 * time.Hour,
+		DefaultLanguage: "en",
+		Webhook: WebhookConfig{
+			Listen:         ":8443",
+			MaxConnections: 40,
+			Register:       true,
+		},
+		Captcha: CaptchaConfig{
+			SweepInterval:    15 * time.Second,
+			TimeoutIsAttempt: true,
+		},
+		Defaults: Defaults{
+			MaxAttempts:   3,
+			CaptchaType:   "random",
+			CaptchaTTL:    2 * time.Minute,
+			NotifyUnblock: true,
+			AutoForward:   true,
+		},
+		Group: GroupConfig{
+			Enabled:    true,
+			FailAction: "kick",
+		},
+		Retention: RetentionConfig{
+			Interval:      24 * time.Hour,
+			StaleCaptchas: day,
+		},
 	}
-	
-	return result
 }
 
-func getEnv(key, defaultValue string) string {
-    if value := os.Getenv(key); value != "" {
-        return value
-    }
-    return defaultValue
-}
\ No newline at end of file
@@ -0,0 +1,290 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// writeConfig writes a config file and points CONFIG_FILE at it
+func writeConfig(t *testing.T, content string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+}
+
+// requiredEnv sets what every valid configuration needs
+func requiredEnv(t *testing.T) {
+	t.Setenv("BOT_TOKEN", "123456:ABC-DEF_ghi")
+	t.Setenv("ADMIN_ID", "42")
+	t.Setenv("STORAGE", "memory")
+}
+
+func problems(t *testing.T, err error) []string {
+	t.Helper()
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Load() error = %v, want a ValidationError", err)
+	}
+	return verr.Problems
+}
+
+func hasProblem(list []string, prefix string) bool {
+	return slices.ContainsFunc(list, func(p string) bool { return strings.HasPrefix(p, prefix) })
+}
+
+func TestLoadFile(t *testing.T) {
+	requiredEnv(t)
+	writeConfig(t, `
+defaults:
+  max_attempts: 5
+  captcha_type: text
+  captcha_ttl: 90s
+captcha:
+  questions:
+    - question: Capital of France?
+      answer: Paris
+  colors: [red, green, blue, yellow]
+  math_operators: ["+", "×"]
+staff:
+  - id: 7
+    role: viewer
+retention:
+  messages_days: 14
+`)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Defaults.MaxAttempts != 5 || cfg.Defaults.CaptchaType != "text" || cfg.Defaults.CaptchaTTL != 90*time.Second {
+		t.Errorf("defaults = %+v", cfg.Defaults)
+	}
+	if len(cfg.Captcha.TextQuestions) != 1 || cfg.Captcha.TextQuestions[0].Answer != "Paris" {
+		t.Errorf("questions = %+v", cfg.Captcha.TextQuestions)
+	}
+	if len(cfg.Staff) != 1 || cfg.Staff[0] != (StaffConfig{ID: 7, Role: "viewer"}) {
+		t.Errorf("staff = %+v", cfg.Staff)
+	}
+	if cfg.Retention.Messages != 14*day {
+		t.Errorf("retention.messages = %s, want 14 days", cfg.Retention.Messages)
+	}
+	// Keys missing in the file keep their defaults
+	if cfg.Retention.StaleCaptchas != day || cfg.Workers != 8 {
+		t.Errorf("defaults were lost: stale captchas %s, workers %d", cfg.Retention.StaleCaptchas, cfg.Workers)
+	}
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	requiredEnv(t)
+	writeConfig(t, `
+admin_id: 1
+defaults:
+  max_attempts: 5
+captcha:
+  questions:
+    - question: From the file?
+      answer: "yes"
+`)
+	t.Setenv("MAX_ATTEMPTS", "7")
+	t.Setenv("CAPTCHA_Q1_QUESTION", "From the environment?")
+	t.Setenv("CAPTCHA_Q1_ANSWER", "yes | y")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.AdminID != 42 {
+		t.Errorf("admin_id = %d, want 42 from ADMIN_ID", cfg.AdminID)
+	}
+	if cfg.Defaults.MaxAttempts != 7 {
+		t.Errorf("max_attempts = %d, want 7 from MAX_ATTEMPTS", cfg.Defaults.MaxAttempts)
+	}
+	if len(cfg.Captcha.TextQuestions) != 1 || cfg.Captcha.TextQuestions[0].Question != "From the environment?" {
+		t.Fatalf("questions = %+v, want the one from the environment", cfg.Captcha.TextQuestions)
+	}
+	if q := cfg.Captcha.TextQuestions[0]; q.Answer != "yes" || !slices.Equal(q.Alternatives, []string{"y"}) {
+		t.Errorf("answers = %q and %q, want yes and y", q.Answer, q.Alternatives)
+	}
+}
+
+// The .env file of the repository must not hide the values of the config file
+func TestExampleEnvKeepsFileValues(t *testing.T) {
+	example, err := os.ReadFile(filepath.Join("..", ".env.example"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err := godotenv.Unmarshal(string(example))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requiredEnv(t)
+	// Load sets the variables of .env in the process, they are removed after the test
+	for key := range vars {
+		if _, ok := os.LookupEnv(key); !ok {
+			t.Setenv(key, "")
+			os.Unsetenv(key)
+		}
+	}
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.WriteFile(".env", example, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(t, `
+update_mode: polling
+defaults:
+  max_attempts: 5
+  captcha_type: math
+captcha:
+  questions:
+    - question: From the file?
+      answer: "yes"
+  math_operators: ["+"]
+group:
+  fail_action: ban
+retention:
+  inactive_users_days: 10
+`)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Defaults.MaxAttempts != 5 || cfg.Defaults.CaptchaType != "math" {
+		t.Errorf("defaults = %+v, want the values of the file", cfg.Defaults)
+	}
+	if len(cfg.Captcha.TextQuestions) != 1 || cfg.Captcha.TextQuestions[0].Question != "From the file?" {
+		t.Errorf("questions = %+v, want the one from the file", cfg.Captcha.TextQuestions)
+	}
+	if !slices.Equal(cfg.Captcha.MathOperators, []string{"+"}) || cfg.Group.FailAction != "ban" {
+		t.Errorf("math operators %q, fail action %q, want the values of the file", cfg.Captcha.MathOperators, cfg.Group.FailAction)
+	}
+	if cfg.Retention.InactiveUsers != 10*day {
+		t.Errorf("retention.inactive_users = %s, want 10 days", cfg.Retention.InactiveUsers)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("BOT_TOKEN", "")
+	t.Setenv("ADMIN_ID", "")
+	t.Setenv("STORAGE", "memory")
+	t.Setenv("WORKERS", "many")
+	t.Setenv("GROUP_FAIL_ACTION", "mute")
+	t.Setenv("CAPTCHA_Q1_QUESTION", "Capital of France?")
+	t.Setenv("CAPTCHA_Q1_ANSWER", "Paris")
+	t.Setenv("CAPTCHA_Q3_QUESTION", "Capital of Italy?")
+
+	_, err := Load()
+	list := problems(t, err)
+
+	for _, want := range []string{
+		"bot_token / BOT_TOKEN: required",
+		"admin_id / ADMIN_ID: required",
+		"WORKERS: expected a whole number",
+		"group.fail_action / GROUP_FAIL_ACTION:",
+		"CAPTCHA_Q3_ANSWER: missing",
+	} {
+		if !hasProblem(list, want) {
+			t.Errorf("missing problem %q in %q", want, list)
+		}
+	}
+	if !strings.HasPrefix(err.Error(), "invalid configuration, 5 problem(s):") {
+		t.Errorf("error = %q", err)
+	}
+}
+
+func TestLoadRejectsInvalidFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "unknown key",
+			content: "defaults:\n  max_atempts: 5\n",
+			want:    "field max_atempts not found",
+		},
+		{
+			name:    "wrong type",
+			content: "workers: eight\n",
+			want:    "cannot unmarshal",
+		},
+		{
+			name:    "negative retention",
+			content: "retention:\n  messages_days: -1\n",
+			want:    "retention.messages_days must be 0 or more days",
+		},
+		{
+			name:    "unknown staff role",
+			content: "staff:\n  - id: 7\n    role: janitor\n",
+			want:    "staff[0].role / STAFF:",
+		},
+		{
+			name:    "unknown difficulty",
+			content: "captcha:\n  questions:\n    - question: 2 + 2?\n      answer: \"4\"\n      difficulty: trivial\n",
+			want:    "captcha.questions[0].difficulty: expected one of easy, medium, hard",
+		},
+		{
+			name:    "unknown normalization step",
+			content: "captcha:\n  questions:\n    - question: 2 + 2?\n      answer: \"4\"\n      normalize: [numbers, spelling]\n",
+			want:    `captcha.questions[0].normalize: expected nfkc, diacritics, punctuation, numbers, none, got "spelling"`,
+		},
+		{
+			name:    "tolerance out of range",
+			content: "captcha:\n  questions:\n    - question: 2 + 2?\n      answer: \"4\"\n      tolerance: 5\n",
+			want:    "captcha.questions[0].tolerance: expected 0-3 typos",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requiredEnv(t)
+			writeConfig(t, tt.content)
+
+			_, err := Load()
+			list := problems(t, err)
+			if !slices.ContainsFunc(list, func(p string) bool { return strings.Contains(p, tt.want) }) {
+				t.Errorf("problems = %q, want one containing %q", list, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRequiresMongoURI(t *testing.T) {
+	requiredEnv(t)
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("STORAGE", "mongo")
+	t.Setenv("MONGO_URI", "")
+
+	_, err := Load()
+	if !hasProblem(problems(t, err), "mongo_uri / MONGO_URI: required") {
+		t.Errorf("error = %v, want a missing MONGO_URI", err)
+	}
+}
+
+func TestExampleConfigIsValid(t *testing.T) {
+	requiredEnv(t)
+	t.Setenv("CONFIG_FILE", filepath.Join("..", "config.example.yaml"))
+
+	if _, err := Load(); err != nil {
+		t.Fatal(err)
+	}
+}
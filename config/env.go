@@ -0,0 +1,248 @@
+package config
+
+import (
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// applyEnv puts the environment variables that are set on top of the config
+// file. Empty variables are treated as unset
+func applyEnv(cfg *Config, r *report) {
+	envString("BOT_TOKEN", &cfg.BotToken)
+	envString("UPDATE_MODE", &cfg.UpdateMode)
+	envString("STORAGE", &cfg.Storage)
+	envString("MONGO_URI", &cfg.MongoURI)
+	envString("MONGO_DB_NAME", &cfg.MongoDBName)
+	envInt64(r, "ADMIN_ID", &cfg.AdminID)
+	envStaff(r, "STAFF", &cfg.Staff)
+	envBool(r, "DEBUG", &cfg.Debug)
+	envString("LOG_LEVEL", &cfg.LogLevel)
+	envString("LOG_FORMAT", &cfg.LogFormat)
+	envBool(r, "LOG_CONTENT", &cfg.LogContent)
+	envDuration(r, "SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
+	envInt(r, "WORKERS", &cfg.Workers)
+	envInt(r, "QUEUE_SIZE", &cfg.QueueSize)
+	envString("CALLBACK_SECRET", &cfg.CallbackSecret)
+	envDuration(r, "CALLBACK_TTL", &cfg.CallbackTTL)
+	envString("METRICS_LISTEN", &cfg.MetricsListen)
+	envString("DEFAULT_LANGUAGE", &cfg.DefaultLanguage)
+
+	webhook := &cfg.Webhook
+	envString("WEBHOOK_URL", &webhook.URL)
+	envString("WEBHOOK_LISTEN", &webhook.Listen)
+	envString("WEBHOOK_PATH", &webhook.Path)
+	envString("WEBHOOK_SECRET", &webhook.SecretToken)
+	envString("WEBHOOK_TLS_CERT", &webhook.CertFile)
+	envString("WEBHOOK_TLS_KEY", &webhook.KeyFile)
+	envBool(r, "WEBHOOK_UPLOAD_CERT", &webhook.UploadCert)
+	envInt(r, "WEBHOOK_MAX_CONNECTIONS", &webhook.MaxConnections)
+	envBool(r, "WEBHOOK_REGISTER", &webhook.Register)
+	envBool(r, "WEBHOOK_DROP_PENDING", &webhook.DropPending)
+
+	defaults := &cfg.Defaults
+	envInt(r, "MAX_ATTEMPTS", &defaults.MaxAttempts)
+	envString("CAPTCHA_TYPE", &defaults.CaptchaType)
+	envDuration(r, "CAPTCHA_TTL", &defaults.CaptchaTTL)
+	envDuration(r, "BLOCK_DURATION", &defaults.BlockDuration)
+	envBool(r, "NOTIFY_UNBLOCK", &defaults.NotifyUnblock)
+	envBool(r, "AUTO_FORWARD", &defaults.AutoForward)
+	envString("WELCOME_MESSAGE", &defaults.WelcomeMessage)
+	envString("VERIFIED_MESSAGE", &defaults.VerifiedMessage)
+
+	captcha := &cfg.Captcha
+	envQuestions(r, &captcha.TextQuestions)
+	envList("CAPTCHA_COLORS", &captcha.Colors)
+	envString("CAPTCHA_BUTTON_TEXT", &captcha.ButtonText)
+	envList("CAPTCHA_MATH_OPS", &captcha.MathOperators)
+	envDuration(r, "CAPTCHA_SWEEP_INTERVAL", &captcha.SweepInterval)
+	envBool(r, "CAPTCHA_TIMEOUT_IS_ATTEMPT", &captcha.TimeoutIsAttempt)
+
+	envBool(r, "GROUP_MODE", &cfg.Group.Enabled)
+	envString("GROUP_FAIL_ACTION", &cfg.Group.FailAction)
+
+	retention := &cfg.Retention
+	envDuration(r, "RETENTION_INTERVAL", &retention.Interval)
+	envDays(r, "RETENTION_MESSAGES_DAYS", &retention.Messages)
+	envDays(r, "RETENTION_INACTIVE_USERS_DAYS", &retention.InactiveUsers)
+	envDays(r, "RETENTION_STALE_CAPTCHAS_DAYS", &retention.StaleCaptchas)
+	envDays(r, "RETENTION_COMPACT_BLOCKED_DAYS", &retention.CompactBlocked)
+}
+
+func envString(key string, dst *string) {
+	if value := os.Getenv(key); value != "" {
+		*dst = value
+	}
+}
+
+func envInt(r *report, key string, dst *int) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		r.add("%s: expected a whole number, got %q", key, value)
+		return
+	}
+	*dst = parsed
+}
+
+func envInt64(r *report, key string, dst *int64) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		r.add("%s: expected a Telegram ID, got %q", key, value)
+		return
+	}
+	*dst = parsed
+}
+
+func envBool(r *report, key string, dst *bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		r.add("%s: expected true or false, got %q", key, value)
+		return
+	}
+	*dst = parsed
+}
+
+func envDuration(r *report, key string, dst *time.Duration) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		r.add("%s: expected a duration such as 30s, 2m or 24h, got %q", key, value)
+		return
+	}
+	*dst = parsed
+}
+
+// envDays reads a number of days, negative values turn the setting off
+func envDays(r *report, key string, dst *time.Duration) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil {
+		r.add("%s: expected a number of days, got %q", key, value)
+		return
+	}
+	*dst = time.Duration(max(days, 0)) * day
+}
+
+func envList(key string, dst *[]string) {
+	if value := os.Getenv(key); value != "" {
+		*dst = splitCommaSeparated(value)
+	}
+}
+
+// envStaff reads a list such as "123:moderator,456:viewer"
+func envStaff(r *report, key string, dst *[]StaffConfig) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+
+	var staff []StaffConfig
+	for _, item := range splitCommaSeparated(value) {
+		id, role, _ := strings.Cut(item, ":")
+		parsed, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+		if err != nil {
+			r.add("%s: expected <telegram_id>:<role>, got %q", key, item)
+			continue
+		}
+		staff = append(staff, StaffConfig{ID: parsed, Role: strings.TrimSpace(role)})
+	}
+	*dst = staff
+}
+
+var questionKeyPattern = regexp.MustCompile(`^CAPTCHA_Q(\d+)_(QUESTION|ANSWER)=`)
+
+// envQuestions reads every CAPTCHA_Q<n>_QUESTION and CAPTCHA_Q<n>_ANSWER pair
+// in the order of n. Gaps in the numbering are allowed, a half of a pair is not.
+// An answer such as "Washington|Washington DC" lists every accepted answer
+func envQuestions(r *report, dst *[]TextQuestion) {
+	pairs := make(map[int]*TextQuestion)
+	for _, env := range os.Environ() {
+		m := questionKeyPattern.FindStringSubmatch(env)
+		if m == nil {
+			continue
+		}
+
+		n, _ := strconv.Atoi(m[1])
+		if pairs[n] == nil {
+			pairs[n] = &TextQuestion{}
+		}
+		value := strings.TrimSpace(env[len(m[0]):])
+		if m[2] == "QUESTION" {
+			pairs[n].Question = value
+		} else {
+			pairs[n].Answer = value
+		}
+	}
+	if len(pairs) == 0 {
+		return
+	}
+
+	numbers := make([]int, 0, len(pairs))
+	for n := range pairs {
+		numbers = append(numbers, n)
+	}
+	slices.Sort(numbers)
+
+	var questions []TextQuestion
+	for _, n := range numbers {
+		q := pairs[n]
+		switch {
+		case q.Question == "" && q.Answer == "":
+			continue
+		case q.Question == "":
+			r.add("CAPTCHA_Q%d_QUESTION: missing, CAPTCHA_Q%d_ANSWER is set", n, n)
+		case q.Answer == "":
+			r.add("CAPTCHA_Q%d_ANSWER: missing, CAPTCHA_Q%d_QUESTION is set", n, n)
+		default:
+			answers := strings.Split(q.Answer, "|")
+			q.Answer = strings.TrimSpace(answers[0])
+			for _, alternative := range answers[1:] {
+				if alternative = strings.TrimSpace(alternative); alternative != "" {
+					q.Alternatives = append(q.Alternatives, alternative)
+				}
+			}
+			questions = append(questions, *q)
+		}
+	}
+	*dst = questions
+}
+
+func splitCommaSeparated(s string) []string {
+	parts := strings.Split(s, ",")
+	result := make([]string, 0, len(parts))
+
+	for _, part := range parts {
+		trimmed := strings.TrimSpace(part)
+		if trimmed != "" {
+			result = append(result, trimmed)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Read when CONFIG_FILE is not set and the file exists
+const defaultConfigFile = "config.yaml"
+
+const day = 24 * time.Hour
+
+// configFile returns the path of the config file, empty when there is none
+func configFile() string {
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return path
+	}
+	if _, err := os.Stat(defaultConfigFile); err == nil {
+		return defaultConfigFile
+	}
+	return ""
+}
+
+// loadFile reads the YAML config file over the defaults. Keys missing in the
+// file keep their defaults, unknown keys are reported as typos
+func loadFile(path string, cfg *Config, r *report) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		r.add("%s: %v", path, err)
+		return
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(cfg)
+	var typeErr *yaml.TypeError
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+	case errors.As(err, &typeErr):
+		for _, problem := range typeErr.Errors {
+			r.add("%s: %s", path, problem)
+		}
+	default:
+		r.add("%s: %s", path, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+}
+
+// UnmarshalYAML reads the retention periods in days, like the environment
+// variables. Keys missing in the file keep their defaults
+func (c *RetentionConfig) UnmarshalYAML(node *yaml.Node) error {
+	var file struct {
+		Interval           *time.Duration `yaml:"interval"`
+		MessagesDays       *int           `yaml:"messages_days"`
+		InactiveUsersDays  *int           `yaml:"inactive_users_days"`
+		StaleCaptchasDays  *int           `yaml:"stale_captchas_days"`
+		CompactBlockedDays *int           `yaml:"compact_blocked_days"`
+	}
+	if err := node.Decode(&file); err != nil {
+		return err
+	}
+
+	if file.Interval != nil {
+		c.Interval = *file.Interval
+	}
+	for _, period := range []struct {
+		days *int
+		dst  *time.Duration
+		key  string
+	}{
+		{file.MessagesDays, &c.Messages, "messages_days"},
+		{file.InactiveUsersDays, &c.InactiveUsers, "inactive_users_days"},
+		{file.StaleCaptchasDays, &c.StaleCaptchas, "stale_captchas_days"},
+		{file.CompactBlockedDays, &c.CompactBlocked, "compact_blocked_days"},
+	} {
+		if period.days == nil {
+			continue
+		}
+		if *period.days < 0 {
+			return fmt.Errorf("line %d: retention.%s must be 0 or more days", node.Line, period.key)
+		}
+		*period.dst = time.Duration(*period.days) * day
+	}
+
+	return nil
+}
+
+// report collects every problem of the configuration, so that all of them
+// can be fixed at once
+type report struct {
+	problems []string
+}
+
+func (r *report) add(format string, args ...any) {
+	r.problems = append(r.problems, fmt.Sprintf(format, args...))
+}
+
+func (r *report) err() error {
+	if len(r.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: r.problems}
+}
+
+// ValidationError lists every invalid or missing setting
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration, %d problem(s):", len(e.Problems))
+	for _, problem := range e.Problems {
+		b.WriteString("\n  - ")
+		b.WriteString(problem)
+	}
+	return b.String()
+}
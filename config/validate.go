@@ -0,0 +1,206 @@
+package config
+
+import (
+	"log/slog"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"telegram-gatekeeper/i18n"
+)
+
+var (
+	botTokenPattern = regexp.MustCompile(`^\d+:[\w-]+$`)
+
+	// Characters Telegram allows in a webhook secret token
+	secretTokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+)
+
+var (
+	captchaTypes  = []string{"random", "math", "text", "button", "image"}
+	mathOperators = []string{"+", "-", "×", "÷"}
+	staffRoles    = []string{"owner", "moderator", "viewer"}
+	difficulties  = []string{"easy", "medium", "hard"}
+	answerSteps   = []string{"nfkc", "diacritics", "punctuation", "numbers", "none"}
+)
+
+// validate reports every invalid or missing setting. Problems are named by
+// the key of the config file and the environment variable
+func validate(cfg *Config, r *report) {
+	switch {
+	case cfg.BotToken == "":
+		r.add("bot_token / BOT_TOKEN: required")
+	case !botTokenPattern.MatchString(cfg.BotToken):
+		r.add("bot_token / BOT_TOKEN: expected the token from @BotFather, such as 123456:ABC-DEF")
+	}
+	if cfg.AdminID <= 0 {
+		r.add("admin_id / ADMIN_ID: required, the Telegram ID of the owner")
+	}
+
+	switch cfg.UpdateMode {
+	case "polling":
+	case "webhook":
+		validateWebhook(cfg.Webhook, r)
+	default:
+		r.add("update_mode / UPDATE_MODE: expected polling or webhook, got %q", cfg.UpdateMode)
+	}
+
+	switch cfg.Storage {
+	case "memory":
+	case "mongo":
+		if cfg.MongoURI == "" {
+			r.add("mongo_uri / MONGO_URI: required with mongo storage")
+		}
+		if cfg.MongoDBName == "" {
+			r.add("mongo_db_name / MONGO_DB_NAME: required with mongo storage")
+		}
+	default:
+		r.add("storage / STORAGE: expected mongo or memory, got %q", cfg.Storage)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		r.add("log_level / LOG_LEVEL: expected debug, info, warn or error, got %q", cfg.LogLevel)
+	}
+	if format := strings.ToLower(cfg.LogFormat); format != "text" && format != "json" {
+		r.add("log_format / LOG_FORMAT: expected text or json, got %q", cfg.LogFormat)
+	}
+
+	if cfg.ShutdownTimeout <= 0 {
+		r.add("shutdown_timeout / SHUTDOWN_TIMEOUT: must be positive")
+	}
+	if cfg.Workers < 1 {
+		r.add("workers / WORKERS: must be 1 or more")
+	}
+	if cfg.QueueSize < 1 {
+		r.add("queue_size / QUEUE_SIZE: must be 1 or more")
+	}
+	if cfg.CallbackTTL <= 0 {
+		r.add("callback_ttl / CALLBACK_TTL: must be positive")
+	}
+	if cfg.MetricsListen != "" {
+		if _, _, err := net.SplitHostPort(cfg.MetricsListen); err != nil {
+			r.add("metrics_listen / METRICS_LISTEN: expected an address such as :9090, got %q", cfg.MetricsListen)
+		}
+	}
+	catalog, err := i18n.Load(cfg.DefaultLanguage)
+	if err != nil {
+		r.add("default_language / DEFAULT_LANGUAGE: %v", err)
+	}
+
+	validateDefaults(cfg, r)
+	validateCaptcha(cfg.Captcha, catalog, r)
+
+	if action := cfg.Group.FailAction; action != "kick" && action != "ban" {
+		r.add("group.fail_action / GROUP_FAIL_ACTION: expected kick or ban, got %q", action)
+	}
+	if cfg.Retention.Interval <= 0 {
+		r.add("retention.interval / RETENTION_INTERVAL: must be positive")
+	}
+
+	validateStaff(cfg, r)
+}
+
+func validateWebhook(webhook WebhookConfig, r *report) {
+	if webhook.URL == "" {
+		r.add("webhook.url / WEBHOOK_URL: required in webhook mode")
+	}
+	if !secretTokenPattern.MatchString(webhook.SecretToken) {
+		r.add("webhook.secret / WEBHOOK_SECRET: must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+	}
+	if (webhook.CertFile == "") != (webhook.KeyFile == "") {
+		r.add("webhook.tls_cert / WEBHOOK_TLS_CERT and webhook.tls_key / WEBHOOK_TLS_KEY: must be set together")
+	}
+	if webhook.UploadCert && webhook.CertFile == "" {
+		r.add("webhook.upload_cert / WEBHOOK_UPLOAD_CERT: needs webhook.tls_cert / WEBHOOK_TLS_CERT")
+	}
+	if webhook.MaxConnections < 0 || webhook.MaxConnections > 100 {
+		r.add("webhook.max_connections / WEBHOOK_MAX_CONNECTIONS: must be 1-100, or 0 for the Telegram default")
+	}
+}
+
+func validateDefaults(cfg *Config, r *report) {
+	defaults := cfg.Defaults
+
+	if defaults.MaxAttempts < 1 || defaults.MaxAttempts > 20 {
+		r.add("defaults.max_attempts / MAX_ATTEMPTS: must be 1-20, got %d", defaults.MaxAttempts)
+	}
+	if defaults.CaptchaTTL < 10*time.Second || defaults.CaptchaTTL > 24*time.Hour {
+		r.add("defaults.captcha_ttl / CAPTCHA_TTL: must be 10s-24h, got %s", defaults.CaptchaTTL)
+	}
+	if defaults.BlockDuration < 0 {
+		r.add("defaults.block_duration / BLOCK_DURATION: must be 0 (forever) or positive")
+	}
+
+	// Text questions may come from the question bank, it is not known here
+	switch defaults.CaptchaType {
+	case "button":
+		if len(cfg.Captcha.Colors) < 4 {
+			r.add("defaults.captcha_type / CAPTCHA_TYPE: button captchas need 4 or more captcha.colors / CAPTCHA_COLORS")
+		}
+	default:
+		if !slices.Contains(captchaTypes, defaults.CaptchaType) {
+			r.add("defaults.captcha_type / CAPTCHA_TYPE: expected one of %s, got %q", strings.Join(captchaTypes, ", "), defaults.CaptchaType)
+		}
+	}
+}
+
+func validateCaptcha(captcha CaptchaConfig, catalog *i18n.Catalog, r *report) {
+	for i, q := range captcha.TextQuestions {
+		if strings.TrimSpace(q.Question) == "" {
+			r.add("captcha.questions[%d].question: required", i)
+		}
+		if strings.TrimSpace(q.Answer) == "" {
+			r.add("captcha.questions[%d].answer: required", i)
+		}
+		if q.Language != "" && catalog != nil && !catalog.Has(strings.ToLower(q.Language)) {
+			r.add("captcha.questions[%d].language: expected one of %s, got %q", i, strings.Join(catalog.Locales(), ", "), q.Language)
+		}
+		if q.Difficulty != "" && !slices.Contains(difficulties, q.Difficulty) {
+			r.add("captcha.questions[%d].difficulty: expected one of %s, got %q", i, strings.Join(difficulties, ", "), q.Difficulty)
+		}
+		for _, step := range q.Normalize {
+			if !slices.Contains(answerSteps, strings.ToLower(step)) {
+				r.add("captcha.questions[%d].normalize: expected %s, got %q", i, strings.Join(answerSteps, ", "), step)
+			}
+		}
+		if q.Tolerance < 0 || q.Tolerance > 3 {
+			r.add("captcha.questions[%d].tolerance: expected 0-3 typos, got %d", i, q.Tolerance)
+		}
+	}
+
+	// Without colors button captchas fall back to math ones, but a short list is a mistake
+	if n := len(captcha.Colors); n > 0 && n < 4 {
+		r.add("captcha.colors / CAPTCHA_COLORS: needs 4 or more colors, got %d", n)
+	}
+	for _, op := range captcha.MathOperators {
+		if !slices.Contains(mathOperators, op) {
+			r.add("captcha.math_operators / CAPTCHA_MATH_OPS: expected %s, got %q", strings.Join(mathOperators, " "), op)
+		}
+	}
+	if captcha.SweepInterval <= 0 {
+		r.add("captcha.sweep_interval / CAPTCHA_SWEEP_INTERVAL: must be positive")
+	}
+}
+
+func validateStaff(cfg *Config, r *report) {
+	seen := make(map[int64]bool)
+	for i, member := range cfg.Staff {
+		if member.ID <= 0 {
+			r.add("staff[%d].id / STAFF: required, a Telegram ID", i)
+			continue
+		}
+		if !slices.Contains(staffRoles, member.Role) {
+			r.add("staff[%d].role / STAFF: expected one of %s, got %q", i, strings.Join(staffRoles, ", "), member.Role)
+		}
+		if member.ID == cfg.AdminID {
+			r.add("staff[%d].id / STAFF: %d is admin_id, the owner", i, member.ID)
+		}
+		if seen[member.ID] {
+			r.add("staff[%d].id / STAFF: %d is listed twice", i, member.ID)
+		}
+		seen[member.ID] = true
+	}
+}
@@ -0,0 +1,901 @@
+package database
+
+import (
+    "maps"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type relayKey struct {
+    adminChatID    int64
+    adminMessageID int
+}
+
+// MemoryStorage keeps all data in memory. It is meant for tests and for running
+// the bot without MongoDB; everything is lost on restart
+type MemoryStorage struct {
+    mu        sync.Mutex
+    users     map[int64]*User
+    relays    map[relayKey]RelayLink
+    messages  []Message
+    settings  map[int64]AdminSettings
+    blacklist []BlacklistEntry
+    staff     []StaffMember
+    cards     []AdminCard
+    stats     []CaptchaStat
+    questions []Question
+    groups    map[groupKey]GroupCaptcha
+}
+
+type groupKey struct {
+    chatID     int64
+    telegramID int64
+}
+
+func NewMemoryStorage() *MemoryStorage {
+    return &MemoryStorage{
+        users:    make(map[int64]*User),
+        relays:   make(map[relayKey]RelayLink),
+        settings: make(map[int64]AdminSettings),
+        groups:   make(map[groupKey]GroupCaptcha),
+    }
+}
+
+func (m *MemoryStorage) Disconnect() {}
+
+// copyUser returns a copy that does not share pointers with the stored user
+func copyUser(user *User) *User {
+    c := *user
+    if user.VerifiedAt != nil {
+        t := *user.VerifiedAt
+        c.VerifiedAt = &t
+    }
+    if user.BlockedUntil != nil {
+        t := *user.BlockedUntil
+        c.BlockedUntil = &t
+    }
+    if user.BlockedAt != nil {
+        t := *user.BlockedAt
+        c.BlockedAt = &t
+    }
+    if user.CaptchaData != nil {
+        captcha := *user.CaptchaData
+        captcha.Options = slices.Clone(user.CaptchaData.Options)
+        captcha.Alternatives = slices.Clone(user.CaptchaData.Alternatives)
+        if user.CaptchaData.Check != nil {
+            check := *user.CaptchaData.Check
+            check.Steps = slices.Clone(check.Steps)
+            captcha.Check = &check
+        }
+        c.CaptchaData = &captcha
+    }
+    return &c
+}
+
+// update applies fn to the stored user, missing users are ignored like in MongoDB
+func (m *MemoryStorage) update(telegramID int64, fn func(user *User)) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if user, ok := m.users[telegramID]; ok {
+        fn(user)
+        user.UpdatedAt = time.Now()
+    }
+}
+
+// Users
+func (m *MemoryStorage) GetOrCreateUser(telegramID int64, username, firstName, lastName, languageCode string, isBot bool) (*User, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if user, ok := m.users[telegramID]; ok {
+        // Returning the data as it was before the update, like MongoDB does
+        found := copyUser(user)
+
+        changed := false
+        if username != user.Username && username != "" {
+            user.Username = username
+            changed = true
+        }
+        if firstName != user.FirstName {
+            user.FirstName = firstName
+            changed = true
+        }
+        if lastName != user.LastName {
+            user.LastName = lastName
+            changed = true
+        }
+        if languageCode != user.LanguageCode && languageCode != "" {
+            user.LanguageCode = languageCode
+            changed = true
+        }
+        if changed {
+            user.UpdatedAt = time.Now()
+        }
+
+        return found, nil
+    }
+
+    now := time.Now()
+    user := &User{
+        ID:           primitive.NewObjectID(),
+        TelegramID:   telegramID,
+        Username:     username,
+        FirstName:    firstName,
+        LastName:     lastName,
+        LanguageCode: languageCode,
+        IsBot:        isBot,
+        CreatedAt:    now,
+        UpdatedAt:    now,
+    }
+    m.users[telegramID] = user
+
+    return copyUser(user), nil
+}
+
+func (m *MemoryStorage) GetUserByTelegramID(telegramID int64) (*User, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    user, ok := m.users[telegramID]
+    if !ok {
+        return nil, ErrNotFound
+    }
+
+    return copyUser(user), nil
+}
+
+func (m *MemoryStorage) UpdateUserVerification(telegramID int64, isVerified bool) error {
+    m.update(telegramID, func(user *User) {
+        user.IsVerified = isVerified
+        if isVerified {
+            now := time.Now()
+            user.VerifiedAt = &now
+        }
+    })
+    return nil
+}
+
+func (m *MemoryStorage) SetUserLanguage(telegramID int64, language string) error {
+    m.update(telegramID, func(user *User) {
+        user.Language = language
+    })
+    return nil
+}
+
+func (m *MemoryStorage) IncrementAttempts(telegramID int64) error {
+    m.update(telegramID, func(user *User) {
+        user.VerificationAttempts++
+        user.LastAttemptAt = time.Now()
+    })
+    return nil
+}
+
+func (m *MemoryStorage) ResetAttempts(telegramID int64) error {
+    m.update(telegramID, func(user *User) {
+        user.VerificationAttempts = 0
+    })
+    return nil
+}
+
+// Blocks
+func (m *MemoryStorage) BlockUser(telegramID int64, until *time.Time, reason string) error {
+    m.update(telegramID, func(user *User) {
+        now := time.Now()
+        user.IsBlocked = true
+        user.IsVerified = false
+        user.BlockedAt = &now
+        user.BlockReason = reason
+        user.BlockedUntil = nil
+        if until != nil {
+            t := *until
+            user.BlockedUntil = &t
+        }
+    })
+    return nil
+}
+
+func (m *MemoryStorage) UnblockUser(telegramID int64) error {
+    m.update(telegramID, unblock)
+    return nil
+}
+
+func (m *MemoryStorage) UnblockExpired(now time.Time) ([]User, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    var unblocked []User
+    for _, user := range m.users {
+        if !user.IsBlocked || user.BlockedUntil == nil || user.BlockedUntil.After(now) {
+            continue
+        }
+
+        unblocked = append(unblocked, *copyUser(user))
+        unblock(user)
+        user.UpdatedAt = time.Now()
+    }
+
+    return unblocked, nil
+}
+
+func unblock(user *User) {
+    user.IsBlocked = false
+    user.VerificationAttempts = 0
+    user.BlockedUntil = nil
+    user.BlockReason = ""
+    user.CaptchaData = nil
+}
+
+// Captchas
+func (m *MemoryStorage) SaveCaptcha(telegramID int64, captcha *Captcha) error {
+    m.update(telegramID, func(user *User) {
+        if captcha == nil {
+            user.CaptchaData = nil
+            return
+        }
+        c := *captcha
+        c.Options = slices.Clone(captcha.Options)
+        user.CaptchaData = &c
+    })
+    return nil
+}
+
+func (m *MemoryStorage) ClearCaptcha(telegramID int64) error {
+    m.update(telegramID, func(user *User) {
+        user.CaptchaData = nil
+    })
+    return nil
+}
+
+func (m *MemoryStorage) FindExpiredCaptchas(now time.Time, limit int) ([]User, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    var expired []User
+    for _, user := range m.users {
+        if user.CaptchaData != nil && !user.CaptchaData.ExpiresAt.After(now) {
+            expired = append(expired, *copyUser(user))
+        }
+    }
+
+    sort.Slice(expired, func(i, j int) bool {
+        return expired[i].CaptchaData.ExpiresAt.Before(expired[j].CaptchaData.ExpiresAt)
+    })
+
+    if len(expired) > limit {
+        expired = expired[:limit]
+    }
+
+    return expired, nil
+}
+
+func (m *MemoryStorage) ExpireCaptcha(telegramID int64, expiresAt time.Time, countAttempt bool) (*User, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    user, ok := m.users[telegramID]
+    if !ok || user.CaptchaData == nil || !user.CaptchaData.ExpiresAt.Equal(expiresAt) {
+        return nil, ErrNotFound
+    }
+
+    now := time.Now()
+    user.CaptchaData = nil
+    user.UpdatedAt = now
+    if countAttempt {
+        user.VerificationAttempts++
+        user.LastAttemptAt = now
+    }
+
+    return copyUser(user), nil
+}
+
+func (m *MemoryStorage) AnswerCaptcha(telegramID int64, nonce string, correct bool) (*User, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    now := time.Now()
+    user, ok := m.users[telegramID]
+    if !ok || user.CaptchaData == nil || user.CaptchaData.Nonce != nonce || !user.CaptchaData.ExpiresAt.After(now) {
+        return nil, ErrNotFound
+    }
+
+    user.CaptchaData = nil
+    user.UpdatedAt = now
+    if correct {
+        user.IsVerified = true
+        user.VerifiedAt = &now
+    } else {
+        user.VerificationAttempts++
+        user.LastAttemptAt = now
+    }
+
+    return copyUser(user), nil
+}
+
+// Group captchas
+func copyGroupCaptcha(captcha GroupCaptcha) *GroupCaptcha {
+    captcha.Captcha.Options = slices.Clone(captcha.Captcha.Options)
+    return &captcha
+}
+
+func (m *MemoryStorage) SaveGroupCaptcha(captcha *GroupCaptcha) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.groups[groupKey{captcha.ChatID, captcha.TelegramID}] = *copyGroupCaptcha(*captcha)
+    return nil
+}
+
+func (m *MemoryStorage) GetGroupCaptcha(chatID, telegramID int64) (*GroupCaptcha, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    captcha, ok := m.groups[groupKey{chatID, telegramID}]
+    if !ok {
+        return nil, ErrNotFound
+    }
+    return copyGroupCaptcha(captcha), nil
+}
+
+func (m *MemoryStorage) TakeGroupCaptcha(chatID, telegramID int64, nonce string) (*GroupCaptcha, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    key := groupKey{chatID, telegramID}
+    captcha, ok := m.groups[key]
+    if !ok || captcha.Captcha.Nonce != nonce || !captcha.Captcha.ExpiresAt.After(time.Now()) {
+        return nil, ErrNotFound
+    }
+
+    delete(m.groups, key)
+    return copyGroupCaptcha(captcha), nil
+}
+
+func (m *MemoryStorage) FindExpiredGroupCaptchas(now time.Time, limit int) ([]GroupCaptcha, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    var expired []GroupCaptcha
+    for _, captcha := range m.groups {
+        if !captcha.Captcha.ExpiresAt.After(now) {
+            expired = append(expired, *copyGroupCaptcha(captcha))
+        }
+    }
+
+    sort.Slice(expired, func(i, j int) bool {
+        return expired[i].Captcha.ExpiresAt.Before(expired[j].Captcha.ExpiresAt)
+    })
+
+    if len(expired) > limit {
+        expired = expired[:limit]
+    }
+
+    return expired, nil
+}
+
+func (m *MemoryStorage) ExpireGroupCaptcha(chatID, telegramID int64, expiresAt time.Time) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    key := groupKey{chatID, telegramID}
+    captcha, ok := m.groups[key]
+    if !ok || !captcha.Captcha.ExpiresAt.Equal(expiresAt) {
+        return ErrNotFound
+    }
+
+    delete(m.groups, key)
+    return nil
+}
+
+// Messages
+func (m *MemoryStorage) SaveRelayLink(adminChatID int64, adminMessageID int, userID int64) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.relays[relayKey{adminChatID, adminMessageID}] = RelayLink{
+        ID:             primitive.NewObjectID(),
+        AdminChatID:    adminChatID,
+        AdminMessageID: adminMessageID,
+        UserID:         userID,
+        CreatedAt:      time.Now(),
+    }
+    return nil
+}
+
+func (m *MemoryStorage) GetRelayLink(adminChatID int64, adminMessageID int) (*RelayLink, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    link, ok := m.relays[relayKey{adminChatID, adminMessageID}]
+    if !ok {
+        return nil, ErrNotFound
+    }
+    return &link, nil
+}
+
+func (m *MemoryStorage) SaveMessage(message *Message) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if message.CreatedAt.IsZero() {
+        message.CreatedAt = time.Now()
+    }
+    message.ID = primitive.NewObjectID()
+
+    stored := *message
+    stored.ForwardedTo = slices.Clone(message.ForwardedTo)
+    m.messages = append(m.messages, stored)
+
+    return nil
+}
+
+func (m *MemoryStorage) GetUserMessages(userID primitive.ObjectID, page, pageSize int) ([]Message, int64, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    // Newest first
+    var messages []Message
+    for i := len(m.messages) - 1; i >= 0; i-- {
+        if m.messages[i].UserID == userID {
+            messages = append(messages, m.messages[i])
+        }
+    }
+    total := int64(len(messages))
+
+    start := min((page-1)*pageSize, len(messages))
+    end := min(start+pageSize, len(messages))
+
+    return slices.Clone(messages[start:end]), total, nil
+}
+
+// Settings
+func (m *MemoryStorage) GetSettings(adminID int64) (*AdminSettings, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    settings, ok := m.settings[adminID]
+    if !ok {
+        return nil, ErrNotFound
+    }
+    settings.Templates = maps.Clone(settings.Templates)
+    return &settings, nil
+}
+
+// SetSetting goes through BSON, so that keys are understood the way MongoDB does
+func (m *MemoryStorage) SetSetting(adminID int64, key string, value any) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    now := time.Now()
+    settings, ok := m.settings[adminID]
+    if !ok {
+        settings = AdminSettings{AdminID: adminID, CreatedAt: now}
+    }
+
+    data, err := bson.Marshal(settings)
+    if err != nil {
+        return err
+    }
+    var doc bson.M
+    if err := bson.Unmarshal(data, &doc); err != nil {
+        return err
+    }
+
+    // "templates.welcome" is the welcome key of the templates document
+    parent := doc
+    path := strings.Split(key, ".")
+    for _, name := range path[:len(path)-1] {
+        child, ok := parent[name].(bson.M)
+        if !ok {
+            child = bson.M{}
+            parent[name] = child
+        }
+        parent = child
+    }
+    if value == nil {
+        delete(parent, path[len(path)-1])
+    } else {
+        parent[path[len(path)-1]] = value
+    }
+    doc["updated_at"] = now
+
+    if data, err = bson.Marshal(doc); err != nil {
+        return err
+    }
+    var updated AdminSettings
+    if err := bson.Unmarshal(data, &updated); err != nil {
+        return err
+    }
+    m.settings[adminID] = updated
+    return nil
+}
+
+// Blacklist
+func (m *MemoryStorage) AddBlacklistEntry(entry *BlacklistEntry) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for i := range m.blacklist {
+        existing := &m.blacklist[i]
+        if existing.Kind == entry.Kind && existing.Value == entry.Value {
+            existing.TelegramID = entry.TelegramID
+            existing.Reason = entry.Reason
+            existing.AddedBy = entry.AddedBy
+            return nil
+        }
+    }
+
+    stored := *entry
+    stored.ID = primitive.NewObjectID()
+    if stored.CreatedAt.IsZero() {
+        stored.CreatedAt = time.Now()
+    }
+    m.blacklist = append(m.blacklist, stored)
+
+    return nil
+}
+
+func (m *MemoryStorage) RemoveBlacklistEntry(kind, value string) (bool, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    before := len(m.blacklist)
+    m.blacklist = slices.DeleteFunc(m.blacklist, func(e BlacklistEntry) bool {
+        return e.Kind == kind && e.Value == value
+    })
+
+    return len(m.blacklist) < before, nil
+}
+
+func (m *MemoryStorage) GetBlacklist() ([]BlacklistEntry, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    return slices.Clone(m.blacklist), nil
+}
+
+// Staff
+func (m *MemoryStorage) GetStaff() ([]StaffMember, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    return slices.Clone(m.staff), nil
+}
+
+func (m *MemoryStorage) SaveStaffMember(member *StaffMember) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for i := range m.staff {
+        existing := &m.staff[i]
+        if existing.TelegramID == member.TelegramID {
+            existing.Role = member.Role
+            existing.Subscribed = member.Subscribed
+            existing.AddedBy = member.AddedBy
+            return nil
+        }
+    }
+
+    stored := *member
+    stored.ID = primitive.NewObjectID()
+    if stored.CreatedAt.IsZero() {
+        stored.CreatedAt = time.Now()
+    }
+    m.staff = append(m.staff, stored)
+
+    return nil
+}
+
+func (m *MemoryStorage) RemoveStaffMember(telegramID int64) (bool, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    before := len(m.staff)
+    m.staff = slices.DeleteFunc(m.staff, func(s StaffMember) bool {
+        return s.TelegramID == telegramID
+    })
+
+    return len(m.staff) < before, nil
+}
+
+// Admin cards
+func (m *MemoryStorage) SaveAdminCard(card *AdminCard) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    card.ID = primitive.NewObjectID()
+    if card.CreatedAt.IsZero() {
+        card.CreatedAt = time.Now()
+    }
+    m.cards = append(m.cards, *card)
+
+    return nil
+}
+
+func (m *MemoryStorage) TakeAdminCards(userID int64) ([]AdminCard, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    var taken []AdminCard
+    m.cards = slices.DeleteFunc(m.cards, func(c AdminCard) bool {
+        if c.UserID == userID {
+            taken = append(taken, c)
+            return true
+        }
+        return false
+    })
+
+    return taken, nil
+}
+
+// Question bank
+func (m *MemoryStorage) AddQuestions(questions []Question) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for i := range questions {
+        if questions[i].ID.IsZero() {
+            questions[i].ID = primitive.NewObjectID()
+        }
+        if questions[i].CreatedAt.IsZero() {
+            questions[i].CreatedAt = time.Now()
+        }
+        m.questions = append(m.questions, questions[i])
+    }
+
+    return nil
+}
+
+func (m *MemoryStorage) GetQuestions() ([]Question, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    return slices.Clone(m.questions), nil
+}
+
+func (m *MemoryStorage) SetQuestionActive(id primitive.ObjectID, active bool) (bool, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for i := range m.questions {
+        if m.questions[i].ID == id {
+            m.questions[i].Active = active
+            return true, nil
+        }
+    }
+
+    return false, nil
+}
+
+func (m *MemoryStorage) SetQuestionCheck(id primitive.ObjectID, check *AnswerCheck) (bool, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for i := range m.questions {
+        if m.questions[i].ID == id {
+            m.questions[i].Check = check
+            return true, nil
+        }
+    }
+
+    return false, nil
+}
+
+func (m *MemoryStorage) DeleteQuestion(id primitive.ObjectID) (bool, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    before := len(m.questions)
+    m.questions = slices.DeleteFunc(m.questions, func(q Question) bool {
+        return q.ID == id
+    })
+
+    return len(m.questions) < before, nil
+}
+
+// Statistics
+func (m *MemoryStorage) RecordCaptchaIssued(stat *CaptchaStat) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    stored := *stat
+    stored.ID = primitive.NewObjectID()
+    m.stats = append(m.stats, stored)
+
+    return nil
+}
+
+func (m *MemoryStorage) RecordCaptchaOutcome(nonce, outcome string, at time.Time) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for i := range m.stats {
+        stat := &m.stats[i]
+        if stat.Nonce == nonce && stat.Outcome == "" {
+            stat.Outcome = outcome
+            stat.FinishedAt = &at
+            stat.DurationMS = at.Sub(stat.IssuedAt).Milliseconds()
+        }
+    }
+
+    return nil
+}
+
+func (m *MemoryStorage) GetStats(since time.Time) (*Stats, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    stats := &Stats{}
+
+    for _, user := range m.users {
+        if !user.CreatedAt.Before(since) {
+            stats.NewUsers++
+        }
+        if user.BlockedAt != nil && !user.BlockedAt.Before(since) {
+            stats.Blocks++
+        }
+    }
+
+    byType := make(map[string]*CaptchaTypeStats)
+    var durations []int64
+    for _, stat := range m.stats {
+        if stat.IssuedAt.Before(since) {
+            continue
+        }
+
+        t, ok := byType[stat.Type]
+        if !ok {
+            t = &CaptchaTypeStats{Type: stat.Type}
+            byType[stat.Type] = t
+        }
+        t.Issued++
+
+        switch stat.Outcome {
+        case OutcomePassed:
+            t.Passed++
+            durations = append(durations, stat.DurationMS)
+        case OutcomeFailed:
+            t.Failed++
+        case OutcomeTimeout:
+            t.Timeout++
+        }
+    }
+
+    for _, t := range byType {
+        stats.Captchas = append(stats.Captchas, *t)
+    }
+    sort.Slice(stats.Captchas, func(i, j int) bool {
+        return stats.Captchas[i].Type < stats.Captchas[j].Type
+    })
+
+    if len(durations) > 0 {
+        slices.Sort(durations)
+        stats.MedianSolveTime = time.Duration(durations[len(durations)/2]) * time.Millisecond
+    }
+
+    for _, message := range m.messages {
+        if message.CreatedAt.Before(since) {
+            continue
+        }
+        if message.FromAdmin {
+            stats.Replies++
+            continue
+        }
+        stats.Received++
+        if message.IsForwarded {
+            stats.Forwarded++
+        }
+    }
+
+    return stats, nil
+}
+
+// Retention
+func (m *MemoryStorage) DeleteMessagesBefore(before time.Time) (int64, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    n := len(m.messages)
+    m.messages = slices.DeleteFunc(m.messages, func(message Message) bool {
+        return message.CreatedAt.Before(before)
+    })
+
+    return int64(n - len(m.messages)), nil
+}
+
+func (m *MemoryStorage) DeleteInactiveUsers(inactiveSince time.Time) (int64, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    var deleted int64
+    for id, user := range m.users {
+        if user.IsVerified || user.VerifiedAt != nil || user.IsBlocked {
+            continue
+        }
+        if !user.CreatedAt.Before(inactiveSince) || !user.UpdatedAt.Before(inactiveSince) ||
+            !user.LastAttemptAt.Before(inactiveSince) {
+            continue
+        }
+
+        delete(m.users, id)
+        deleted++
+    }
+
+    return deleted, nil
+}
+
+func (m *MemoryStorage) ClearStaleCaptchas(expiredBefore time.Time) (int64, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    var cleared int64
+    for _, user := range m.users {
+        if user.CaptchaData != nil && user.CaptchaData.ExpiresAt.Before(expiredBefore) {
+            user.CaptchaData = nil
+            cleared++
+        }
+    }
+    for key, captcha := range m.groups {
+        if captcha.Captcha.ExpiresAt.Before(expiredBefore) {
+            delete(m.groups, key)
+            cleared++
+        }
+    }
+
+    return cleared, nil
+}
+
+func (m *MemoryStorage) CompactBlockedUsers(blockedBefore time.Time, addedBy int64) (int64, error) {
+    m.mu.Lock()
+    var candidates []*User
+    for _, user := range m.users {
+        if !user.IsBlocked || user.BlockedUntil != nil {
+            continue
+        }
+
+        blockedAt := user.UpdatedAt
+        if user.BlockedAt != nil {
+            blockedAt = *user.BlockedAt
+        }
+        if blockedAt.Before(blockedBefore) {
+            candidates = append(candidates, copyUser(user))
+        }
+    }
+    m.mu.Unlock()
+
+    var compacted int64
+    for _, user := range candidates {
+        entry := compactedEntry(user, addedBy)
+
+        m.mu.Lock()
+        // A user unblocked in the meantime is kept
+        current, ok := m.users[user.TelegramID]
+        if !ok || !current.IsBlocked || current.BlockedUntil != nil {
+            m.mu.Unlock()
+            continue
+        }
+
+        // An existing entry, e.g. a /ban of the admin, is kept as it is
+        exists := slices.ContainsFunc(m.blacklist, func(e BlacklistEntry) bool {
+            return e.Kind == entry.Kind && e.Value == entry.Value
+        })
+        if !exists {
+            stored := *entry
+            stored.ID = primitive.NewObjectID()
+            stored.CreatedAt = time.Now()
+            m.blacklist = append(m.blacklist, stored)
+        }
+        delete(m.users, user.TelegramID)
+        m.mu.Unlock()
+
+        compacted++
+    }
+
+    return compacted, nil
+}
@@ -1,7 +1,9 @@
 package database
 
 import (
+    "strconv"
     "time"
+
     "go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -12,10 +14,14 @@ type User struct {
     Username     string             `bson:"username,omitempty"`
     FirstName    string             `bson:"first_name"`
     LastName     string             `bson:"last_name,omitempty"`
-    LanguageCode string             `bson:"language_code,omitempty"`
+    LanguageCode string             `bson:"language_code,omitempty"` // Reported by Telegram
+    Language     string             `bson:"language,omitempty"`      // Chosen with /language
     IsBot        bool               `bson:"is_bot"`
     IsVerified   bool               `bson:"is_verified"`
     IsBlocked    bool               `bson:"is_blocked"`
+    BlockedUntil *time.Time         `bson:"blocked_until,omitempty"` // nil - blocked forever
+    BlockReason  string             `bson:"block_reason,omitempty"`
+    BlockedAt    *time.Time         `bson:"blocked_at,omitempty"` // Last time the user was blocked
     VerifiedAt   *time.Time         `bson:"verified_at,omitempty"`
     CreatedAt    time.Time          `bson:"created_at"`
     UpdatedAt    time.Time          `bson:"updated_at"`
@@ -28,12 +34,43 @@ type User struct {
 
 // Captcha model
 type Captcha struct {
-    Type        string    `bson:"type"` // "math", "text", "button"
+    Type        string    `bson:"type"` // "math", "text", "button", "image"
     Question    string    `bson:"question"`
     Answer      string    `bson:"answer"`
     Options     []string  `bson:"options,omitempty"` 
     CreatedAt   time.Time `bson:"created_at"`
     ExpiresAt   time.Time `bson:"expires_at"`
+    
+    // Text captchas only: more accepted answers and how answers are compared.
+    // Without Check the answer must match exactly, ignoring case
+    Alternatives []string     `bson:"alternatives,omitempty"`
+    Check        *AnswerCheck `bson:"check,omitempty"`
+    
+    // Random value that identifies this captcha, so that an answer is
+    // accepted only once and only for the captcha it was given to
+    Nonce string `bson:"nonce,omitempty"`
+    
+    // Where the captcha was posted
+    ChatID        int64 `bson:"chat_id,omitempty"`
+    MessageID     int   `bson:"message_id,omitempty"`
+    JoinMessageID int   `bson:"join_message_id,omitempty"` // Group mode only
+}
+
+// GroupCaptcha is the captcha a new member has to answer in a group. Every
+// group has its own, next to the private captcha in User.CaptchaData, so that
+// joining another group or talking to the bot does not replace it
+type GroupCaptcha struct {
+    ID         primitive.ObjectID `bson:"_id,omitempty"`
+    ChatID     int64              `bson:"chat_id"`
+    TelegramID int64              `bson:"telegram_id"`
+    Attempts   int                `bson:"attempts"` // Wrong answers in this group
+    Captcha    Captcha            `bson:"captcha"`
+}
+
+// IsGroup reports whether the captcha was posted in a group.
+// Group chat IDs are negative, private chat IDs match the user ID
+func (c *Captcha) IsGroup() bool {
+    return c.ChatID < 0
 }
 
 // Message model
@@ -42,21 +79,178 @@ type Message struct {
     TelegramID  int                `bson:"telegram_id"`
     UserID      primitive.ObjectID `bson:"user_id"`
     Text        string             `bson:"text"`
+    ContentType string             `bson:"content_type,omitempty"` // "text", "photo", "document", "voice", "sticker"
+    FromAdmin   bool               `bson:"from_admin"` // Reply from the admin to the user
     IsForwarded bool               `bson:"is_forwarded"`
     ForwardedTo []int64            `bson:"forwarded_to,omitempty"` // ID admin
     CreatedAt   time.Time          `bson:"created_at"`
 }
 
-// AdminSettings
+// AdminSettings holds the settings changed at runtime. Only the values that
+// were set are stored, missing ones fall back to the config defaults
 type AdminSettings struct {
     ID                    primitive.ObjectID `bson:"_id,omitempty"`
     AdminID               int64              `bson:"admin_id"`
-    AutoForwardEnabled    bool               `bson:"auto_forward_enabled"`
-    CaptchaType           string             `bson:"captcha_type"`
-    MaxAttempts           int                `bson:"max_attempts"`
-    BlockDuration         time.Duration      `bson:"block_duration"`
-    WelcomeMessage        string             `bson:"welcome_message"`
-    VerifiedMessage       string             `bson:"verified_message"`
+    AutoForwardEnabled    *bool              `bson:"auto_forward_enabled,omitempty"`
+    CaptchaType           string             `bson:"captcha_type,omitempty"`
+    MaxAttempts           int                `bson:"max_attempts,omitempty"`
+    CaptchaTTL            time.Duration      `bson:"captcha_ttl,omitempty"`
+    BlockDuration         *time.Duration     `bson:"block_duration,omitempty"` // 0 - forever
+    Templates             map[string]string  `bson:"templates,omitempty"` // Message name -> text/template source
+    QuestionDifficulty    *string            `bson:"question_difficulty,omitempty"` // Preferred for text captchas, empty - any
+    
+    // Before templates, replaced by Templates["welcome"] and Templates["verified"]
+    WelcomeMessage  string `bson:"welcome_message,omitempty"`
+    VerifiedMessage string `bson:"verified_message,omitempty"`
     CreatedAt             time.Time          `bson:"created_at"`
     UpdatedAt             time.Time          `bson:"updated_at"`
-}
\ No newline at end of file
+}
+
+// RelayLink maps a message in the admin chat to the user it came from
+type RelayLink struct {
+    ID             primitive.ObjectID `bson:"_id,omitempty"`
+    AdminChatID    int64              `bson:"admin_chat_id"`
+    AdminMessageID int                `bson:"admin_message_id"`
+    UserID         int64              `bson:"user_id"` // Telegram ID of the original sender
+    CreatedAt      time.Time          `bson:"created_at"`
+}
+
+// Kinds of blacklist entries
+const (
+    BlacklistByID       = "id"
+    BlacklistByUsername = "username"
+    BlacklistByRegex    = "regex" // Matched against the username and the full name
+)
+
+// BlacklistEntry model
+type BlacklistEntry struct {
+    ID         primitive.ObjectID `bson:"_id,omitempty"`
+    Kind       string             `bson:"kind"`
+    Value      string             `bson:"value"` // Telegram ID, lowercase username or pattern
+    TelegramID int64              `bson:"telegram_id,omitempty"`
+    Reason     string             `bson:"reason,omitempty"`
+    AddedBy    int64              `bson:"added_by"`
+    CreatedAt  time.Time          `bson:"created_at"`
+}
+
+// compactedEntry is the blacklist entry that replaces a user blocked forever
+func compactedEntry(user *User, addedBy int64) *BlacklistEntry {
+    reason := user.BlockReason
+    if reason == "" {
+        reason = "Blocked"
+    }
+    
+    return &BlacklistEntry{
+        Kind:       BlacklistByID,
+        Value:      strconv.FormatInt(user.TelegramID, 10),
+        TelegramID: user.TelegramID,
+        Reason:     reason,
+        AddedBy:    addedBy,
+    }
+}
+
+// Staff roles
+const (
+    RoleOwner     = "owner"
+    RoleModerator = "moderator"
+    RoleViewer    = "viewer"
+)
+
+// StaffMember is someone who helps the owner to run the bot
+type StaffMember struct {
+    ID         primitive.ObjectID `bson:"_id,omitempty"`
+    TelegramID int64              `bson:"telegram_id"`
+    Role       string             `bson:"role"`
+    Subscribed bool               `bson:"subscribed"` // Receives forwards and notifications
+    AddedBy    int64              `bson:"added_by"`
+    CreatedAt  time.Time          `bson:"created_at"`
+}
+
+// AdminCard is a copy of a sender information card in one staff chat.
+// Cards are kept until someone acts on the user, so that all copies can be updated
+type AdminCard struct {
+    ID        primitive.ObjectID `bson:"_id,omitempty"`
+    UserID    int64              `bson:"user_id"` // Telegram ID of the user the card is about
+    ChatID    int64              `bson:"chat_id"`
+    MessageID int                `bson:"message_id"`
+    Text      string             `bson:"text"` // Plain text of the card, without the buttons
+    CreatedAt time.Time          `bson:"created_at"`
+}
+
+// Question difficulties
+const (
+    DifficultyEasy   = "easy"
+    DifficultyMedium = "medium"
+    DifficultyHard   = "hard"
+)
+
+// AnswerCheck says how answers to a text captcha are compared with the
+// accepted ones. Both sides go through the same normalization steps
+type AnswerCheck struct {
+    Steps     []string `bson:"steps"`     // "nfkc", "diacritics", "punctuation", "numbers"
+    Tolerance int      `bson:"tolerance"` // Typos forgiven, as a Levenshtein distance
+}
+
+// Question is a text captcha question of the question bank
+type Question struct {
+    ID           primitive.ObjectID `bson:"_id,omitempty"`
+    Question     string             `bson:"question"`
+    Answer       string             `bson:"answer"`
+    Alternatives []string           `bson:"alternatives,omitempty"` // More accepted answers
+    Check        *AnswerCheck       `bson:"check,omitempty"`        // nil - the default steps, no typos
+    Language     string             `bson:"language"`               // Locale of the users who are asked it
+    Difficulty   string             `bson:"difficulty"`
+    Active       bool               `bson:"active"` // Disabled questions are kept, but not asked
+    AddedBy      int64              `bson:"added_by,omitempty"`
+    CreatedAt    time.Time          `bson:"created_at"`
+}
+
+// Captcha outcomes
+const (
+    OutcomePassed  = "passed"
+    OutcomeFailed  = "failed"
+    OutcomeTimeout = "timeout"
+)
+
+// CaptchaStat records one issued captcha and how it ended. Captchas that were
+// replaced by a new one before they were answered keep an empty outcome
+type CaptchaStat struct {
+    ID         primitive.ObjectID `bson:"_id,omitempty"`
+    Nonce      string             `bson:"nonce"`
+    TelegramID int64              `bson:"telegram_id"`
+    Type       string             `bson:"type"`
+    Group      bool               `bson:"group"`
+    IssuedAt   time.Time          `bson:"issued_at"`
+    Outcome    string             `bson:"outcome,omitempty"`
+    FinishedAt *time.Time         `bson:"finished_at,omitempty"`
+    DurationMS int64              `bson:"duration_ms,omitempty"` // From issue to outcome
+}
+
+// CaptchaTypeStats are the outcomes of one captcha type
+type CaptchaTypeStats struct {
+    Type    string `bson:"_id"`
+    Issued  int64  `bson:"issued"`
+    Passed  int64  `bson:"passed"`
+    Failed  int64  `bson:"failed"`
+    Timeout int64  `bson:"timeout"`
+}
+
+// PassRate is the share of finished captchas that were passed
+func (s CaptchaTypeStats) PassRate() float64 {
+    finished := s.Passed + s.Failed + s.Timeout
+    if finished == 0 {
+        return 0
+    }
+    return float64(s.Passed) / float64(finished)
+}
+
+// Stats summarize the activity since a point in time
+type Stats struct {
+    NewUsers        int64
+    Captchas        []CaptchaTypeStats // Sorted by type
+    MedianSolveTime time.Duration      // Of passed captchas
+    Blocks          int64              // Users blocked in the period
+    Received        int64              // Messages from users
+    Forwarded       int64              // Messages from users forwarded to staff
+    Replies         int64              // Replies from staff
+}
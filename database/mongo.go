@@ -2,34 +2,42 @@ package database
 
 import (
     "context"
+    "errors"
     "fmt"
-    "log"
+    "log/slog"
     "time"
+
+    "telegram-gatekeeper/metrics"
     
     "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
     "go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/mongo/options"
     "go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 type MongoDB struct {
-    Client     *mongo.Client
-    Database   *mongo.Database
+    Client        *mongo.Client
+    Database      *mongo.Database
     
     // Collections
-    Users      *mongo.Collection
-    Messages   *mongo.Collection
-    Settings   *mongo.Collection
-    Blacklist  *mongo.Collection
+    Users         *mongo.Collection
+    Messages      *mongo.Collection
+    Settings      *mongo.Collection
+    Blacklist     *mongo.Collection
+    Relays        *mongo.Collection
+    Staff         *mongo.Collection
+    Cards         *mongo.Collection
+    Stats         *mongo.Collection
+    Questions     *mongo.Collection
+    GroupCaptchas *mongo.Collection
 }
 
-var DB *MongoDB
-
 func Connect(uri, dbName string) (*MongoDB, error) {
     ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
     defer cancel()
     
-    clientOptions := options.Client().ApplyURI(uri)
+    clientOptions := options.Client().ApplyURI(uri).SetMonitor(metrics.MongoMonitor())
     client, err := mongo.Connect(ctx, clientOptions)
     if err != nil {
         return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
@@ -46,19 +54,24 @@ func Connect(uri, dbName string) (*MongoDB, error) {
     db := client.Database(dbName)
     
     mongoDB := &MongoDB{
-        Client:    client,
-        Database:  db,
-        Users:     db.Collection("users"),
-        Messages:  db.Collection("messages"),
-        Settings:  db.Collection("settings"),
-        Blacklist: db.Collection("blacklist"),
+        Client:        client,
+        Database:      db,
+        Users:         db.Collection("users"),
+        Messages:      db.Collection("messages"),
+        Settings:      db.Collection("settings"),
+        Blacklist:     db.Collection("blacklist"),
+        Relays:        db.Collection("relays"),
+        Staff:         db.Collection("staff"),
+        Cards:         db.Collection("admin_cards"),
+        Stats:         db.Collection("captcha_stats"),
+        Questions:     db.Collection("questions"),
+        GroupCaptchas: db.Collection("group_captchas"),
     }
     
     // Creating indexes
     createIndexes(mongoDB)
     
-    DB = mongoDB
-    log.Println("Connected to MongoDB successfully")
+    slog.Info("Connected to MongoDB", "database", dbName)
     return mongoDB, nil
 }
 
@@ -81,11 +94,23 @@ func createIndexes(db *MongoDB) {
         {
             Keys: bson.D{{Key: "created_at", Value: -1}},
         },
+        {
+            Keys: bson.D{{Key: "blocked_until", Value: 1}},
+            Options: options.Index().SetSparse(true),
+        },
+        {
+            Keys: bson.D{{Key: "captcha_data.expires_at", Value: 1}},
+            Options: options.Index().SetSparse(true),
+        },
+        {
+            Keys: bson.D{{Key: "blocked_at", Value: 1}},
+            Options: options.Index().SetSparse(true),
+        },
     }
     
     _, err := db.Users.Indexes().CreateMany(ctx, usersIndexes)
     if err != nil {
-        log.Printf("Error creating users indexes: %v", err)
+        slog.Error("Error creating users indexes", "error", err)
     }
     
     // Indexes for messages
@@ -103,8 +128,141 @@ func createIndexes(db *MongoDB) {
     
     _, err = db.Messages.Indexes().CreateMany(ctx, messagesIndexes)
     if err != nil {
-        log.Printf("Error creating messages indexes: %v", err)
+        slog.Error("Error creating messages indexes", "error", err)
+    }
+    
+    // Indexes for blacklist
+    blacklistIndexes := []mongo.IndexModel{
+        {
+            Keys: bson.D{
+                {Key: "kind", Value: 1},
+                {Key: "value", Value: 1},
+            },
+            Options: options.Index().SetUnique(true),
+        },
+    }
+    
+    _, err = db.Blacklist.Indexes().CreateMany(ctx, blacklistIndexes)
+    if err != nil {
+        slog.Error("Error creating blacklist indexes", "error", err)
+    }
+    
+    // Indexes for settings
+    settingsIndexes := []mongo.IndexModel{
+        {
+            Keys: bson.D{{Key: "admin_id", Value: 1}},
+            Options: options.Index().SetUnique(true),
+        },
+    }
+    
+    _, err = db.Settings.Indexes().CreateMany(ctx, settingsIndexes)
+    if err != nil {
+        slog.Error("Error creating settings indexes", "error", err)
+    }
+    
+    // Indexes for relay links
+    relaysIndexes := []mongo.IndexModel{
+        {
+            Keys: bson.D{
+                {Key: "admin_chat_id", Value: 1},
+                {Key: "admin_message_id", Value: 1},
+            },
+            Options: options.Index().SetUnique(true),
+        },
+    }
+    
+    _, err = db.Relays.Indexes().CreateMany(ctx, relaysIndexes)
+    if err != nil {
+        slog.Error("Error creating relays indexes", "error", err)
+    }
+    
+    // Indexes for staff
+    staffIndexes := []mongo.IndexModel{
+        {
+            Keys: bson.D{{Key: "telegram_id", Value: 1}},
+            Options: options.Index().SetUnique(true),
+        },
+    }
+    
+    _, err = db.Staff.Indexes().CreateMany(ctx, staffIndexes)
+    if err != nil {
+        slog.Error("Error creating staff indexes", "error", err)
+    }
+    
+    // Indexes for captcha statistics
+    statsIndexes := []mongo.IndexModel{
+        {
+            Keys: bson.D{{Key: "nonce", Value: 1}},
+            Options: options.Index().SetUnique(true),
+        },
+        {
+            Keys: bson.D{{Key: "issued_at", Value: 1}},
+        },
+        {
+            // Median solve time: passed captchas sorted by duration
+            Keys: bson.D{
+                {Key: "outcome", Value: 1},
+                {Key: "issued_at", Value: 1},
+                {Key: "duration_ms", Value: 1},
+            },
+        },
+    }
+    
+    _, err = db.Stats.Indexes().CreateMany(ctx, statsIndexes)
+    if err != nil {
+        slog.Error("Error creating captcha stats indexes", "error", err)
+    }
+    
+    // Indexes for admin cards
+    cardsIndexes := []mongo.IndexModel{
+        {
+            Keys: bson.D{{Key: "user_id", Value: 1}},
+        },
+    }
+    
+    _, err = db.Cards.Indexes().CreateMany(ctx, cardsIndexes)
+    if err != nil {
+        slog.Error("Error creating admin cards indexes", "error", err)
     }
+    
+    // Indexes for the question bank
+    questionsIndexes := []mongo.IndexModel{
+        {
+            Keys: bson.D{{Key: "created_at", Value: 1}},
+        },
+    }
+    
+    _, err = db.Questions.Indexes().CreateMany(ctx, questionsIndexes)
+    if err != nil {
+        slog.Error("Error creating questions indexes", "error", err)
+    }
+    
+    // Indexes for group captchas
+    groupCaptchasIndexes := []mongo.IndexModel{
+        {
+            Keys: bson.D{
+                {Key: "chat_id", Value: 1},
+                {Key: "telegram_id", Value: 1},
+            },
+            Options: options.Index().SetUnique(true),
+        },
+        {
+            Keys: bson.D{{Key: "captcha.expires_at", Value: 1}},
+        },
+    }
+    
+    _, err = db.GroupCaptchas.Indexes().CreateMany(ctx, groupCaptchasIndexes)
+    if err != nil {
+        slog.Error("Error creating group captchas indexes", "error", err)
+    }
+}
+
+// notFound translates the driver's "no documents" into ErrNotFound
+func notFound(err error) error {
+    if errors.Is(err, mongo.ErrNoDocuments) {
+        return ErrNotFound
+    }
+    return err
 }
 
 func (db *MongoDB) Disconnect() {
@@ -112,13 +270,13 @@ func (db *MongoDB) Disconnect() {
     defer cancel()
     
     if err := db.Client.Disconnect(ctx); err != nil {
-        log.Printf("Error disconnecting from MongoDB: %v", err)
+        slog.Error("Error disconnecting from MongoDB", "error", err)
     }
-    log.Println("Disconnected from MongoDB")
+    slog.Info("Disconnected from MongoDB")
 }
 
 // CRUD operations for users
-func (db *MongoDB) GetOrCreateUser(telegramID int64, username, firstName, lastName string, isBot bool) (*User, error) {
+func (db *MongoDB) GetOrCreateUser(telegramID int64, username, firstName, lastName, languageCode string, isBot bool) (*User, error) {
     ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
     defer cancel()
     
@@ -140,6 +298,9 @@ func (db *MongoDB) GetOrCreateUser(telegramID int64, username, firstName, lastNa
         if lastName != user.LastName {
             updateFields["last_name"] = lastName
         }
+        if languageCode != user.LanguageCode && languageCode != "" {
+            updateFields["language_code"] = languageCode
+        }
         
         if len(updateFields) > 0 {
             updateFields["updated_at"] = time.Now()
@@ -149,7 +310,7 @@ func (db *MongoDB) GetOrCreateUser(telegramID int64, username, firstName, lastNa
                 bson.M{"$set": updateFields},
             )
             if err != nil {
-                log.Printf("Error updating user: %v", err)
+                slog.Error("Error updating user", "error", err)
             }
         }
         
@@ -164,6 +325,7 @@ func (db *MongoDB) GetOrCreateUser(telegramID int64, username, firstName, lastNa
         Username:     username,
         FirstName:    firstName,
         LastName:     lastName,
+        LanguageCode: languageCode,
         IsBot:        isBot,
         IsVerified:   false,
         IsBlocked:    false,
@@ -172,11 +334,15 @@ func (db *MongoDB) GetOrCreateUser(telegramID int64, username, firstName, lastNa
         VerificationAttempts: 0,
     }
     
-    _, err = db.Users.InsertOne(ctx, newUser)
+    result, err := db.Users.InsertOne(ctx, newUser)
     if err != nil {
         return nil, err
     }
     
+    if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+        newUser.ID = id
+    }
+    
     return newUser, nil
 }
 
@@ -205,6 +371,24 @@ func (db *MongoDB) UpdateUserVerification(telegramID int64, isVerified bool) err
     return err
 }
 
+func (db *MongoDB) SetUserLanguage(telegramID int64, language string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    update := bson.M{
+        "$set": bson.M{"language": language, "updated_at": time.Now()},
+    }
+    if language == "" {
+        update = bson.M{
+            "$unset": bson.M{"language": ""},
+            "$set":   bson.M{"updated_at": time.Now()},
+        }
+    }
+    
+    _, err := db.Users.UpdateOne(ctx, bson.M{"telegram_id": telegramID}, update)
+    return err
+}
+
 func (db *MongoDB) IncrementAttempts(telegramID int64) error {
     ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
     defer cancel()
@@ -224,6 +408,115 @@ func (db *MongoDB) IncrementAttempts(telegramID int64) error {
     return err
 }
 
+// BlockUser blocks the user until the given time, or forever if until is nil
+func (db *MongoDB) BlockUser(telegramID int64, until *time.Time, reason string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    now := time.Now()
+    set := bson.M{
+        "is_blocked":  true,
+        "is_verified": false,
+        "blocked_at":  now,
+        "updated_at":  now,
+    }
+    unset := bson.M{}
+    
+    if until != nil {
+        set["blocked_until"] = *until
+    } else {
+        unset["blocked_until"] = ""
+    }
+    
+    if reason != "" {
+        set["block_reason"] = reason
+    } else {
+        unset["block_reason"] = ""
+    }
+    
+    update := bson.M{"$set": set}
+    if len(unset) > 0 {
+        update["$unset"] = unset
+    }
+    
+    _, err := db.Users.UpdateOne(ctx, bson.M{"telegram_id": telegramID}, update)
+    return err
+}
+
+// UnblockUser lifts the block and gives the user a fresh set of attempts
+func (db *MongoDB) UnblockUser(telegramID int64) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    _, err := db.Users.UpdateOne(
+        ctx,
+        bson.M{"telegram_id": telegramID},
+        unblockUpdate(),
+    )
+    
+    return err
+}
+
+// UnblockExpired lifts all blocks that have expired by now and returns the users
+// that were unblocked
+func (db *MongoDB) UnblockExpired(now time.Time) ([]User, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    
+    filter := bson.M{
+        "is_blocked":    true,
+        "blocked_until": bson.M{"$lte": now},
+    }
+    
+    cursor, err := db.Users.Find(ctx, filter)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+    
+    var expired []User
+    if err := cursor.All(ctx, &expired); err != nil {
+        return nil, err
+    }
+    
+    var unblocked []User
+    for _, user := range expired {
+        // The filter is repeated so that a block extended in the meantime is kept
+        result, err := db.Users.UpdateOne(
+            ctx,
+            bson.M{
+                "telegram_id":   user.TelegramID,
+                "is_blocked":    true,
+                "blocked_until": bson.M{"$lte": now},
+            },
+            unblockUpdate(),
+        )
+        if err != nil {
+            return unblocked, err
+        }
+        if result.ModifiedCount > 0 {
+            unblocked = append(unblocked, user)
+        }
+    }
+    
+    return unblocked, nil
+}
+
+func unblockUpdate() bson.M {
+    return bson.M{
+        "$set": bson.M{
+            "is_blocked":            false,
+            "verification_attempts": 0,
+            "updated_at":            time.Now(),
+        },
+        "$unset": bson.M{
+            "blocked_until": "",
+            "block_reason":  "",
+            "captcha_data":  "",
+        },
+    }
+}
+
 func (db *MongoDB) SaveCaptcha(telegramID int64, captcha *Captcha) error {
     ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
     defer cancel()
@@ -242,15 +535,899 @@ func (db *MongoDB) SaveCaptcha(telegramID int64, captcha *Captcha) error {
     return err
 }
 
-func (db *MongoDB) GetUserByTelegramID(telegramID int64) (*User, error) {
+func (db *MongoDB) ClearCaptcha(telegramID int64) error {
     ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
     defer cancel()
     
-    var user User
-    err := db.Users.FindOne(ctx, bson.M{"telegram_id": telegramID}).Decode(&user)
+    _, err := db.Users.UpdateOne(
+        ctx,
+        bson.M{"telegram_id": telegramID},
+        bson.M{
+            "$unset": bson.M{"captcha_data": ""},
+            "$set":   bson.M{"updated_at": time.Now()},
+        },
+    )
+    
+    return err
+}
+
+// FindExpiredCaptchas returns users whose captcha expired before now
+func (db *MongoDB) FindExpiredCaptchas(now time.Time, limit int) ([]User, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    
+    cursor, err := db.Users.Find(
+        ctx,
+        bson.M{"captcha_data.expires_at": bson.M{"$lte": now}},
+        options.Find().
+            SetSort(bson.D{{Key: "captcha_data.expires_at", Value: 1}}).
+            SetLimit(int64(limit)),
+    )
     if err != nil {
         return nil, err
     }
+    defer cursor.Close(ctx)
+    
+    var users []User
+    if err := cursor.All(ctx, &users); err != nil {
+        return nil, err
+    }
+    
+    return users, nil
+}
+
+// ExpireCaptcha clears the captcha if it is still the one that expired at expiresAt,
+// optionally counting it as a failed attempt. Returns the updated user,
+// or ErrNotFound if the captcha was answered or replaced in the meantime
+func (db *MongoDB) ExpireCaptcha(telegramID int64, expiresAt time.Time, countAttempt bool) (*User, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    now := time.Now()
+    update := bson.M{
+        "$unset": bson.M{"captcha_data": ""},
+        "$set":   bson.M{"updated_at": now},
+    }
+    if countAttempt {
+        update["$inc"] = bson.M{"verification_attempts": 1}
+        update["$set"].(bson.M)["last_attempt_at"] = now
+    }
+    
+    var user User
+    err := db.Users.FindOneAndUpdate(
+        ctx,
+        bson.M{
+            "telegram_id":             telegramID,
+            "captcha_data.expires_at": expiresAt,
+        },
+        update,
+        options.FindOneAndUpdate().SetReturnDocument(options.After),
+    ).Decode(&user)
+    if err != nil {
+        return nil, notFound(err)
+    }
+    
+    return &user, nil
+}
+
+// AnswerCaptcha consumes the active captcha with the given nonce in one step.
+// A correct answer verifies the user, a wrong one counts an attempt; the
+// updated user is returned. ErrNotFound means the captcha was already
+// answered, replaced or has expired
+func (db *MongoDB) AnswerCaptcha(telegramID int64, nonce string, correct bool) (*User, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    now := time.Now()
+    update := bson.M{
+        "$unset": bson.M{"captcha_data": ""},
+        "$set":   bson.M{"updated_at": now},
+    }
+    if correct {
+        update["$set"].(bson.M)["is_verified"] = true
+        update["$set"].(bson.M)["verified_at"] = now
+    } else {
+        update["$inc"] = bson.M{"verification_attempts": 1}
+        update["$set"].(bson.M)["last_attempt_at"] = now
+    }
+    
+    var user User
+    err := db.Users.FindOneAndUpdate(
+        ctx,
+        bson.M{
+            "telegram_id":             telegramID,
+            "captcha_data.nonce":      nonce,
+            "captcha_data.expires_at": bson.M{"$gt": now},
+        },
+        update,
+        options.FindOneAndUpdate().SetReturnDocument(options.After),
+    ).Decode(&user)
+    if err != nil {
+        return nil, notFound(err)
+    }
     
     return &user, nil
-}
\ No newline at end of file
+}
+
+// SaveGroupCaptcha replaces the captcha of the user in the group
+func (db *MongoDB) SaveGroupCaptcha(captcha *GroupCaptcha) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    _, err := db.GroupCaptchas.UpdateOne(
+        ctx,
+        bson.M{"chat_id": captcha.ChatID, "telegram_id": captcha.TelegramID},
+        bson.M{"$set": bson.M{
+            "attempts": captcha.Attempts,
+            "captcha":  captcha.Captcha,
+        }},
+        options.Update().SetUpsert(true),
+    )
+    
+    return err
+}
+
+func (db *MongoDB) GetGroupCaptcha(chatID, telegramID int64) (*GroupCaptcha, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    var captcha GroupCaptcha
+    err := db.GroupCaptchas.FindOne(ctx, bson.M{"chat_id": chatID, "telegram_id": telegramID}).Decode(&captcha)
+    if err != nil {
+        return nil, notFound(err)
+    }
+    
+    return &captcha, nil
+}
+
+// TakeGroupCaptcha removes the active captcha with the given nonce in one step
+// and returns it, so that an answer is accepted once. ErrNotFound means it was
+// already answered, replaced or has expired
+func (db *MongoDB) TakeGroupCaptcha(chatID, telegramID int64, nonce string) (*GroupCaptcha, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    var captcha GroupCaptcha
+    err := db.GroupCaptchas.FindOneAndDelete(ctx, bson.M{
+        "chat_id":            chatID,
+        "telegram_id":        telegramID,
+        "captcha.nonce":      nonce,
+        "captcha.expires_at": bson.M{"$gt": time.Now()},
+    }).Decode(&captcha)
+    if err != nil {
+        return nil, notFound(err)
+    }
+    
+    return &captcha, nil
+}
+
+// FindExpiredGroupCaptchas returns group captchas that expired before now
+func (db *MongoDB) FindExpiredGroupCaptchas(now time.Time, limit int) ([]GroupCaptcha, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    
+    cursor, err := db.GroupCaptchas.Find(
+        ctx,
+        bson.M{"captcha.expires_at": bson.M{"$lte": now}},
+        options.Find().
+            SetSort(bson.D{{Key: "captcha.expires_at", Value: 1}}).
+            SetLimit(int64(limit)),
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+    
+    var captchas []GroupCaptcha
+    if err := cursor.All(ctx, &captchas); err != nil {
+        return nil, err
+    }
+    
+    return captchas, nil
+}
+
+// ExpireGroupCaptcha removes the captcha if it is still the one that expired
+// at expiresAt, ErrNotFound means it was answered or replaced in the meantime
+func (db *MongoDB) ExpireGroupCaptcha(chatID, telegramID int64, expiresAt time.Time) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    result, err := db.GroupCaptchas.DeleteOne(ctx, bson.M{
+        "chat_id":            chatID,
+        "telegram_id":        telegramID,
+        "captcha.expires_at": expiresAt,
+    })
+    if err != nil {
+        return err
+    }
+    if result.DeletedCount == 0 {
+        return ErrNotFound
+    }
+    
+    return nil
+}
+
+func (db *MongoDB) ResetAttempts(telegramID int64) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    _, err := db.Users.UpdateOne(
+        ctx,
+        bson.M{"telegram_id": telegramID},
+        bson.M{
+            "$set": bson.M{
+                "verification_attempts": 0,
+                "updated_at":            time.Now(),
+            },
+        },
+    )
+    
+    return err
+}
+
+func (db *MongoDB) GetUserByTelegramID(telegramID int64) (*User, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    var user User
+    err := db.Users.FindOne(ctx, bson.M{"telegram_id": telegramID}).Decode(&user)
+    if err != nil {
+        return nil, notFound(err)
+    }
+    
+    return &user, nil
+}
+
+// SaveRelayLink remembers which user a message in the admin chat belongs to
+func (db *MongoDB) SaveRelayLink(adminChatID int64, adminMessageID int, userID int64) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    link := &RelayLink{
+        AdminChatID:    adminChatID,
+        AdminMessageID: adminMessageID,
+        UserID:         userID,
+        CreatedAt:      time.Now(),
+    }
+    
+    _, err := db.Relays.InsertOne(ctx, link)
+    return err
+}
+
+// GetRelayLink finds the user behind a message in the admin chat
+func (db *MongoDB) GetRelayLink(adminChatID int64, adminMessageID int) (*RelayLink, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    var link RelayLink
+    err := db.Relays.FindOne(ctx, bson.M{
+        "admin_chat_id":    adminChatID,
+        "admin_message_id": adminMessageID,
+    }).Decode(&link)
+    if err != nil {
+        return nil, notFound(err)
+    }
+    
+    return &link, nil
+}
+
+// CRUD operations for messages
+func (db *MongoDB) SaveMessage(message *Message) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    if message.CreatedAt.IsZero() {
+        message.CreatedAt = time.Now()
+    }
+    
+    result, err := db.Messages.InsertOne(ctx, message)
+    if err != nil {
+        return err
+    }
+    
+    if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+        message.ID = id
+    }
+    
+    return nil
+}
+
+// GetUserMessages returns one page of the user's conversation, newest first,
+// together with the total number of stored messages
+func (db *MongoDB) GetUserMessages(userID primitive.ObjectID, page, pageSize int) ([]Message, int64, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    filter := bson.M{"user_id": userID}
+    
+    total, err := db.Messages.CountDocuments(ctx, filter)
+    if err != nil {
+        return nil, 0, err
+    }
+    
+    findOptions := options.Find().
+        SetSort(bson.D{{Key: "created_at", Value: -1}}).
+        SetSkip(int64((page - 1) * pageSize)).
+        SetLimit(int64(pageSize))
+    
+    cursor, err := db.Messages.Find(ctx, filter, findOptions)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer cursor.Close(ctx)
+    
+    var messages []Message
+    if err := cursor.All(ctx, &messages); err != nil {
+        return nil, 0, err
+    }
+    
+    return messages, total, nil
+}
+
+// CRUD operations for admin settings
+func (db *MongoDB) GetSettings(adminID int64) (*AdminSettings, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    var settings AdminSettings
+    err := db.Settings.FindOne(ctx, bson.M{"admin_id": adminID}).Decode(&settings)
+    if err != nil {
+        return nil, notFound(err)
+    }
+    
+    return &settings, nil
+}
+
+// SetSetting changes one value of the settings document, the others are left
+// as they are so that values that were never set keep following the defaults
+func (db *MongoDB) SetSetting(adminID int64, key string, value any) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    now := time.Now()
+    update := bson.M{
+        "$set":         bson.M{"updated_at": now},
+        "$setOnInsert": bson.M{"created_at": now},
+    }
+    if value == nil {
+        update["$unset"] = bson.M{key: ""}
+    } else {
+        update["$set"].(bson.M)[key] = value
+    }
+    
+    _, err := db.Settings.UpdateOne(
+        ctx,
+        bson.M{"admin_id": adminID},
+        update,
+        options.Update().SetUpsert(true),
+    )
+    
+    return err
+}
+
+// CRUD operations for blacklist
+func (db *MongoDB) AddBlacklistEntry(entry *BlacklistEntry) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    if entry.CreatedAt.IsZero() {
+        entry.CreatedAt = time.Now()
+    }
+    
+    // Adding the same key again only updates the reason
+    _, err := db.Blacklist.UpdateOne(
+        ctx,
+        bson.M{"kind": entry.Kind, "value": entry.Value},
+        bson.M{
+            "$set": bson.M{
+                "telegram_id": entry.TelegramID,
+                "reason":      entry.Reason,
+                "added_by":    entry.AddedBy,
+            },
+            "$setOnInsert": bson.M{"created_at": entry.CreatedAt},
+        },
+        options.Update().SetUpsert(true),
+    )
+    
+    return err
+}
+
+// RemoveBlacklistEntry deletes the entry and reports whether it existed
+func (db *MongoDB) RemoveBlacklistEntry(kind, value string) (bool, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    result, err := db.Blacklist.DeleteOne(ctx, bson.M{"kind": kind, "value": value})
+    if err != nil {
+        return false, err
+    }
+    
+    return result.DeletedCount > 0, nil
+}
+
+func (db *MongoDB) GetBlacklist() ([]BlacklistEntry, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    cursor, err := db.Blacklist.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+    
+    var entries []BlacklistEntry
+    if err := cursor.All(ctx, &entries); err != nil {
+        return nil, err
+    }
+    
+    return entries, nil
+}
+
+// CRUD operations for staff
+func (db *MongoDB) GetStaff() ([]StaffMember, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    cursor, err := db.Staff.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+    
+    var staff []StaffMember
+    if err := cursor.All(ctx, &staff); err != nil {
+        return nil, err
+    }
+    
+    return staff, nil
+}
+
+// SaveStaffMember adds the member or updates the role and subscription
+func (db *MongoDB) SaveStaffMember(member *StaffMember) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    if member.CreatedAt.IsZero() {
+        member.CreatedAt = time.Now()
+    }
+    
+    _, err := db.Staff.UpdateOne(
+        ctx,
+        bson.M{"telegram_id": member.TelegramID},
+        bson.M{
+            "$set": bson.M{
+                "role":       member.Role,
+                "subscribed": member.Subscribed,
+                "added_by":   member.AddedBy,
+            },
+            "$setOnInsert": bson.M{"created_at": member.CreatedAt},
+        },
+        options.Update().SetUpsert(true),
+    )
+    
+    return err
+}
+
+// RemoveStaffMember deletes the member and reports whether they existed
+func (db *MongoDB) RemoveStaffMember(telegramID int64) (bool, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    result, err := db.Staff.DeleteOne(ctx, bson.M{"telegram_id": telegramID})
+    if err != nil {
+        return false, err
+    }
+    
+    return result.DeletedCount > 0, nil
+}
+
+// CRUD operations for admin cards
+func (db *MongoDB) SaveAdminCard(card *AdminCard) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    if card.CreatedAt.IsZero() {
+        card.CreatedAt = time.Now()
+    }
+    
+    result, err := db.Cards.InsertOne(ctx, card)
+    if err != nil {
+        return err
+    }
+    
+    card.ID = result.InsertedID.(primitive.ObjectID)
+    return nil
+}
+
+// TakeAdminCards returns the cards about the user and deletes them, so that
+// when two moderators act at once each card is updated only once
+func (db *MongoDB) TakeAdminCards(userID int64) ([]AdminCard, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    var taken []AdminCard
+    for {
+        var card AdminCard
+        err := db.Cards.FindOneAndDelete(ctx, bson.M{"user_id": userID}).Decode(&card)
+        if errors.Is(err, mongo.ErrNoDocuments) {
+            return taken, nil
+        }
+        if err != nil {
+            return taken, err
+        }
+        taken = append(taken, card)
+    }
+}
+
+// CRUD operations for the question bank
+func (db *MongoDB) AddQuestions(questions []Question) error {
+    if len(questions) == 0 {
+        return nil
+    }
+    
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    
+    docs := make([]any, len(questions))
+    for i := range questions {
+        if questions[i].ID.IsZero() {
+            questions[i].ID = primitive.NewObjectID()
+        }
+        if questions[i].CreatedAt.IsZero() {
+            questions[i].CreatedAt = time.Now()
+        }
+        docs[i] = questions[i]
+    }
+    
+    _, err := db.Questions.InsertMany(ctx, docs)
+    return err
+}
+
+func (db *MongoDB) GetQuestions() ([]Question, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    cursor, err := db.Questions.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+    
+    var questions []Question
+    if err := cursor.All(ctx, &questions); err != nil {
+        return nil, err
+    }
+    
+    return questions, nil
+}
+
+// SetQuestionActive enables or disables the question and reports whether it exists
+func (db *MongoDB) SetQuestionActive(id primitive.ObjectID, active bool) (bool, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    result, err := db.Questions.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"active": active}})
+    if err != nil {
+        return false, err
+    }
+    
+    return result.MatchedCount > 0, nil
+}
+
+// SetQuestionCheck changes how answers to the question are compared and
+// reports whether it exists
+func (db *MongoDB) SetQuestionCheck(id primitive.ObjectID, check *AnswerCheck) (bool, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    update := bson.M{"$set": bson.M{"check": check}}
+    if check == nil {
+        update = bson.M{"$unset": bson.M{"check": ""}}
+    }
+    
+    result, err := db.Questions.UpdateOne(ctx, bson.M{"_id": id}, update)
+    if err != nil {
+        return false, err
+    }
+    
+    return result.MatchedCount > 0, nil
+}
+
+// DeleteQuestion deletes the question and reports whether it existed
+func (db *MongoDB) DeleteQuestion(id primitive.ObjectID) (bool, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    result, err := db.Questions.DeleteOne(ctx, bson.M{"_id": id})
+    if err != nil {
+        return false, err
+    }
+    
+    return result.DeletedCount > 0, nil
+}
+
+// Statistics
+func (db *MongoDB) RecordCaptchaIssued(stat *CaptchaStat) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    _, err := db.Stats.InsertOne(ctx, stat)
+    return err
+}
+
+// RecordCaptchaOutcome sets the outcome once, the duration is computed by the server
+func (db *MongoDB) RecordCaptchaOutcome(nonce, outcome string, at time.Time) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    _, err := db.Stats.UpdateOne(
+        ctx,
+        bson.M{"nonce": nonce, "outcome": bson.M{"$exists": false}},
+        mongo.Pipeline{
+            {{Key: "$set", Value: bson.M{
+                "outcome":     outcome,
+                "finished_at": at,
+                "duration_ms": bson.M{"$subtract": bson.A{at, "$issued_at"}},
+            }}},
+        },
+    )
+    return err
+}
+
+func (db *MongoDB) GetStats(since time.Time) (*Stats, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    
+    stats := &Stats{}
+    var err error
+    
+    stats.NewUsers, err = db.Users.CountDocuments(ctx, bson.M{"created_at": bson.M{"$gte": since}})
+    if err != nil {
+        return nil, fmt.Errorf("counting new users: %w", err)
+    }
+    
+    stats.Blocks, err = db.Users.CountDocuments(ctx, bson.M{"blocked_at": bson.M{"$gte": since}})
+    if err != nil {
+        return nil, fmt.Errorf("counting blocks: %w", err)
+    }
+    
+    if stats.Captchas, err = db.captchaTypeStats(ctx, since); err != nil {
+        return nil, fmt.Errorf("aggregating captchas: %w", err)
+    }
+    
+    if stats.MedianSolveTime, err = db.medianSolveTime(ctx, since); err != nil {
+        return nil, fmt.Errorf("finding median solve time: %w", err)
+    }
+    
+    if err := db.messageStats(ctx, since, stats); err != nil {
+        return nil, fmt.Errorf("aggregating messages: %w", err)
+    }
+    
+    return stats, nil
+}
+
+func (db *MongoDB) captchaTypeStats(ctx context.Context, since time.Time) ([]CaptchaTypeStats, error) {
+    countOutcome := func(outcome string) bson.M {
+        return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$outcome", outcome}}, 1, 0}}}
+    }
+    
+    cursor, err := db.Stats.Aggregate(ctx, mongo.Pipeline{
+        {{Key: "$match", Value: bson.M{"issued_at": bson.M{"$gte": since}}}},
+        {{Key: "$group", Value: bson.M{
+            "_id":     "$type",
+            "issued":  bson.M{"$sum": 1},
+            "passed":  countOutcome(OutcomePassed),
+            "failed":  countOutcome(OutcomeFailed),
+            "timeout": countOutcome(OutcomeTimeout),
+        }}},
+        {{Key: "$sort", Value: bson.M{"_id": 1}}},
+    })
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+    
+    var result []CaptchaTypeStats
+    if err := cursor.All(ctx, &result); err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// medianSolveTime skips to the middle of the passed captchas sorted by
+// duration, which works on MongoDB versions without $median
+func (db *MongoDB) medianSolveTime(ctx context.Context, since time.Time) (time.Duration, error) {
+    filter := bson.M{"outcome": OutcomePassed, "issued_at": bson.M{"$gte": since}}
+    
+    passed, err := db.Stats.CountDocuments(ctx, filter)
+    if err != nil || passed == 0 {
+        return 0, err
+    }
+    
+    cursor, err := db.Stats.Aggregate(ctx, mongo.Pipeline{
+        {{Key: "$match", Value: filter}},
+        {{Key: "$sort", Value: bson.M{"duration_ms": 1}}},
+        {{Key: "$skip", Value: passed / 2}},
+        {{Key: "$limit", Value: 1}},
+        {{Key: "$project", Value: bson.M{"_id": 0, "duration_ms": 1}}},
+    })
+    if err != nil {
+        return 0, err
+    }
+    defer cursor.Close(ctx)
+    
+    var middle []CaptchaStat
+    if err := cursor.All(ctx, &middle); err != nil || len(middle) == 0 {
+        return 0, err
+    }
+    
+    return time.Duration(middle[0].DurationMS) * time.Millisecond, nil
+}
+
+func (db *MongoDB) messageStats(ctx context.Context, since time.Time, stats *Stats) error {
+    cursor, err := db.Messages.Aggregate(ctx, mongo.Pipeline{
+        {{Key: "$match", Value: bson.M{"created_at": bson.M{"$gte": since}}}},
+        {{Key: "$group", Value: bson.M{
+            "_id": nil,
+            "received": bson.M{"$sum": bson.M{"$cond": bson.A{"$from_admin", 0, 1}}},
+            "forwarded": bson.M{"$sum": bson.M{"$cond": bson.A{
+                bson.M{"$and": bson.A{bson.M{"$not": bson.A{"$from_admin"}}, "$is_forwarded"}}, 1, 0,
+            }}},
+            "replies": bson.M{"$sum": bson.M{"$cond": bson.A{"$from_admin", 1, 0}}},
+        }}},
+    })
+    if err != nil {
+        return err
+    }
+    defer cursor.Close(ctx)
+    
+    var totals []struct {
+        Received  int64 `bson:"received"`
+        Forwarded int64 `bson:"forwarded"`
+        Replies   int64 `bson:"replies"`
+    }
+    if err := cursor.All(ctx, &totals); err != nil {
+        return err
+    }
+    
+    if len(totals) > 0 {
+        stats.Received = totals[0].Received
+        stats.Forwarded = totals[0].Forwarded
+        stats.Replies = totals[0].Replies
+    }
+    return nil
+}
+
+// Retention
+func (db *MongoDB) DeleteMessagesBefore(before time.Time) (int64, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+    defer cancel()
+    
+    result, err := db.Messages.DeleteMany(ctx, bson.M{"created_at": bson.M{"$lt": before}})
+    if err != nil {
+        return 0, err
+    }
+    return result.DeletedCount, nil
+}
+
+// DeleteInactiveUsers deletes users who never passed verification, are not
+// blocked and have not done anything since inactiveSince
+func (db *MongoDB) DeleteInactiveUsers(inactiveSince time.Time) (int64, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+    defer cancel()
+    
+    result, err := db.Users.DeleteMany(ctx, bson.M{
+        "is_verified":     false,
+        "verified_at":     bson.M{"$exists": false},
+        "is_blocked":      false,
+        "created_at":      bson.M{"$lt": inactiveSince},
+        "updated_at":      bson.M{"$lt": inactiveSince},
+        "last_attempt_at": bson.M{"$lt": inactiveSince},
+    })
+    if err != nil {
+        return 0, err
+    }
+    return result.DeletedCount, nil
+}
+
+// ClearStaleCaptchas removes captchas that expired before expiredBefore but were
+// never retired by the sweeper
+func (db *MongoDB) ClearStaleCaptchas(expiredBefore time.Time) (int64, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+    defer cancel()
+    
+    result, err := db.Users.UpdateMany(
+        ctx,
+        bson.M{"captcha_data.expires_at": bson.M{"$lt": expiredBefore}},
+        bson.M{"$unset": bson.M{"captcha_data": ""}},
+    )
+    if err != nil {
+        return 0, err
+    }
+    
+    groups, err := db.GroupCaptchas.DeleteMany(ctx, bson.M{"captcha.expires_at": bson.M{"$lt": expiredBefore}})
+    if err != nil {
+        return result.ModifiedCount, err
+    }
+    return result.ModifiedCount + groups.DeletedCount, nil
+}
+
+// CompactBlockedUsers replaces users blocked forever since before blockedBefore
+// with blacklist entries. The record of a user is deleted only once the entry
+// is stored, their messages are left to the message retention
+func (db *MongoDB) CompactBlockedUsers(blockedBefore time.Time, addedBy int64) (int64, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+    defer cancel()
+    
+    filter := bson.M{
+        "is_blocked":    true,
+        "blocked_until": nil,
+        "$or": bson.A{
+            bson.M{"blocked_at": bson.M{"$lt": blockedBefore}},
+            // Blocked before the block time was recorded
+            bson.M{"blocked_at": nil, "updated_at": bson.M{"$lt": blockedBefore}},
+        },
+    }
+    
+    cursor, err := db.Users.Find(ctx, filter)
+    if err != nil {
+        return 0, err
+    }
+    defer cursor.Close(ctx)
+    
+    var users []User
+    if err := cursor.All(ctx, &users); err != nil {
+        return 0, err
+    }
+    
+    var compacted int64
+    for _, user := range users {
+        entry := compactedEntry(&user, addedBy)
+        inserted, err := db.insertBlacklistEntry(ctx, entry)
+        if err != nil {
+            return compacted, err
+        }
+        
+        // The filter is repeated so that a user unblocked in the meantime is kept
+        result, err := db.Users.DeleteOne(ctx, bson.M{"_id": user.ID, "is_blocked": true, "blocked_until": nil})
+        if err != nil {
+            return compacted, err
+        }
+        if result.DeletedCount == 0 {
+            // Only an entry of this run is rolled back, a ban of the admin stays
+            if inserted {
+                if _, err := db.RemoveBlacklistEntry(entry.Kind, entry.Value); err != nil {
+                    return compacted, err
+                }
+            }
+            continue
+        }
+        compacted++
+    }
+    
+    return compacted, nil
+}
+
+// insertBlacklistEntry stores the entry unless its key is already blacklisted,
+// so that the reason and author of an existing entry are kept
+func (db *MongoDB) insertBlacklistEntry(ctx context.Context, entry *BlacklistEntry) (bool, error) {
+    if entry.CreatedAt.IsZero() {
+        entry.CreatedAt = time.Now()
+    }
+    
+    result, err := db.Blacklist.UpdateOne(
+        ctx,
+        bson.M{"kind": entry.Kind, "value": entry.Value},
+        bson.M{"$setOnInsert": bson.M{
+            "telegram_id": entry.TelegramID,
+            "reason":      entry.Reason,
+            "added_by":    entry.AddedBy,
+            "created_at":  entry.CreatedAt,
+        }},
+        options.Update().SetUpsert(true),
+    )
+    if err != nil {
+        return false, err
+    }
+    return result.UpsertedCount > 0, nil
+}
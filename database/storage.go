@@ -0,0 +1,93 @@
+package database
+
+import (
+    "errors"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotFound is returned when the requested document does not exist
+var ErrNotFound = errors.New("not found")
+
+// Storage is everything the bot keeps between updates.
+// MongoDB is the production implementation, MemoryStorage keeps data in memory
+type Storage interface {
+    // Users
+    GetOrCreateUser(telegramID int64, username, firstName, lastName, languageCode string, isBot bool) (*User, error)
+    GetUserByTelegramID(telegramID int64) (*User, error)
+    UpdateUserVerification(telegramID int64, isVerified bool) error
+    SetUserLanguage(telegramID int64, language string) error // "" - follow the Telegram language
+    IncrementAttempts(telegramID int64) error
+    ResetAttempts(telegramID int64) error
+
+    // Blocks
+    BlockUser(telegramID int64, until *time.Time, reason string) error
+    UnblockUser(telegramID int64) error
+    UnblockExpired(now time.Time) ([]User, error)
+
+    // Captchas
+    SaveCaptcha(telegramID int64, captcha *Captcha) error
+    ClearCaptcha(telegramID int64) error
+    FindExpiredCaptchas(now time.Time, limit int) ([]User, error)
+    ExpireCaptcha(telegramID int64, expiresAt time.Time, countAttempt bool) (*User, error)
+    AnswerCaptcha(telegramID int64, nonce string, correct bool) (*User, error)
+
+    // Group captchas, one per group and user
+    SaveGroupCaptcha(captcha *GroupCaptcha) error
+    GetGroupCaptcha(chatID, telegramID int64) (*GroupCaptcha, error)
+    TakeGroupCaptcha(chatID, telegramID int64, nonce string) (*GroupCaptcha, error)
+    FindExpiredGroupCaptchas(now time.Time, limit int) ([]GroupCaptcha, error)
+    ExpireGroupCaptcha(chatID, telegramID int64, expiresAt time.Time) error
+
+    // Messages
+    SaveRelayLink(adminChatID int64, adminMessageID int, userID int64) error
+    GetRelayLink(adminChatID int64, adminMessageID int) (*RelayLink, error)
+    SaveMessage(message *Message) error
+    GetUserMessages(userID primitive.ObjectID, page, pageSize int) ([]Message, int64, error)
+
+    // Settings
+    GetSettings(adminID int64) (*AdminSettings, error)
+    // SetSetting stores a single value under its key, e.g. "max_attempts" or
+    // "templates.welcome". A nil value removes it, so that the default applies
+    SetSetting(adminID int64, key string, value any) error
+
+    // Blacklist
+    AddBlacklistEntry(entry *BlacklistEntry) error
+    RemoveBlacklistEntry(kind, value string) (bool, error)
+    GetBlacklist() ([]BlacklistEntry, error)
+
+    // Staff
+    GetStaff() ([]StaffMember, error)
+    SaveStaffMember(member *StaffMember) error
+    RemoveStaffMember(telegramID int64) (bool, error)
+
+    // Question bank
+    AddQuestions(questions []Question) error
+    GetQuestions() ([]Question, error)
+    SetQuestionActive(id primitive.ObjectID, active bool) (bool, error)
+    SetQuestionCheck(id primitive.ObjectID, check *AnswerCheck) (bool, error) // nil - the default check
+    DeleteQuestion(id primitive.ObjectID) (bool, error)
+
+    // Statistics
+    RecordCaptchaIssued(stat *CaptchaStat) error
+    RecordCaptchaOutcome(nonce, outcome string, at time.Time) error
+    GetStats(since time.Time) (*Stats, error)
+
+    // Retention
+    DeleteMessagesBefore(before time.Time) (int64, error)
+    DeleteInactiveUsers(inactiveSince time.Time) (int64, error)
+    ClearStaleCaptchas(expiredBefore time.Time) (int64, error)
+    CompactBlockedUsers(blockedBefore time.Time, addedBy int64) (int64, error)
+
+    // Admin cards
+    SaveAdminCard(card *AdminCard) error
+    TakeAdminCards(userID int64) ([]AdminCard, error)
+
+    Disconnect()
+}
+
+var (
+    _ Storage = (*MongoDB)(nil)
+    _ Storage = (*MemoryStorage)(nil)
+)
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Permissions needed for admin commands, an empty one means any staff member
+var commandPermissions = map[string]string{
+    "history":       PermHistory,
+    "stats":         PermHistory,
+    "settings":      PermSettings,
+    "set":           PermSettings,
+    "template":      PermSettings,
+    "questions":     PermSettings,
+    "block":         PermBlock,
+    "unblock":       PermBlock,
+    "ban":           PermBlock,
+    "unban":         PermBlock,
+    "banlist":       PermBlock,
+    "addmod":        PermStaff,
+    "removemod":     PermStaff,
+    "staff":         "",
+    "notifications": "",
+}
+
+// handleAdminCommand runs staff commands and reports whether the command was handled.
+// For everyone else admin commands look like unknown ones
+func (h *BotHandler) handleAdminCommand(message *tgbotapi.Message) bool {
+    if message.From == nil || !h.isStaff(message.From.ID) {
+        return false
+    }
+
+    permission, ok := commandPermissions[message.Command()]
+    if !ok {
+        return false
+    }
+    if permission != "" && !h.can(message.From.ID, permission) {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.no_permission"))
+        return true
+    }
+
+    switch message.Command() {
+    case "history":
+        h.handleHistoryCommand(message)
+    case "stats":
+        h.handleStatsCommand(message)
+    case "settings":
+        h.handleSettingsCommand(message)
+    case "set":
+        h.handleSetCommand(message)
+    case "template":
+        h.handleTemplateCommand(message)
+    case "questions":
+        h.handleQuestionsCommand(message)
+    case "block":
+        h.handleBlockCommand(message)
+    case "unblock":
+        h.handleUnblockCommand(message)
+    case "ban":
+        h.handleBanCommand(message)
+    case "unban":
+        h.handleUnbanCommand(message)
+    case "banlist":
+        h.handleBanlistCommand(message)
+    case "addmod":
+        h.handleAddModCommand(message)
+    case "removemod":
+        h.handleRemoveModCommand(message)
+    case "staff":
+        h.handleStaffCommand(message)
+    case "notifications":
+        h.handleNotificationsCommand(message)
+    default:
+        return false
+    }
+
+    return true
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+    "slices"
+    "strconv"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+
+    "telegram-gatekeeper/database"
+
+    "golang.org/x/text/runes"
+    "golang.org/x/text/transform"
+    "golang.org/x/text/unicode/norm"
+)
+
+// Normalization steps of text captcha answers. Case and extra spaces are always ignored
+const (
+    stepNFKC        = "nfkc"        // Compatibility forms: full-width letters, ligatures, superscripts
+    stepDiacritics  = "diacritics"  // é -> e, ё -> е
+    stepPunctuation = "punctuation" // "Washington, D.C." -> "washington dc", dashes split words
+    stepNumbers     = "numbers"     // "twenty-nine", "двадцать девять" -> "29"
+)
+
+var answerSteps = []string{stepNFKC, stepDiacritics, stepPunctuation, stepNumbers}
+
+// defaultAnswerCheck is used for questions that do not set their own
+var defaultAnswerCheck = database.AnswerCheck{Steps: answerSteps}
+
+// One typo is forgiven per this many characters of the accepted answer, so
+// that a tolerance cannot turn short answers into guesses
+const charsPerTypo = 4
+
+// answerCheckOf returns how answers to the question are compared
+func answerCheckOf(q database.Question) *database.AnswerCheck {
+    if q.Check != nil {
+        return q.Check
+    }
+    check := defaultAnswerCheck
+    return &check
+}
+
+// answerMatches reports whether the answer of the user is accepted by the captcha
+func answerMatches(answer string, captcha *database.Captcha) bool {
+    if captcha.Check == nil {
+        return strings.EqualFold(strings.TrimSpace(answer), captcha.Answer)
+    }
+
+    got := normalizeAnswer(answer, captcha.Check.Steps)
+    for _, accepted := range append([]string{captcha.Answer}, captcha.Alternatives...) {
+        want := normalizeAnswer(accepted, captcha.Check.Steps)
+        if want == "" {
+            continue
+        }
+        if got == want {
+            return true
+        }
+
+        tolerance := min(captcha.Check.Tolerance, utf8.RuneCountInString(want)/charsPerTypo)
+        if tolerance > 0 && levenshtein(got, want) <= tolerance {
+            return true
+        }
+    }
+
+    return false
+}
+
+var stripDiacritics = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// normalizeAnswer brings an answer to the form answers are compared in
+func normalizeAnswer(s string, steps []string) string {
+    has := func(step string) bool { return slices.Contains(steps, step) }
+
+    if has(stepNFKC) {
+        s = norm.NFKC.String(s)
+    }
+    s = strings.ToLower(s)
+    if has(stepDiacritics) {
+        if stripped, _, err := transform.String(stripDiacritics, s); err == nil {
+            s = stripped
+        }
+    }
+    if has(stepPunctuation) {
+        s = strings.Map(func(r rune) rune {
+            switch {
+            case unicode.Is(unicode.Pd, r) || r == '/':
+                return ' '
+            case unicode.IsPunct(r):
+                return -1
+            }
+            return r
+        }, s)
+    }
+
+    words := strings.Fields(s)
+    if has(stepNumbers) {
+        words = replaceNumberWords(words)
+    }
+
+    return strings.Join(words, " ")
+}
+
+// Values of number words in the supported languages. Hundreds are a
+// multiplier in English and words of their own in Russian
+var numberWords = map[string]int{
+    "zero": 0, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7, "eight": 8, "nine": 9,
+    "ten": 10, "eleven": 11, "twelve": 12, "thirteen": 13, "fourteen": 14, "fifteen": 15, "sixteen": 16,
+    "seventeen": 17, "eighteen": 18, "nineteen": 19, "twenty": 20, "thirty": 30, "forty": 40, "fifty": 50,
+    "sixty": 60, "seventy": 70, "eighty": 80, "ninety": 90,
+
+    "ноль": 0, "один": 1, "одна": 1, "одно": 1, "два": 2, "две": 2, "три": 3, "четыре": 4, "пять": 5,
+    "шесть": 6, "семь": 7, "восемь": 8, "девять": 9, "десять": 10, "одиннадцать": 11, "двенадцать": 12,
+    "тринадцать": 13, "четырнадцать": 14, "пятнадцать": 15, "шестнадцать": 16, "семнадцать": 17,
+    "восемнадцать": 18, "девятнадцать": 19, "двадцать": 20, "тридцать": 30, "сорок": 40, "пятьдесят": 50,
+    "шестьдесят": 60, "семьдесят": 70, "восемьдесят": 80, "девяносто": 90, "сто": 100, "двести": 200,
+    "триста": 300, "четыреста": 400, "пятьсот": 500, "шестьсот": 600, "семьсот": 700, "восемьсот": 800,
+    "девятьсот": 900,
+}
+
+var numberScales = map[string]int{
+    "hundred": 100, "thousand": 1000, "million": 1000000,
+    "тысяча": 1000, "тысячи": 1000, "тысяч": 1000, "миллион": 1000000, "миллиона": 1000000, "миллионов": 1000000,
+}
+
+// replaceNumberWords turns runs of number words into digits. A run ends where
+// the next word could not continue the number, so "one two" stays two numbers
+func replaceNumberWords(words []string) []string {
+    result := make([]string, 0, len(words))
+
+    for i := 0; i < len(words); {
+        // Without the punctuation step "twenty-nine" is a single word
+        if parts := strings.Split(words[i], "-"); len(parts) > 1 && allNumberWords(parts) {
+            words = append(words[:i:i], append(parts, words[i+1:]...)...)
+        }
+
+        value, n := parseNumber(words[i:])
+        if n == 0 {
+            result = append(result, words[i])
+            i++
+            continue
+        }
+        result = append(result, strconv.Itoa(value))
+        i += n
+    }
+
+    return result
+}
+
+func allNumberWords(words []string) bool {
+    for _, w := range words {
+        if _, ok := numberWords[w]; !ok {
+            return false
+        }
+    }
+    return true
+}
+
+// parseNumber reads the number at the start of words and returns it with the
+// count of words it took, 0 when words do not start with a number
+func parseNumber(words []string) (int, int) {
+    total, current, taken := 0, 0, 0
+
+    for i, w := range words {
+        if v, ok := numberWords[w]; ok {
+            if taken > 0 && !canFollow(current, v) {
+                break
+            }
+            current += v
+            taken = i + 1
+            continue
+        }
+
+        if scale, ok := numberScales[w]; ok {
+            if scale == 100 {
+                current = max(current, 1) * 100
+            } else {
+                total += max(current, 1) * scale
+                current = 0
+            }
+            taken = i + 1
+            continue
+        }
+
+        // "one hundred and five"
+        if w == "and" && taken > 0 && i+1 < len(words) {
+            if _, ok := numberWords[words[i+1]]; ok {
+                continue
+            }
+        }
+        break
+    }
+
+    return total + current, taken
+}
+
+// canFollow reports whether a number word of value v continues a number
+// whose last group is current: tens follow hundreds, units follow tens
+func canFollow(current, v int) bool {
+    switch {
+    case v >= 100:
+        return current%1000 == 0
+    case v >= 10:
+        return current%100 == 0
+    default:
+        return current%10 == 0 && current%100 != 10
+    }
+}
+
+// levenshtein is the number of single character edits between a and b
+func levenshtein(a, b string) int {
+    ra, rb := []rune(a), []rune(b)
+    prev := make([]int, len(rb)+1)
+    curr := make([]int, len(rb)+1)
+    for j := range prev {
+        prev[j] = j
+    }
+
+    for i := 1; i <= len(ra); i++ {
+        curr[0] = i
+        for j := 1; j <= len(rb); j++ {
+            cost := 1
+            if ra[i-1] == rb[j-1] {
+                cost = 0
+            }
+            curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+        }
+        prev, curr = curr, prev
+    }
+
+    return prev[len(rb)]
+}
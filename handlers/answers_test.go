@@ -0,0 +1,84 @@
+package handlers
+
+import (
+    "testing"
+
+    "telegram-gatekeeper/database"
+)
+
+func TestNormalizeAnswer(t *testing.T) {
+    tests := []struct {
+        in    string
+        steps []string
+        want  string
+    }{
+        {"  Washington,   D.C. ", answerSteps, "washington dc"},
+        {"Twenty-Nine", answerSteps, "29"},
+        {"двадцать девять", answerSteps, "29"},
+        {"one hundred and five", answerSteps, "105"},
+        {"two thousand twenty four", answerSteps, "2024"},
+        {"триста сорок два", answerSteps, "342"},
+        {"one two", answerSteps, "1 2"},
+        {"nineteen eighty", answerSteps, "19 80"},
+        {"Crème Brûlée", answerSteps, "creme brulee"},
+        {"Ёлка", answerSteps, "елка"},
+        {"ＰＡＲＩＳ", answerSteps, "paris"},
+        {"twenty-nine", []string{stepNumbers}, "29"},
+        {"Washington, D.C.", []string{stepNFKC}, "washington, d.c."},
+        {"Crème", nil, "crème"},
+    }
+
+    for _, tt := range tests {
+        if got := normalizeAnswer(tt.in, tt.steps); got != tt.want {
+            t.Errorf("normalizeAnswer(%q, %q) = %q, want %q", tt.in, tt.steps, got, tt.want)
+        }
+    }
+}
+
+func TestAnswerMatches(t *testing.T) {
+    capital := &database.Captcha{
+        Answer:       "Washington",
+        Alternatives: []string{"Washington DC"},
+        Check:        &database.AnswerCheck{Steps: answerSteps},
+    }
+    typos := &database.Captcha{
+        Answer: "Washington",
+        Check:  &database.AnswerCheck{Steps: answerSteps, Tolerance: 2},
+    }
+    number := &database.Captcha{Answer: "29", Check: &database.AnswerCheck{Steps: answerSteps}}
+    short := &database.Captcha{Answer: "Rome", Check: &database.AnswerCheck{Steps: answerSteps, Tolerance: 3}}
+    exact := &database.Captcha{Answer: "Washington DC", Check: &database.AnswerCheck{}}
+    math := &database.Captcha{Answer: "12"}
+
+    tests := []struct {
+        name    string
+        captcha *database.Captcha
+        answer  string
+        want    bool
+    }{
+        {"main answer", capital, "washington", true},
+        {"alternative", capital, "washington dc", true},
+        {"punctuated alternative", capital, "Washington, D.C.", true},
+        {"wrong", capital, "New York", false},
+        {"typo without a tolerance", capital, "Washingtn", false},
+        {"one typo", typos, "Washingtn", true},
+        {"two typos", typos, "Wahsington", true},
+        {"too many typos", typos, "Wshngtn", false},
+        {"number words", number, "twenty-nine", true},
+        {"russian number words", number, "Двадцать девять", true},
+        {"short answers forgive one typo", short, "Rone", true},
+        {"short answers forgive no more", short, "Rnne", false},
+        {"no steps compare exactly", exact, "washington dc", true},
+        {"no steps keep punctuation", exact, "Washington, D.C.", false},
+        {"captchas without a check", math, " 12 ", true},
+        {"captchas without a check are not normalized", math, "twelve", false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := answerMatches(tt.answer, tt.captcha); got != tt.want {
+                t.Errorf("answerMatches(%q) = %v, want %v", tt.answer, got, tt.want)
+            }
+        })
+    }
+}
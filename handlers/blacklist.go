@@ -0,0 +1,260 @@
+package handlers
+
+import (
+    "errors"
+    "fmt"
+    "html"
+    "log/slog"
+    "regexp"
+    "regexp/syntax"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "telegram-gatekeeper/database"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// How long the loaded blacklist is used before it is read from the database again
+const blacklistCacheTTL = 30 * time.Second
+
+// blacklistCache keeps the blacklist in memory, so that checking every update
+// does not cost a database round trip
+type blacklistCache struct {
+    mu        sync.Mutex
+    ids       map[int64]bool
+    usernames map[string]bool
+    patterns  []*regexp.Regexp
+    loadedAt  time.Time
+}
+
+// isBlacklisted reports whether updates from the user must be ignored
+func (h *BotHandler) isBlacklisted(user *tgbotapi.User) bool {
+    if user == nil || h.isStaff(user.ID) {
+        return false
+    }
+
+    c := &h.blacklist
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.ids == nil || time.Since(c.loadedAt) >= blacklistCacheTTL {
+        h.reloadBlacklistLocked()
+    }
+
+    if c.ids[user.ID] {
+        return true
+    }
+
+    username := strings.ToLower(user.UserName)
+    if username != "" && c.usernames[username] {
+        return true
+    }
+
+    fullName := strings.TrimSpace(user.FirstName + " " + user.LastName)
+    for _, pattern := range c.patterns {
+        if (username != "" && pattern.MatchString(username)) || pattern.MatchString(fullName) {
+            return true
+        }
+    }
+
+    return false
+}
+
+func (h *BotHandler) reloadBlacklistLocked() {
+    c := &h.blacklist
+
+    entries, err := h.db.GetBlacklist()
+    if err != nil {
+        slog.Error("Error loading blacklist", "error", err)
+        if c.ids == nil {
+            c.ids = map[int64]bool{}
+        }
+        return
+    }
+
+    c.ids = make(map[int64]bool)
+    c.usernames = make(map[string]bool)
+    c.patterns = nil
+
+    for _, entry := range entries {
+        switch entry.Kind {
+        case database.BlacklistByID:
+            c.ids[entry.TelegramID] = true
+        case database.BlacklistByUsername:
+            c.usernames[entry.Value] = true
+        case database.BlacklistByRegex:
+            pattern, err := compileBlacklistPattern(entry.Value)
+            if err != nil {
+                // The pattern, and the error quoting it, may hold personal data
+                code := ""
+                var syntaxErr *syntax.Error
+                if errors.As(err, &syntaxErr) {
+                    code = string(syntaxErr.Code)
+                }
+                slog.Warn("Invalid blacklist pattern", "entry_id", entry.ID.Hex(), "kind", entry.Kind, "error_code", code)
+                continue
+            }
+            c.patterns = append(c.patterns, pattern)
+        }
+    }
+
+    c.loadedAt = time.Now()
+}
+
+func (h *BotHandler) invalidateBlacklist() {
+    h.blacklist.mu.Lock()
+    h.blacklist.ids = nil
+    h.blacklist.mu.Unlock()
+}
+
+func compileBlacklistPattern(pattern string) (*regexp.Regexp, error) {
+    return regexp.Compile("(?i)" + pattern)
+}
+
+// parseBlacklistKey splits the command arguments into the entry key and the rest.
+// The key is a Telegram ID, @username or /regex/
+func parseBlacklistKey(args string) (*database.BlacklistEntry, string, error) {
+    args = strings.TrimSpace(args)
+
+    if strings.HasPrefix(args, "/") {
+        for i := 1; i < len(args); i++ {
+            if args[i] == '/' && (i == len(args)-1 || args[i+1] == ' ') {
+                pattern := args[1:i]
+                if pattern == "" {
+                    break
+                }
+                if _, err := compileBlacklistPattern(pattern); err != nil {
+                    return nil, "", fmt.Errorf("invalid regex: %v", err)
+                }
+                entry := &database.BlacklistEntry{Kind: database.BlacklistByRegex, Value: pattern}
+                return entry, strings.TrimSpace(args[i+1:]), nil
+            }
+        }
+        return nil, "", errors.New("a regex must be written as /pattern/")
+    }
+
+    key, rest, _ := strings.Cut(args, " ")
+    rest = strings.TrimSpace(rest)
+
+    if telegramID, err := strconv.ParseInt(key, 10, 64); err == nil {
+        entry := &database.BlacklistEntry{
+            Kind:       database.BlacklistByID,
+            Value:      key,
+            TelegramID: telegramID,
+        }
+        return entry, rest, nil
+    }
+
+    username := strings.ToLower(strings.TrimPrefix(key, "@"))
+    if username == "" {
+        return nil, "", errors.New("empty key")
+    }
+
+    return &database.BlacklistEntry{Kind: database.BlacklistByUsername, Value: username}, rest, nil
+}
+
+func (h *BotHandler) handleBanCommand(message *tgbotapi.Message) {
+    if strings.TrimSpace(message.CommandArguments()) == "" {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.ban.usage"))
+        return
+    }
+
+    entry, reason, err := parseBlacklistKey(message.CommandArguments())
+    if err != nil {
+        h.sendMessageHTML(message.Chat.ID, "❌ "+html.EscapeString(err.Error()))
+        return
+    }
+
+    if entry.Kind == database.BlacklistByID && h.isStaff(entry.TelegramID) {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.ban.staff"))
+        return
+    }
+
+    entry.Reason = reason
+    entry.AddedBy = message.From.ID
+
+    if err := h.db.AddBlacklistEntry(entry); err != nil {
+        slog.Error("Error adding blacklist entry", "error", err)
+        h.sendMessage(message.Chat.ID, h.staffText("error.server_message"))
+        return
+    }
+
+    h.invalidateBlacklist()
+
+    h.sendMessageHTML(message.Chat.ID, h.staffText("staff.ban.done", h.formatBlacklistEntry(entry)))
+}
+
+func (h *BotHandler) handleUnbanCommand(message *tgbotapi.Message) {
+    if strings.TrimSpace(message.CommandArguments()) == "" {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.unban.usage"))
+        return
+    }
+
+    entry, _, err := parseBlacklistKey(message.CommandArguments())
+    if err != nil {
+        h.sendMessageHTML(message.Chat.ID, "❌ "+html.EscapeString(err.Error()))
+        return
+    }
+
+    removed, err := h.db.RemoveBlacklistEntry(entry.Kind, entry.Value)
+    if err != nil {
+        slog.Error("Error removing blacklist entry", "error", err)
+        h.sendMessage(message.Chat.ID, h.staffText("error.server_message"))
+        return
+    }
+
+    if !removed {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.unban.missing", h.formatBlacklistEntry(entry)))
+        return
+    }
+
+    h.invalidateBlacklist()
+
+    h.sendMessageHTML(message.Chat.ID, h.staffText("staff.unban.done", h.formatBlacklistEntry(entry)))
+}
+
+func (h *BotHandler) handleBanlistCommand(message *tgbotapi.Message) {
+    entries, err := h.db.GetBlacklist()
+    if err != nil {
+        slog.Error("Error loading blacklist", "error", err)
+        h.sendMessage(message.Chat.ID, h.staffText("error.server_message"))
+        return
+    }
+
+    if len(entries) == 0 {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.banlist.empty"))
+        return
+    }
+
+    var sb strings.Builder
+    sb.WriteString(h.staffText("staff.banlist.title", len(entries)))
+
+    for i, entry := range entries {
+        fmt.Fprintf(&sb, "\n%d. %s", i+1, h.formatBlacklistEntry(&entry))
+        if entry.Reason != "" {
+            fmt.Fprintf(&sb, " — %s", html.EscapeString(entry.Reason))
+        }
+        fmt.Fprintf(&sb, "\n   <i>%s</i>", h.staffText("staff.banlist.added", entry.CreatedAt.Format("02.01.2006"), entry.AddedBy))
+
+        // Telegram does not accept messages longer than 4096 characters
+        if sb.Len() > 3800 && i < len(entries)-1 {
+            sb.WriteString("\n\n" + h.staffText("staff.and_more", len(entries)-i-1))
+            break
+        }
+    }
+
+    h.sendMessageHTML(message.Chat.ID, sb.String())
+}
+
+func (h *BotHandler) formatBlacklistEntry(entry *database.BlacklistEntry) string {
+    switch entry.Kind {
+    case database.BlacklistByID:
+        return fmt.Sprintf("ID <code>%d</code>", entry.TelegramID)
+    case database.BlacklistByUsername:
+        return "@" + html.EscapeString(entry.Value)
+    }
+    return h.staffText("staff.banlist.regex", html.EscapeString(entry.Value))
+}
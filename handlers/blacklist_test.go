@@ -0,0 +1,35 @@
+package handlers
+
+import (
+    "bytes"
+    "log/slog"
+    "strings"
+    "testing"
+
+    "telegram-gatekeeper/database"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestInvalidBlacklistPatternIsNotLogged(t *testing.T) {
+    handler, _, storage := newTestHandler()
+
+    var logs bytes.Buffer
+    previous := slog.Default()
+    slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+    t.Cleanup(func() { slog.SetDefault(previous) })
+
+    storage.AddBlacklistEntry(&database.BlacklistEntry{Kind: database.BlacklistByRegex, Value: "alice(smith", AddedBy: testAdminID})
+    entries, _ := storage.GetBlacklist()
+
+    if handler.isBlacklisted(&tgbotapi.User{ID: testUserID, FirstName: "alice(smith"}) {
+        t.Error("an invalid pattern matched")
+    }
+
+    if strings.Contains(logs.String(), "alice") {
+        t.Errorf("logs = %q, want the pattern left out", logs.String())
+    }
+    if !strings.Contains(logs.String(), "entry_id="+entries[0].ID.Hex()) {
+        t.Errorf("logs = %q, want the ID of the entry", logs.String())
+    }
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+    "context"
+    "errors"
+    "html"
+    "log/slog"
+    "strconv"
+    "strings"
+    "time"
+
+    "telegram-gatekeeper/database"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// blockUser blocks the user for the given duration (0 - forever) and returns
+// the time the block ends, nil if it never does
+func (h *BotHandler) blockUser(telegramID int64, duration time.Duration, reason string) (*time.Time, error) {
+    var until *time.Time
+    if duration > 0 {
+        t := time.Now().Add(duration)
+        until = &t
+    }
+
+    return until, h.db.BlockUser(telegramID, until, reason)
+}
+
+// liftExpiredBlock unblocks the user if the block has already expired but the
+// background job has not got to it yet. Reports whether the user is free now
+func (h *BotHandler) liftExpiredBlock(user *database.User) bool {
+    if user.BlockedUntil == nil || time.Now().Before(*user.BlockedUntil) {
+        return false
+    }
+
+    if err := h.db.UnblockUser(user.TelegramID); err != nil {
+        slog.Error("Error unblocking user", "user_id", user.TelegramID, "error", err)
+        return false
+    }
+
+    user.IsBlocked = false
+    user.BlockedUntil = nil
+    user.BlockReason = ""
+    user.VerificationAttempts = 0
+    user.CaptchaData = nil
+
+    h.syncAdminCards(user.TelegramID, h.staffText("card.block_expired"), nil)
+
+    return true
+}
+
+// RunBlockExpiry lifts expired blocks every interval until the context is cancelled
+func (h *BotHandler) RunBlockExpiry(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            h.liftExpiredBlocks()
+        }
+    }
+}
+
+func (h *BotHandler) liftExpiredBlocks() {
+    users, err := h.db.UnblockExpired(time.Now())
+    if err != nil {
+        slog.Error("Error lifting expired blocks", "error", err)
+    }
+
+    for _, user := range users {
+        slog.Info("Block has expired", "user_id", user.TelegramID)
+        h.syncAdminCards(user.TelegramID, h.staffText("card.block_expired"), nil)
+
+        if h.config.Defaults.NotifyUnblock {
+            h.sendMessage(user.TelegramID, h.tr(h.lang(&user), "user.block_expired"))
+        }
+    }
+}
+
+func (h *BotHandler) handleBlockCommand(message *tgbotapi.Message) {
+    args := strings.Fields(message.CommandArguments())
+    if len(args) == 0 {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.block.usage"))
+        return
+    }
+
+    telegramID, err := strconv.ParseInt(args[0], 10, 64)
+    if err != nil {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.invalid_id"))
+        return
+    }
+    args = args[1:]
+
+    // The duration is optional, without it the block_duration setting is used
+    duration := h.settings().BlockDuration
+    if len(args) > 0 {
+        if strings.EqualFold(args[0], "forever") || args[0] == "0" {
+            duration = 0
+            args = args[1:]
+        } else if d, err := parseDuration(args[0]); err == nil && d > 0 {
+            duration = d
+            args = args[1:]
+        }
+    }
+
+    reason := strings.Join(args, " ")
+    if reason == "" {
+        reason = h.staffText("reason.blocked_by_admin")
+    }
+
+    user, err := h.db.GetUserByTelegramID(telegramID)
+    if err != nil {
+        if errors.Is(err, database.ErrNotFound) {
+            h.sendMessage(message.Chat.ID, h.staffText("staff.user_not_found"))
+            return
+        }
+        slog.Error("Error getting user", "user_id", telegramID, "error", err)
+        h.sendMessage(message.Chat.ID, h.staffText("error.server_message"))
+        return
+    }
+
+    until, err := h.blockUser(telegramID, duration, reason)
+    if err != nil {
+        slog.Error("Error blocking user", "user_id", telegramID, "error", err)
+        h.sendMessage(message.Chat.ID, h.staffText("error.server_message"))
+        return
+    }
+
+    h.sendMessageHTML(message.Chat.ID, h.staffText("staff.block.done",
+        telegramID,
+        h.blockDescription(h.catalog.Fallback(), until),
+        html.EscapeString(reason),
+    ))
+
+    lang := h.lang(user)
+    h.sendMessage(telegramID, h.tr(lang, "user.blocked_by_admin")+h.blockedUntilText(lang, until))
+
+    status := h.staffText("card.blocked_by", staffName(message.From), h.blockDescription(h.catalog.Fallback(), until))
+    h.syncAdminCards(telegramID, status, nil)
+}
+
+func (h *BotHandler) handleUnblockCommand(message *tgbotapi.Message) {
+    args := strings.Fields(message.CommandArguments())
+    if len(args) != 1 {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.unblock.usage"))
+        return
+    }
+
+    telegramID, err := strconv.ParseInt(args[0], 10, 64)
+    if err != nil {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.invalid_id"))
+        return
+    }
+
+    user, err := h.db.GetUserByTelegramID(telegramID)
+    if err != nil {
+        if errors.Is(err, database.ErrNotFound) {
+            h.sendMessage(message.Chat.ID, h.staffText("staff.user_not_found"))
+            return
+        }
+        slog.Error("Error getting user", "user_id", telegramID, "error", err)
+        h.sendMessage(message.Chat.ID, h.staffText("error.server_message"))
+        return
+    }
+
+    if !user.IsBlocked {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.unblock.not_blocked", telegramID))
+        return
+    }
+
+    if err := h.db.UnblockUser(telegramID); err != nil {
+        slog.Error("Error unblocking user", "error", err)
+        h.sendMessage(message.Chat.ID, h.staffText("error.server_message"))
+        return
+    }
+
+    h.sendMessageHTML(message.Chat.ID, h.staffText("staff.unblock.done", telegramID))
+    h.sendMessage(telegramID, h.tr(h.lang(user), "user.unblocked"))
+
+    h.syncAdminCards(telegramID, h.staffText("card.unblocked_by", staffName(message.From)), nil)
+}
+
+// blockDescription describes the length of a block for admin cards
+func (h *BotHandler) blockDescription(lang string, until *time.Time) string {
+    if until == nil {
+        return h.tr(lang, "block.forever")
+    }
+
+    return h.tr(lang, "block.for",
+        h.formatRemaining(lang, time.Until(*until)),
+        until.Format("02.01.2006 15:04"),
+    )
+}
+
+// blockedUntilText tells the user when they can try again
+func (h *BotHandler) blockedUntilText(lang string, until *time.Time) string {
+    if until == nil {
+        return ""
+    }
+    return h.tr(lang, "block.try_again", h.formatRemaining(lang, time.Until(*until)))
+}
+
+// formatRemaining formats the time left as "2d 3h", "5h 10m" or "15m"
+func (h *BotHandler) formatRemaining(lang string, d time.Duration) string {
+    if d < time.Minute {
+        return h.tr(lang, "duration.less_than_minute")
+    }
+
+    d = d.Round(time.Minute)
+    days := int(d / (24 * time.Hour))
+    hours := int(d % (24 * time.Hour) / time.Hour)
+    minutes := int(d % time.Hour / time.Minute)
+
+    switch {
+    case days > 0 && hours > 0:
+        return h.tr(lang, "duration.days_hours", days, hours)
+    case days > 0:
+        return h.tr(lang, "duration.days", days)
+    case hours > 0 && minutes > 0:
+        return h.tr(lang, "duration.hours_minutes", hours, minutes)
+    case hours > 0:
+        return h.tr(lang, "duration.hours", hours)
+    }
+
+    return h.tr(lang, "duration.minutes", minutes)
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+    "errors"
+    "fmt"
+    "slices"
+    "strings"
+    "testing"
+    "time"
+
+    "telegram-gatekeeper/database"
+)
+
+// blockFailingStorage cannot store blocks
+type blockFailingStorage struct {
+    *database.MemoryStorage
+}
+
+func (s blockFailingStorage) BlockUser(telegramID int64, until *time.Time, reason string) error {
+    return errors.New("write failed")
+}
+
+func TestBlockCommandReportsStorageErrors(t *testing.T) {
+    handler, sender, storage := newTestHandler()
+    handler.db = blockFailingStorage{storage}
+
+    createUser(t, storage, true)
+    storage.SaveAdminCard(&database.AdminCard{UserID: testUserID, ChatID: testAdminID, MessageID: 50, Text: "User card"})
+
+    handler.HandleUpdate(privateMessage(testAdminID, fmt.Sprintf("/block %d spam", testUserID)))
+
+    assertSentContains(t, sender, testAdminID, "Server error")
+    if slices.ContainsFunc(sender.textsTo(testAdminID), func(text string) bool { return strings.Contains(text, "is blocked") }) {
+        t.Error("staff were told the user is blocked")
+    }
+    if texts := sender.textsTo(testUserID); len(texts) != 0 {
+        t.Errorf("user was sent %q", texts)
+    }
+    if cards, _ := storage.TakeAdminCards(testUserID); len(cards) != 1 {
+        t.Errorf("%d cards left, want the card untouched", len(cards))
+    }
+}
+
+func TestExpiredBlocksUpdateTheCards(t *testing.T) {
+    handler, sender, storage := newTestHandler()
+
+    createUser(t, storage, false)
+    until := time.Now().Add(-time.Minute)
+    storage.BlockUser(testUserID, &until, "spam")
+    storage.SaveAdminCard(&database.AdminCard{UserID: testUserID, ChatID: testAdminID, MessageID: 50, Text: "User card"})
+
+    handler.liftExpiredBlocks()
+
+    if getUser(t, storage).IsBlocked {
+        t.Error("user is still blocked")
+    }
+    assertSentContains(t, sender, testAdminID, "User card\n\n🔓 Block has expired")
+    if cards, _ := storage.TakeAdminCards(testUserID); len(cards) != 0 {
+        t.Errorf("%d cards were left", len(cards))
+    }
+}
@@ -1,10 +1,15 @@
 package handlers
 
 import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"log"
+	"html"
+	"log/slog"
 	"math/rand"
 	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"telegram-gatekeeper/database"
@@ -13,53 +18,147 @@ import (
 )
 
 func (h *BotHandler) sendNewCaptcha(chatID int64, user *database.User) {
-    captcha := h.generateCaptcha()
+    settings := h.settings()
+    lang := h.lang(user)
+    captcha := h.generateCaptcha(settings.CaptchaType, lang, settings.CaptchaTTL)
     
-    // Saving the captcha in the database
-    h.db.SaveCaptcha(user.TelegramID, captcha)
+    // The prompt set by the admin replaces the built-in one for every type
+    prompt, custom := h.customMessage(templateCaptcha, h.captchaTemplateData(user, captcha))
     
-    var msg tgbotapi.MessageConfig
+    var msg tgbotapi.Chattable
     
     switch captcha.Type {
     case "math":
-        msg = tgbotapi.NewMessage(chatID, 
-            fmt.Sprintf("🔐 *Security check*\n\nSolve the example:\n`%s`", captcha.Question),
+        textMsg := tgbotapi.NewMessage(chatID, 
+            h.captchaPrompt(lang, captcha),
         )
-        msg.ParseMode = "Markdown"
+        textMsg.ParseMode = "HTML"
+        msg = textMsg
         
     case "text":
-        msg = tgbotapi.NewMessage(chatID, 
-            fmt.Sprintf("🔐 *Security check*\n\nAnswer the question:\n%s", captcha.Question),
+        textMsg := tgbotapi.NewMessage(chatID, 
+            h.captchaPrompt(lang, captcha),
         )
-        msg.ParseMode = "Markdown"
+        textMsg.ParseMode = "HTML"
+        msg = textMsg
+        
+    case "image":
+        // The picture is rendered in memory for every captcha and never stored
+        picture, err := renderCaptchaImage(captcha.Answer)
+        if err != nil {
+            slog.Error("Error rendering captcha image", "user_id", user.TelegramID, "captcha_type", captcha.Type, "error", err)
+            return
+        }
+        
+        photoMsg := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "captcha.png", Bytes: picture})
+        photoMsg.Caption = h.captchaPrompt(lang, captcha)
+        photoMsg.ParseMode = "HTML"
+        if custom {
+            photoMsg.Caption, photoMsg.ParseMode = prompt, "HTML"
+        }
+        msg = photoMsg
         
     case "button":
-        msg = tgbotapi.NewMessage(chatID, 
-            "🔐 *Security check*\n\nChoose the correct answer:",
+        buttonMsg := tgbotapi.NewMessage(chatID, 
+            h.captchaPrompt(lang, captcha),
         )
-        msg.ParseMode = "Markdown"
+        buttonMsg.ParseMode = "HTML"
         
         // Creating buttons
         var rows [][]tgbotapi.InlineKeyboardButton
         for i, option := range captcha.Options {
-            callbackData := fmt.Sprintf("captcha_%d_%d", user.TelegramID, i)
-            button := tgbotapi.NewInlineKeyboardButtonData(option, callbackData)
+            payload := fmt.Sprintf("captcha_%d_%s_%d", user.TelegramID, captcha.Nonce, i)
+            button := h.callbackButton(option, payload, captcha.ExpiresAt)
             rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
         }
         
         keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
-        msg.ReplyMarkup = keyboard
+        buttonMsg.ReplyMarkup = keyboard
+        msg = buttonMsg
     }
     
-    _, err := h.bot.Send(msg)
+    if textMsg, ok := msg.(tgbotapi.MessageConfig); ok && custom {
+        textMsg.Text, textMsg.ParseMode = prompt, "HTML"
+        msg = textMsg
+    }
+    
+    sent, err := h.bot.Send(msg)
     if err != nil {
-        log.Printf("Error sending captcha: %v", err)
+        slog.Error("Error sending captcha", "user_id", user.TelegramID, "captcha_type", captcha.Type, "error", err)
+    } else {
+        // Remembered so that the sweeper can retire the message when it expires
+        captcha.ChatID = chatID
+        captcha.MessageID = sent.MessageID
+    }
+    
+    // Saving the captcha in the database
+    if err := h.saveCaptcha(user.TelegramID, captcha); err != nil {
+        slog.Error("Error saving captcha", "user_id", user.TelegramID, "captcha_type", captcha.Type, "error", err)
+    }
+}
+
+// captchaPrompt is the built-in prompt of the captcha in Telegram HTML. The
+// question is escaped, questions of the bank may contain any characters
+func (h *BotHandler) captchaPrompt(lang string, captcha *database.Captcha) string {
+    switch captcha.Type {
+    case "math", "text":
+        return h.tr(lang, "captcha."+captcha.Type, html.EscapeString(captcha.Question))
     }
+    return h.tr(lang, "captcha."+captcha.Type)
 }
 
-func (h *BotHandler) generateCaptcha() *database.Captcha {
-	captchaTypes := []string{"math", "text", "button"}
-	captchaType := captchaTypes[rand.Intn(len(captchaTypes))]
+// captchaTemplateData adds the captcha to the data of the prompt template
+func (h *BotHandler) captchaTemplateData(user *database.User, captcha *database.Captcha) templateData {
+    data := h.templateData(user)
+    if captcha.Type == "math" || captcha.Type == "text" {
+        data.Question = html.EscapeString(captcha.Question)
+    }
+    data.ExpiresIn = html.EscapeString(h.formatRemaining(h.lang(user), time.Until(captcha.ExpiresAt)))
+    return data
+}
+
+// parseCaptchaCallback splits captcha_<user ID>_<nonce>_<option index>
+func parseCaptchaCallback(data string) (telegramID int64, nonce string, optionIndex int, err error) {
+    parts := strings.Split(data, "_")
+    if len(parts) != 4 {
+        return 0, "", 0, fmt.Errorf("unexpected captcha callback %q", data)
+    }
+
+    telegramID, err = strconv.ParseInt(parts[1], 10, 64)
+    if err != nil {
+        return 0, "", 0, err
+    }
+
+    optionIndex, err = strconv.Atoi(parts[3])
+    if err != nil {
+        return 0, "", 0, err
+    }
+
+    return telegramID, parts[2], optionIndex, nil
+}
+
+// generateCaptcha returns a new captcha with a fresh nonce. Text questions
+// are drawn from the active questions in the language of the user
+func (h *BotHandler) generateCaptcha(captchaType, lang string, ttl time.Duration) *database.Captcha {
+    captcha := h.buildCaptcha(captchaType, lang, ttl)
+    captcha.Nonce = newCaptchaNonce()
+    return captcha
+}
+
+// newCaptchaNonce is short enough to fit into callback data next to the user
+// ID and the option index
+func newCaptchaNonce() string {
+    b := make([]byte, 8)
+    if _, err := cryptorand.Read(b); err != nil {
+        panic(err)
+    }
+    return hex.EncodeToString(b)
+}
+
+func (h *BotHandler) buildCaptcha(captchaType, lang string, ttl time.Duration) *database.Captcha {
+	if captchaType == "random" || !slices.Contains(captchaTypes, captchaType) {
+		captchaType = captchaTypes[rand.Intn(len(captchaTypes))]
+	}
 	
 	switch captchaType {
 	case "math":
@@ -70,7 +169,7 @@ func (h *BotHandler) generateCaptcha() *database.Captcha {
                 Question:  fmt.Sprintf("%d + %d", a, b),
                 Answer:    fmt.Sprintf("%d", a+b),
                 CreatedAt: time.Now(),
-                ExpiresAt: time.Now().Add(2 * time.Minute),
+                ExpiresAt: time.Now().Add(ttl),
             }
         }
 		
@@ -104,29 +203,38 @@ func (h *BotHandler) generateCaptcha() *database.Captcha {
 			Question:  question,
 			Answer:    answer,
 			CreatedAt: time.Now(),
-			ExpiresAt: time.Now().Add(2 * time.Minute),
+			ExpiresAt: time.Now().Add(ttl),
 		}
 		
 	case "text":
-		if len(h.config.Captcha.TextQuestions) == 0 {
+		q, ok := h.pickQuestion(lang)
+		if !ok {
             a, b := rand.Intn(10)+1, rand.Intn(10)+1
             return &database.Captcha{
                 Type:      "math",
                 Question:  fmt.Sprintf("%d + %d", a, b),
                 Answer:    fmt.Sprintf("%d", a+b),
                 CreatedAt: time.Now(),
-                ExpiresAt: time.Now().Add(2 * time.Minute),
+                ExpiresAt: time.Now().Add(ttl),
             }
         }
 		
-		q := h.config.Captcha.TextQuestions[rand.Intn(len(h.config.Captcha.TextQuestions))]
+		return &database.Captcha{
+			Type:         "text",
+			Question:     q.Question,
+			Answer:       q.Answer,
+			Alternatives: q.Alternatives,
+			Check:        answerCheckOf(q),
+			CreatedAt:    time.Now(),
+			ExpiresAt:    time.Now().Add(ttl),
+		}
 		
+	case "image":
 		return &database.Captcha{
-			Type:      "text",
-			Question:  q.Question,
-			Answer:    q.Answer,
+			Type:      "image",
+			Answer:    randomCaptchaCode(imageCaptchaLength),
 			CreatedAt: time.Now(),
-			ExpiresAt: time.Now().Add(2 * time.Minute),
+			ExpiresAt: time.Now().Add(ttl),
 		}
 		
 	case "button":
@@ -137,7 +245,7 @@ func (h *BotHandler) generateCaptcha() *database.Captcha {
                 Question:  fmt.Sprintf("%d + %d", a, b),
                 Answer:    fmt.Sprintf("%d", a+b),
                 CreatedAt: time.Now(),
-                ExpiresAt: time.Now().Add(2 * time.Minute),
+                ExpiresAt: time.Now().Add(ttl),
             }
         }
 		
@@ -169,7 +277,7 @@ func (h *BotHandler) generateCaptcha() *database.Captcha {
 			Answer:    correct,
 			Options:   options,
 			CreatedAt: time.Now(),
-			ExpiresAt: time.Now().Add(2 * time.Minute),
+			ExpiresAt: time.Now().Add(ttl),
 		}
 	}
 	
@@ -178,6 +286,6 @@ func (h *BotHandler) generateCaptcha() *database.Captcha {
         Question:  "2 + 2",
         Answer:    "4",
         CreatedAt: time.Now(),
-        ExpiresAt: time.Now().Add(2 * time.Minute),
+        ExpiresAt: time.Now().Add(ttl),
     }
 }
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/color"
+    "image/png"
+    "math"
+    "math/rand"
+)
+
+const (
+    imageCaptchaLength = 5
+
+    // Characters that are easy to tell apart: no 0/O, 1/I/L-like pairs
+    imageCaptchaAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+    glyphScale  = 6  // Pixels per font dot
+    glyphCell   = 44 // Horizontal space for one character
+    imageHeight = 90
+    imageMargin = 20
+    imageNoise  = 0.12 // Share of background pixels turned into noise
+    imageLines  = 5
+)
+
+// 5x7 bitmap font for imageCaptchaAlphabet
+var captchaFont = map[rune][7]string{
+    'A': {".###.", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+    'B': {"####.", "#...#", "#...#", "####.", "#...#", "#...#", "####."},
+    'C': {".###.", "#...#", "#....", "#....", "#....", "#...#", ".###."},
+    'D': {"####.", "#...#", "#...#", "#...#", "#...#", "#...#", "####."},
+    'E': {"#####", "#....", "#....", "####.", "#....", "#....", "#####"},
+    'F': {"#####", "#....", "#....", "####.", "#....", "#....", "#...."},
+    'G': {".###.", "#...#", "#....", "#.###", "#...#", "#...#", ".###."},
+    'H': {"#...#", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+    'J': {"..###", "...#.", "...#.", "...#.", "...#.", "#..#.", ".##.."},
+    'K': {"#...#", "#..#.", "#.#..", "##...", "#.#..", "#..#.", "#...#"},
+    'M': {"#...#", "##.##", "#.#.#", "#.#.#", "#...#", "#...#", "#...#"},
+    'N': {"#...#", "#...#", "##..#", "#.#.#", "#..##", "#...#", "#...#"},
+    'P': {"####.", "#...#", "#...#", "####.", "#....", "#....", "#...."},
+    'Q': {".###.", "#...#", "#...#", "#...#", "#.#.#", "#..#.", ".##.#"},
+    'R': {"####.", "#...#", "#...#", "####.", "#.#..", "#..#.", "#...#"},
+    'S': {".####", "#....", "#....", ".###.", "....#", "....#", "####."},
+    'T': {"#####", "..#..", "..#..", "..#..", "..#..", "..#..", "..#.."},
+    'U': {"#...#", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+    'V': {"#...#", "#...#", "#...#", "#...#", "#...#", ".#.#.", "..#.."},
+    'W': {"#...#", "#...#", "#...#", "#.#.#", "#.#.#", "#.#.#", ".#.#."},
+    'X': {"#...#", "#...#", ".#.#.", "..#..", ".#.#.", "#...#", "#...#"},
+    'Y': {"#...#", "#...#", ".#.#.", "..#..", "..#..", "..#..", "..#.."},
+    'Z': {"#####", "....#", "...#.", "..#..", ".#...", "#....", "#####"},
+    '2': {".###.", "#...#", "....#", "...#.", "..#..", ".#...", "#####"},
+    '3': {"#####", "...#.", "..#..", "...#.", "....#", "#...#", ".###."},
+    '4': {"...#.", "..##.", ".#.#.", "#..#.", "#####", "...#.", "...#."},
+    '5': {"#####", "#....", "####.", "....#", "....#", "#...#", ".###."},
+    '6': {"..##.", ".#...", "#....", "####.", "#...#", "#...#", ".###."},
+    '7': {"#####", "....#", "...#.", "..#..", ".#...", ".#...", ".#..."},
+    '8': {".###.", "#...#", "#...#", ".###.", "#...#", "#...#", ".###."},
+    '9': {".###.", "#...#", "#...#", ".####", "....#", "...#.", ".##.."},
+}
+
+func randomCaptchaCode(length int) string {
+    code := make([]byte, length)
+    for i := range code {
+        code[i] = imageCaptchaAlphabet[rand.Intn(len(imageCaptchaAlphabet))]
+    }
+    return string(code)
+}
+
+// renderCaptchaImage draws the code as a PNG with rotated characters,
+// noise and wavy lines, so that it cannot be read as plain text
+func renderCaptchaImage(code string) ([]byte, error) {
+    width := len(code)*glyphCell + 2*imageMargin
+    canvas := image.NewRGBA(image.Rect(0, 0, width, imageHeight))
+
+    // Light background
+    background := color.RGBA{uint8(225 + rand.Intn(30)), uint8(225 + rand.Intn(30)), uint8(225 + rand.Intn(30)), 255}
+    for y := 0; y < imageHeight; y++ {
+        for x := 0; x < width; x++ {
+            canvas.SetRGBA(x, y, background)
+        }
+    }
+
+    for i, char := range code {
+        glyph, ok := captchaFont[char]
+        if !ok {
+            return nil, fmt.Errorf("no glyph for %q", char)
+        }
+
+        centerX := float64(imageMargin + i*glyphCell + glyphCell/2 + rand.Intn(7) - 3)
+        centerY := float64(imageHeight/2 + rand.Intn(13) - 6)
+        angle := (rand.Float64()*50 - 25) * math.Pi / 180
+        ink := randomDarkColor()
+
+        drawGlyph(canvas, glyph, centerX, centerY, angle, ink)
+    }
+
+    for i := 0; i < imageLines; i++ {
+        drawWavyLine(canvas, randomDarkColor())
+    }
+
+    addNoise(canvas)
+
+    var buf bytes.Buffer
+    if err := png.Encode(&buf, warp(canvas)); err != nil {
+        return nil, err
+    }
+
+    return buf.Bytes(), nil
+}
+
+// drawGlyph paints the glyph rotated by angle around its center
+func drawGlyph(canvas *image.RGBA, glyph [7]string, centerX, centerY, angle float64, ink color.RGBA) {
+    sin, cos := math.Sincos(angle)
+    radius := int(4.5 * glyphScale)
+
+    for dy := -radius; dy <= radius; dy++ {
+        for dx := -radius; dx <= radius; dx++ {
+            // Inverse rotation: where in the glyph this pixel comes from
+            gx := (cos*float64(dx)+sin*float64(dy))/glyphScale + 2.5
+            gy := (-sin*float64(dx)+cos*float64(dy))/glyphScale + 3.5
+            if gx < 0 || gy < 0 || gx >= 5 || gy >= 7 {
+                continue
+            }
+
+            if glyph[int(gy)][int(gx)] == '#' {
+                canvas.SetRGBA(int(centerX)+dx, int(centerY)+dy, ink)
+            }
+        }
+    }
+}
+
+// drawWavyLine crosses the image with a thick sine-shaped line
+func drawWavyLine(canvas *image.RGBA, ink color.RGBA) {
+    bounds := canvas.Bounds()
+    baseY := float64(rand.Intn(bounds.Dy()))
+    amplitude := 5 + rand.Float64()*15
+    period := 40 + rand.Float64()*80
+    phase := rand.Float64() * 2 * math.Pi
+    slope := rand.Float64()*0.4 - 0.2
+
+    for x := bounds.Min.X; x < bounds.Max.X; x++ {
+        y := int(baseY + slope*float64(x) + amplitude*math.Sin(2*math.Pi*float64(x)/period+phase))
+        for t := 0; t < 2; t++ {
+            if (image.Point{X: x, Y: y + t}).In(bounds) {
+                canvas.SetRGBA(x, y+t, ink)
+            }
+        }
+    }
+}
+
+func addNoise(canvas *image.RGBA) {
+    bounds := canvas.Bounds()
+    dots := int(float64(bounds.Dx()*bounds.Dy()) * imageNoise)
+
+    for i := 0; i < dots; i++ {
+        x := rand.Intn(bounds.Dx())
+        y := rand.Intn(bounds.Dy())
+        canvas.SetRGBA(x, y, color.RGBA{uint8(rand.Intn(256)), uint8(rand.Intn(256)), uint8(rand.Intn(256)), 255})
+    }
+}
+
+// warp shifts every column vertically along a sine wave
+func warp(src *image.RGBA) *image.RGBA {
+    bounds := src.Bounds()
+    dst := image.NewRGBA(bounds)
+
+    amplitude := 3 + rand.Float64()*3
+    period := 60 + rand.Float64()*60
+    phase := rand.Float64() * 2 * math.Pi
+
+    for x := bounds.Min.X; x < bounds.Max.X; x++ {
+        shift := int(amplitude * math.Sin(2*math.Pi*float64(x)/period+phase))
+        for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+            sy := min(max(y+shift, bounds.Min.Y), bounds.Max.Y-1)
+            dst.SetRGBA(x, y, src.RGBAAt(x, sy))
+        }
+    }
+
+    return dst
+}
+
+func randomDarkColor() color.RGBA {
+    return color.RGBA{uint8(rand.Intn(120)), uint8(rand.Intn(120)), uint8(rand.Intn(120)), 255}
+}
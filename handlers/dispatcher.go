@@ -0,0 +1,199 @@
+package handlers
+
+import (
+    "context"
+    "log/slog"
+    "runtime/debug"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Dispatcher runs updates on a fixed number of workers. Updates from the same
+// user are queued and handled one after another, so they cannot race on the
+// user's captcha and attempts, while different users are handled in parallel
+type Dispatcher struct {
+    handle  func(tgbotapi.Update)
+    workers int
+
+    mu     sync.Mutex
+    queues map[int64][]tgbotapi.Update // A key is present while its user has queued or running updates
+
+    ready   chan int64    // Users with queued updates waiting for a worker
+    slots   chan struct{} // One slot per queued or running update
+    pending sync.WaitGroup
+    stopped sync.WaitGroup
+
+    busy      atomic.Int64
+    saturated atomic.Bool
+}
+
+// DispatcherStats is a snapshot of the dispatcher load
+type DispatcherStats struct {
+    Workers  int
+    Busy     int // Workers handling an update
+    Queued   int // Updates waiting or running
+    Capacity int
+    Users    int // Users with waiting or running updates
+}
+
+func NewDispatcher(handle func(tgbotapi.Update), workers, queueSize int) *Dispatcher {
+    d := &Dispatcher{
+        handle:  handle,
+        workers: workers,
+        queues:  make(map[int64][]tgbotapi.Update),
+        // Every user in ready has at least one queued update, so it never blocks
+        ready: make(chan int64, queueSize),
+        slots: make(chan struct{}, queueSize),
+    }
+
+    d.stopped.Add(workers)
+    for i := 0; i < workers; i++ {
+        go d.work()
+    }
+
+    return d
+}
+
+// updateKey returns the user the update belongs to, or the chat when the
+// update has no sender
+func updateKey(update *tgbotapi.Update) int64 {
+    if user := update.SentFrom(); user != nil {
+        return user.ID
+    }
+    if chat := update.FromChat(); chat != nil {
+        return chat.ID
+    }
+    return 0
+}
+
+// Submit queues the update. When the queue is full it waits for a free slot,
+// which slows down receiving instead of piling up goroutines. It returns false
+// if ctx is done first
+func (d *Dispatcher) Submit(ctx context.Context, update tgbotapi.Update) bool {
+    select {
+    case d.slots <- struct{}{}:
+        d.saturated.Store(false)
+    default:
+        if !d.saturated.Swap(true) {
+            slog.Warn("Update queue is full, waiting for workers", "capacity", cap(d.slots))
+        }
+
+        select {
+        case d.slots <- struct{}{}:
+        case <-ctx.Done():
+            return false
+        }
+    }
+
+    d.pending.Add(1)
+    key := updateKey(&update)
+
+    d.mu.Lock()
+    queue, active := d.queues[key]
+    d.queues[key] = append(queue, update)
+    if !active {
+        d.ready <- key
+    }
+    d.mu.Unlock()
+
+    return true
+}
+
+func (d *Dispatcher) work() {
+    defer d.stopped.Done()
+
+    for key := range d.ready {
+        d.mu.Lock()
+        queue := d.queues[key]
+        update := queue[0]
+        d.queues[key] = queue[1:]
+        d.mu.Unlock()
+
+        d.busy.Add(1)
+        d.run(update)
+        d.busy.Add(-1)
+
+        <-d.slots
+        d.pending.Done()
+
+        // The next update of this user goes to the back of the line, so one
+        // busy user cannot hold a worker forever
+        d.mu.Lock()
+        if len(d.queues[key]) == 0 {
+            delete(d.queues, key)
+        } else {
+            d.ready <- key
+        }
+        d.mu.Unlock()
+    }
+}
+
+// run handles one update, a panic is logged instead of killing the worker
+func (d *Dispatcher) run(update tgbotapi.Update) {
+    defer func() {
+        if r := recover(); r != nil {
+            slog.Error("Panic while handling update", "update_id", update.UpdateID, "panic", r, "stack", string(debug.Stack()))
+        }
+    }()
+
+    d.handle(update)
+}
+
+// Stats returns the current load of the dispatcher
+func (d *Dispatcher) Stats() DispatcherStats {
+    d.mu.Lock()
+    users := len(d.queues)
+    d.mu.Unlock()
+
+    return DispatcherStats{
+        Workers:  d.workers,
+        Busy:     int(d.busy.Load()),
+        Queued:   len(d.slots),
+        Capacity: cap(d.slots),
+        Users:    users,
+    }
+}
+
+// Shutdown waits up to timeout for the queued updates and stops the workers.
+// Submit must not be called any more. It reports whether everything was
+// handled in time
+func (d *Dispatcher) Shutdown(timeout time.Duration) bool {
+    done := make(chan struct{})
+    go func() {
+        d.pending.Wait()
+        close(done)
+    }()
+
+    select {
+    case <-done:
+    case <-time.After(timeout):
+        return false
+    }
+
+    close(d.ready)
+    d.stopped.Wait()
+    return true
+}
+
+// updateType names the kind of an update for metrics
+func updateType(update tgbotapi.Update) string {
+    switch {
+    case update.Message != nil:
+        return "message"
+    case update.EditedMessage != nil:
+        return "edited_message"
+    case update.CallbackQuery != nil:
+        return "callback_query"
+    case update.ChatMember != nil:
+        return "chat_member"
+    case update.MyChatMember != nil:
+        return "my_chat_member"
+    case update.ChatJoinRequest != nil:
+        return "chat_join_request"
+    default:
+        return "other"
+    }
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func updateFrom(userID int64, updateID int) tgbotapi.Update {
+    update := privateMessage(userID, "hello")
+    update.UpdateID = updateID
+    return update
+}
+
+func TestDispatcherKeepsOrderPerUser(t *testing.T) {
+    var mu sync.Mutex
+    handled := make(map[int64][]int)
+
+    dispatcher := NewDispatcher(func(update tgbotapi.Update) {
+        // Give other workers a chance to overtake
+        time.Sleep(time.Millisecond)
+
+        mu.Lock()
+        defer mu.Unlock()
+        userID := update.Message.From.ID
+        handled[userID] = append(handled[userID], update.UpdateID)
+    }, 4, 10)
+
+    for i := 0; i < 20; i++ {
+        for userID := int64(1); userID <= 3; userID++ {
+            dispatcher.Submit(context.Background(), updateFrom(userID, i))
+        }
+    }
+
+    if !dispatcher.Shutdown(5 * time.Second) {
+        t.Fatal("updates were not handled in time")
+    }
+
+    for userID := int64(1); userID <= 3; userID++ {
+        ids := handled[userID]
+        if len(ids) != 20 {
+            t.Fatalf("user %d: handled %d updates, want 20", userID, len(ids))
+        }
+        for i, id := range ids {
+            if id != i {
+                t.Fatalf("user %d: updates handled out of order: %v", userID, ids)
+            }
+        }
+    }
+}
+
+func TestDispatcherRunsUsersInParallel(t *testing.T) {
+    release := make(chan struct{})
+    started := make(chan int64, 2)
+
+    dispatcher := NewDispatcher(func(update tgbotapi.Update) {
+        started <- update.Message.From.ID
+        <-release
+    }, 2, 10)
+
+    dispatcher.Submit(context.Background(), updateFrom(1, 1))
+    dispatcher.Submit(context.Background(), updateFrom(1, 2))
+    dispatcher.Submit(context.Background(), updateFrom(2, 3))
+
+    // The second update of user 1 must wait, user 2 must not
+    for i := 0; i < 2; i++ {
+        select {
+        case <-started:
+        case <-time.After(time.Second):
+            t.Fatal("users were not handled in parallel")
+        }
+    }
+
+    stats := dispatcher.Stats()
+    if stats.Busy != 2 || stats.Queued != 3 || stats.Users != 2 {
+        t.Errorf("unexpected stats %+v", stats)
+    }
+
+    close(release)
+    <-started
+
+    if !dispatcher.Shutdown(time.Second) {
+        t.Fatal("updates were not handled in time")
+    }
+}
+
+func TestDispatcherSubmitWaitsForFreeSlot(t *testing.T) {
+    release := make(chan struct{})
+    dispatcher := NewDispatcher(func(update tgbotapi.Update) {
+        <-release
+    }, 1, 1)
+
+    dispatcher.Submit(context.Background(), updateFrom(1, 1))
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    if dispatcher.Submit(ctx, updateFrom(2, 2)) {
+        t.Error("submit to a full queue must wait")
+    }
+
+    close(release)
+    if !dispatcher.Shutdown(time.Second) {
+        t.Fatal("updates were not handled in time")
+    }
+}
@@ -0,0 +1,389 @@
+package handlers
+
+import (
+    "errors"
+    "fmt"
+    "html"
+    "log/slog"
+    "math/rand"
+    "strconv"
+    "time"
+
+    "telegram-gatekeeper/database"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// handleGroupMessage gatekeeps new members of a group. Everything else
+// in groups is left to the people
+func (h *BotHandler) handleGroupMessage(message *tgbotapi.Message) {
+    if !h.config.Group.Enabled || len(message.NewChatMembers) == 0 {
+        return
+    }
+
+    for i := range message.NewChatMembers {
+        member := &message.NewChatMembers[i]
+
+        // The bot itself and bots added by admins are not checked
+        if member.IsBot {
+            continue
+        }
+
+        h.handleNewChatMember(message, member)
+    }
+}
+
+func (h *BotHandler) handleNewChatMember(message *tgbotapi.Message, member *tgbotapi.User) {
+    chatID := message.Chat.ID
+
+    // Blacklisted users are removed without any feedback
+    if h.isBlacklisted(member) {
+        h.banChatMember(chatID, member.ID, 0)
+        h.deleteMessage(chatID, message.MessageID)
+        return
+    }
+
+    user, err := h.db.GetOrCreateUser(
+        member.ID,
+        member.UserName,
+        member.FirstName,
+        member.LastName,
+        member.LanguageCode,
+        member.IsBot,
+    )
+    if err != nil {
+        slog.Error("Error getting user from DB", "user_id", member.ID, "error", err)
+        return
+    }
+
+    if user.IsBlocked && !h.liftExpiredBlock(user) {
+        h.removeChatMember(chatID, member.ID)
+        h.deleteMessage(chatID, message.MessageID)
+        return
+    }
+
+    // Users who have already passed verification in private are trusted
+    if user.IsVerified {
+        return
+    }
+
+    if !h.restrictChatMember(chatID, member.ID) {
+        return
+    }
+
+    captcha := h.generateGroupCaptcha()
+    captcha.ChatID = chatID
+    captcha.JoinMessageID = message.MessageID
+
+    msg := tgbotapi.NewMessage(chatID, h.groupCaptchaText(h.lang(user), member, captcha))
+    msg.ParseMode = "HTML"
+    msg.ReplyMarkup = h.groupCaptchaKeyboard(member.ID, captcha)
+
+    sent, err := h.bot.Send(msg)
+    if err != nil {
+        slog.Error("Error sending group captcha", "chat_id", chatID, "user_id", member.ID, "captcha_type", captcha.Type, "error", err)
+        return
+    }
+    captcha.MessageID = sent.MessageID
+
+    // Unanswered captchas are picked up by the sweeper. Every group keeps its
+    // own captcha, the attempts start over with every join
+    pending := &database.GroupCaptcha{ChatID: chatID, TelegramID: member.ID, Captcha: *captcha}
+    if err := h.saveGroupCaptcha(pending); err != nil {
+        slog.Error("Error saving captcha", "chat_id", chatID, "user_id", member.ID, "captcha_type", captcha.Type, "error", err)
+    }
+}
+
+// generateGroupCaptcha always returns a captcha with buttons: answers typed
+// into the group would be seen by everyone
+func (h *BotHandler) generateGroupCaptcha() *database.Captcha {
+    captcha := h.generateCaptcha("button", h.catalog.Fallback(), h.settings().CaptchaTTL)
+    if len(captcha.Options) == 0 {
+        captcha.Options = numericOptions(captcha.Answer)
+    }
+    return captcha
+}
+
+// numericOptions builds four shuffled options around a numeric answer
+func numericOptions(answer string) []string {
+    correct, err := strconv.Atoi(answer)
+    if err != nil {
+        return []string{answer}
+    }
+
+    seen := map[int]bool{correct: true}
+    options := []string{answer}
+    for len(options) < 4 {
+        candidate := correct + rand.Intn(11) - 5
+        if candidate < 0 || seen[candidate] {
+            continue
+        }
+        seen[candidate] = true
+        options = append(options, strconv.Itoa(candidate))
+    }
+
+    rand.Shuffle(len(options), func(i, j int) {
+        options[i], options[j] = options[j], options[i]
+    })
+
+    return options
+}
+
+// groupCaptchaText greets the newcomer in their own language
+func (h *BotHandler) groupCaptchaText(lang string, member *tgbotapi.User, captcha *database.Captcha) string {
+    question := captcha.Question
+    if captcha.Type != "button" {
+        question = h.tr(lang, "group.question", question)
+    }
+
+    return h.tr(lang, "group.welcome",
+        member.ID,
+        html.EscapeString(member.FirstName),
+        h.formatRemaining(lang, time.Until(captcha.ExpiresAt)),
+        html.EscapeString(question),
+    )
+}
+
+func (h *BotHandler) groupCaptchaKeyboard(telegramID int64, captcha *database.Captcha) tgbotapi.InlineKeyboardMarkup {
+    var buttons []tgbotapi.InlineKeyboardButton
+    for i, option := range captcha.Options {
+        payload := fmt.Sprintf("captcha_%d_%s_%d", telegramID, captcha.Nonce, i)
+        buttons = append(buttons, h.callbackButton(option, payload, captcha.ExpiresAt))
+    }
+
+    // Two buttons per row
+    var rows [][]tgbotapi.InlineKeyboardButton
+    for i := 0; i < len(buttons); i += 2 {
+        end := min(i+2, len(buttons))
+        rows = append(rows, tgbotapi.NewInlineKeyboardRow(buttons[i:end]...))
+    }
+
+    return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (h *BotHandler) handleGroupCaptchaCallback(callback *tgbotapi.CallbackQuery) {
+    lang := h.langOf(callback.From)
+
+    telegramID, nonce, optionIndex, err := parseCaptchaCallback(callback.Data)
+    if err != nil {
+        h.answerCallback(callback.ID, h.tr(lang, "error.data"))
+        return
+    }
+
+    // Only the newcomer can answer their captcha
+    if callback.From.ID != telegramID {
+        h.answerCallback(callback.ID, h.tr(lang, "captcha.not_yours"))
+        return
+    }
+
+    chatID := callback.Message.Chat.ID
+
+    user, err := h.db.GetUserByTelegramID(telegramID)
+    if err != nil {
+        slog.Error("Error getting user", "user_id", telegramID, "error", err)
+        h.answerCallback(callback.ID, h.tr(lang, "error.receiving"))
+        return
+    }
+    lang = h.lang(user)
+
+    pending, err := h.db.GetGroupCaptcha(chatID, telegramID)
+    if err != nil && !errors.Is(err, database.ErrNotFound) {
+        slog.Error("Error getting group captcha", "chat_id", chatID, "user_id", telegramID, "error", err)
+        h.answerCallback(callback.ID, h.tr(lang, "error.receiving"))
+        return
+    }
+    if pending == nil || pending.Captcha.Nonce != nonce || pending.Captcha.MessageID != callback.Message.MessageID {
+        h.answerCallback(callback.ID, h.tr(lang, "captcha.outdated"))
+        return
+    }
+    captcha := &pending.Captcha
+
+    if time.Now().After(captcha.ExpiresAt) {
+        h.answerCallback(callback.ID, h.tr(lang, "captcha.expired_short"))
+        h.expireGroupCaptcha(pending)
+        return
+    }
+
+    if optionIndex < 0 || optionIndex >= len(captcha.Options) {
+        h.answerCallback(callback.ID, h.tr(lang, "error.index"))
+        return
+    }
+
+    // Consuming the captcha in one step, so a double tap counts once
+    correct := captcha.Options[optionIndex] == captcha.Answer
+    pending, err = h.db.TakeGroupCaptcha(chatID, telegramID, nonce)
+    if errors.Is(err, database.ErrNotFound) {
+        h.answerCallback(callback.ID, h.tr(lang, "captcha.outdated"))
+        return
+    }
+    if err != nil {
+        slog.Error("Error answering captcha", "chat_id", chatID, "user_id", telegramID, "captcha_type", captcha.Type, "error", err)
+        h.answerCallback(callback.ID, h.tr(lang, "error.server"))
+        return
+    }
+    h.recordCaptchaOutcome(captcha, captchaOutcome(correct))
+
+    if correct {
+        h.answerCallback(callback.ID, h.tr(lang, "group.right"))
+        h.passGroupCaptcha(user, captcha)
+        return
+    }
+
+    // Wrong answers count in this group only
+    attempts := pending.Attempts + 1
+    maxAttempts := h.settings().MaxAttempts
+
+    if attempts >= maxAttempts {
+        h.answerCallback(callback.ID, h.tr(lang, "captcha.attempts_exceeded"))
+        h.removeFromGroup(user, captcha, h.staffText("reason.attempts_exceeded"))
+        return
+    }
+
+    h.answerCallback(callback.ID, h.tr(lang, "captcha.wrong_short", maxAttempts-attempts, maxAttempts))
+
+    // A new question in the same message, the deadline stays the same
+    next := h.generateGroupCaptcha()
+    next.ChatID = captcha.ChatID
+    next.MessageID = captcha.MessageID
+    next.JoinMessageID = captcha.JoinMessageID
+    next.ExpiresAt = captcha.ExpiresAt
+
+    retry := &database.GroupCaptcha{ChatID: chatID, TelegramID: telegramID, Attempts: attempts, Captcha: *next}
+    if err := h.saveGroupCaptcha(retry); err != nil {
+        slog.Error("Error saving captcha", "chat_id", chatID, "user_id", telegramID, "captcha_type", next.Type, "error", err)
+    }
+
+    editMsg := tgbotapi.NewEditMessageTextAndMarkup(
+        chatID,
+        captcha.MessageID,
+        h.groupCaptchaText(lang, callback.From, next),
+        h.groupCaptchaKeyboard(telegramID, next),
+    )
+    editMsg.ParseMode = "HTML"
+    if _, err := h.bot.Send(editMsg); err != nil {
+        slog.Error("Error editing group captcha", "chat_id", chatID, "user_id", telegramID, "error", err)
+    }
+}
+
+// expireGroupCaptcha removes the user from the group when their captcha expired
+func (h *BotHandler) expireGroupCaptcha(pending *database.GroupCaptcha) {
+    captcha := &pending.Captcha
+
+    // Conditional, so that a captcha answered in the meantime is left alone
+    err := h.db.ExpireGroupCaptcha(pending.ChatID, pending.TelegramID, captcha.ExpiresAt)
+    if err != nil {
+        if !errors.Is(err, database.ErrNotFound) {
+            slog.Error("Error expiring group captcha", "chat_id", pending.ChatID, "user_id", pending.TelegramID, "error", err)
+        }
+        return
+    }
+    h.recordCaptchaOutcome(captcha, database.OutcomeTimeout)
+
+    user, err := h.db.GetUserByTelegramID(pending.TelegramID)
+    if err != nil {
+        slog.Error("Error getting user", "user_id", pending.TelegramID, "error", err)
+        h.removeChatMember(captcha.ChatID, pending.TelegramID)
+        h.cleanupGroupCaptcha(captcha)
+        return
+    }
+    h.removeFromGroup(user, captcha, h.staffText("reason.captcha_expired"))
+}
+
+// passGroupCaptcha lets in a user whose answer was already accepted. It
+// is valid for this group only, the user stays unverified in private
+func (h *BotHandler) passGroupCaptcha(user *database.User, captcha *database.Captcha) {
+    h.unrestrictChatMember(captcha.ChatID, user.TelegramID)
+    h.cleanupGroupCaptcha(captcha)
+
+    h.notifyAdmin(user, true, h.staffText("reason.joined_group"))
+}
+
+func (h *BotHandler) removeFromGroup(user *database.User, captcha *database.Captcha, reason string) {
+    h.removeChatMember(captcha.ChatID, user.TelegramID)
+    h.cleanupGroupCaptcha(captcha)
+
+    h.notifyAdmin(user, false, h.staffText("reason.removed_from_group", reason))
+}
+
+func (h *BotHandler) cleanupGroupCaptcha(captcha *database.Captcha) {
+    h.deleteMessage(captcha.ChatID, captcha.MessageID)
+    if captcha.JoinMessageID != 0 {
+        h.deleteMessage(captcha.ChatID, captcha.JoinMessageID)
+    }
+}
+
+// removeChatMember kicks or bans the user, depending on the group config
+func (h *BotHandler) removeChatMember(chatID, userID int64) {
+    if h.config.Group.FailAction == "ban" {
+        h.banChatMember(chatID, userID, h.settings().BlockDuration)
+        return
+    }
+
+    // A kick is a ban that is lifted right away
+    if !h.banChatMember(chatID, userID, 0) {
+        return
+    }
+
+    unban := tgbotapi.UnbanChatMemberConfig{
+        ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: chatID, UserID: userID},
+        OnlyIfBanned:     true,
+    }
+    if _, err := h.bot.Request(unban); err != nil {
+        slog.Error("Error unbanning chat member", "chat_id", chatID, "user_id", userID, "error", err)
+    }
+}
+
+// banChatMember bans the user for the given duration, 0 - forever
+func (h *BotHandler) banChatMember(chatID, userID int64, duration time.Duration) bool {
+    ban := tgbotapi.BanChatMemberConfig{
+        ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: chatID, UserID: userID},
+    }
+    if duration > 0 {
+        ban.UntilDate = time.Now().Add(duration).Unix()
+    }
+
+    if _, err := h.bot.Request(ban); err != nil {
+        slog.Error("Error banning chat member", "chat_id", chatID, "user_id", userID, "error", err)
+        return false
+    }
+    return true
+}
+
+// restrictChatMember takes away all rights until the captcha is passed
+func (h *BotHandler) restrictChatMember(chatID, userID int64) bool {
+    restrict := tgbotapi.RestrictChatMemberConfig{
+        ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: chatID, UserID: userID},
+        Permissions:      &tgbotapi.ChatPermissions{},
+    }
+
+    if _, err := h.bot.Request(restrict); err != nil {
+        slog.Error("Error restricting chat member", "chat_id", chatID, "user_id", userID, "error", err)
+        return false
+    }
+    return true
+}
+
+// unrestrictChatMember gives the rights back, the chat defaults still apply
+func (h *BotHandler) unrestrictChatMember(chatID, userID int64) {
+    restrict := tgbotapi.RestrictChatMemberConfig{
+        ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: chatID, UserID: userID},
+        Permissions: &tgbotapi.ChatPermissions{
+            CanSendMessages:       true,
+            CanSendMediaMessages:  true,
+            CanSendPolls:          true,
+            CanSendOtherMessages:  true,
+            CanAddWebPagePreviews: true,
+            CanInviteUsers:        true,
+        },
+    }
+
+    if _, err := h.bot.Request(restrict); err != nil {
+        slog.Error("Error lifting restrictions", "chat_id", chatID, "user_id", userID, "error", err)
+    }
+}
+
+func (h *BotHandler) deleteMessage(chatID int64, messageID int) {
+    if _, err := h.bot.Request(tgbotapi.NewDeleteMessage(chatID, messageID)); err != nil {
+        slog.Error("Error deleting message", "chat_id", chatID, "message_id", messageID, "error", err)
+    }
+}
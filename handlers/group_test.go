@@ -0,0 +1,117 @@
+package handlers
+
+import (
+    "fmt"
+    "slices"
+    "testing"
+    "time"
+
+    "telegram-gatekeeper/database"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+    testGroupA int64 = -1001
+    testGroupB int64 = -1002
+)
+
+// joinGroup is the test user joining the group
+func joinGroup(chatID int64) tgbotapi.Update {
+    user := tgbotapi.User{ID: testUserID, FirstName: "Alice", UserName: "alice"}
+    return tgbotapi.Update{Message: &tgbotapi.Message{
+        MessageID:      7,
+        From:           &user,
+        Chat:           &tgbotapi.Chat{ID: chatID, Type: "supergroup"},
+        Date:           int(time.Now().Unix()),
+        NewChatMembers: []tgbotapi.User{user},
+    }}
+}
+
+// answerGroupCaptcha presses the right or a wrong button of the captcha in the group
+func answerGroupCaptcha(t *testing.T, storage *database.MemoryStorage, chatID int64, correct bool) tgbotapi.Update {
+    t.Helper()
+
+    pending, err := storage.GetGroupCaptcha(chatID, testUserID)
+    if err != nil {
+        t.Fatalf("group captcha of %d: %v", chatID, err)
+    }
+    captcha := pending.Captcha
+    index := slices.IndexFunc(captcha.Options, func(option string) bool { return (option == captcha.Answer) == correct })
+
+    update := callbackQuery(testUserID, fmt.Sprintf("captcha_%d_%s_%d", testUserID, captcha.Nonce, index))
+    update.CallbackQuery.From.FirstName = "Alice"
+    update.CallbackQuery.Message.MessageID = captcha.MessageID
+    update.CallbackQuery.Message.Chat = &tgbotapi.Chat{ID: chatID, Type: "supergroup"}
+    return update
+}
+
+func TestGroupCaptchasAreKeptApart(t *testing.T) {
+    handler, _, storage := newTestHandler()
+    handler.config.Group.Enabled = true
+
+    handler.HandleUpdate(joinGroup(testGroupA))
+    handler.HandleUpdate(joinGroup(testGroupB))
+    handler.HandleUpdate(privateMessage(testUserID, "/verify"))
+
+    // Neither the second group nor the private captcha replaced the first one
+    for _, chatID := range []int64{testGroupA, testGroupB} {
+        if _, err := storage.GetGroupCaptcha(chatID, testUserID); err != nil {
+            t.Fatalf("captcha of %d: %v", chatID, err)
+        }
+    }
+    if getUser(t, storage).CaptchaData == nil {
+        t.Fatal("the private captcha was not saved")
+    }
+
+    // A wrong answer counts in its group only
+    handler.HandleUpdate(answerGroupCaptcha(t, storage, testGroupA, false))
+    if pending, _ := storage.GetGroupCaptcha(testGroupA, testUserID); pending == nil || pending.Attempts != 1 {
+        t.Errorf("group A = %+v, want a new captcha after 1 attempt", pending)
+    }
+    if user := getUser(t, storage); user.VerificationAttempts != 0 {
+        t.Errorf("private attempts = %d, want 0", user.VerificationAttempts)
+    }
+
+    handler.HandleUpdate(answerGroupCaptcha(t, storage, testGroupA, true))
+    if _, err := storage.GetGroupCaptcha(testGroupA, testUserID); err == nil {
+        t.Error("the answered captcha was kept")
+    }
+
+    // Passing in a group does not verify the user in private
+    user := getUser(t, storage)
+    if user.IsVerified || user.CaptchaData == nil {
+        t.Errorf("user = %+v, want unverified with the private captcha", user)
+    }
+    if _, err := storage.GetGroupCaptcha(testGroupB, testUserID); err != nil {
+        t.Errorf("captcha of group B: %v", err)
+    }
+}
+
+func TestSweeperExpiresGroupCaptchas(t *testing.T) {
+    handler, sender, storage := newTestHandler()
+    handler.config.Group.Enabled = true
+
+    handler.HandleUpdate(joinGroup(testGroupA))
+
+    pending, err := storage.GetGroupCaptcha(testGroupA, testUserID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    pending.Captcha.ExpiresAt = time.Now().Add(-time.Second)
+    storage.SaveGroupCaptcha(pending)
+
+    handler.sweepExpiredCaptchas()
+
+    if _, err := storage.GetGroupCaptcha(testGroupA, testUserID); err == nil {
+        t.Error("the expired captcha was kept")
+    }
+
+    banned := slices.ContainsFunc(sender.requests, func(c tgbotapi.Chattable) bool {
+        ban, ok := c.(tgbotapi.BanChatMemberConfig)
+        return ok && ban.ChatID == testGroupA && ban.UserID == testUserID
+    })
+    if !banned {
+        t.Error("the user was not removed from the group")
+    }
+}
@@ -0,0 +1,786 @@
+package handlers
+
+import (
+    "fmt"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "telegram-gatekeeper/config"
+    "telegram-gatekeeper/database"
+    "telegram-gatekeeper/i18n"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+    testAdminID int64 = 100
+    testUserID  int64 = 200
+    testNonce         = "0123456789abcdef"
+
+    testModeratorID int64 = 300
+    testViewerID    int64 = 400
+)
+
+// recordingSender is a fake Telegram client that remembers everything sent to it
+type recordingSender struct {
+    mu            sync.Mutex
+    sent          []tgbotapi.Chattable
+    requests      []tgbotapi.Chattable
+    nextMessageID int
+}
+
+func (r *recordingSender) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.sent = append(r.sent, c)
+    r.nextMessageID++
+
+    sent := tgbotapi.Message{
+        MessageID: r.nextMessageID,
+        Chat:      &tgbotapi.Chat{ID: chatIDOf(c)},
+    }
+    if m, ok := c.(tgbotapi.MessageConfig); ok {
+        sent.Text = m.Text
+    }
+    return sent, nil
+}
+
+func (r *recordingSender) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    r.requests = append(r.requests, c)
+    return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// textsTo returns the texts of all messages and edits sent to the chat
+func (r *recordingSender) textsTo(chatID int64) []string {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    var texts []string
+    for _, c := range r.sent {
+        if chatIDOf(c) != chatID {
+            continue
+        }
+        switch m := c.(type) {
+        case tgbotapi.MessageConfig:
+            texts = append(texts, m.Text)
+        case tgbotapi.EditMessageTextConfig:
+            texts = append(texts, m.Text)
+        case tgbotapi.PhotoConfig:
+            texts = append(texts, m.Caption)
+        }
+    }
+    return texts
+}
+
+// callbackAnswers returns the texts of all answered callback queries
+func (r *recordingSender) callbackAnswers() []string {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    var answers []string
+    for _, c := range r.requests {
+        if answer, ok := c.(tgbotapi.CallbackConfig); ok {
+            answers = append(answers, answer.Text)
+        }
+    }
+    return answers
+}
+
+func chatIDOf(c tgbotapi.Chattable) int64 {
+    switch m := c.(type) {
+    case tgbotapi.MessageConfig:
+        return m.ChatID
+    case tgbotapi.ForwardConfig:
+        return m.ChatID
+    case tgbotapi.PhotoConfig:
+        return m.ChatID
+    case tgbotapi.DocumentConfig:
+        return m.ChatID
+    case tgbotapi.VoiceConfig:
+        return m.ChatID
+    case tgbotapi.StickerConfig:
+        return m.ChatID
+    case tgbotapi.EditMessageTextConfig:
+        return m.ChatID
+    case tgbotapi.EditMessageCaptionConfig:
+        return m.ChatID
+    case tgbotapi.EditMessageReplyMarkupConfig:
+        return m.ChatID
+    }
+    return 0
+}
+
+func testConfig() *config.Config {
+    return &config.Config{
+        AdminID:     testAdminID,
+        CallbackTTL: time.Hour,
+        Defaults: config.Defaults{
+            MaxAttempts: 3,
+            CaptchaType: "math",
+            CaptchaTTL:  2 * time.Minute,
+            AutoForward: true,
+        },
+    }
+}
+
+func newTestHandler() (*BotHandler, *recordingSender, *database.MemoryStorage) {
+    sender := &recordingSender{}
+    storage := database.NewMemoryStorage()
+
+    catalog, err := i18n.Load("en")
+    if err != nil {
+        panic(err)
+    }
+
+    return NewBotHandler(sender, storage, testConfig(), catalog), sender, storage
+}
+
+func privateMessage(fromID int64, text string) tgbotapi.Update {
+    message := &tgbotapi.Message{
+        MessageID: 1,
+        From:      &tgbotapi.User{ID: fromID, FirstName: "Alice", UserName: "alice"},
+        Chat:      &tgbotapi.Chat{ID: fromID, Type: "private"},
+        Date:      int(time.Now().Unix()),
+        Text:      text,
+    }
+
+    if strings.HasPrefix(text, "/") {
+        command, _, _ := strings.Cut(text, " ")
+        message.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+    }
+
+    return tgbotapi.Update{Message: message}
+}
+
+// withLanguage sets the language the Telegram app of the sender reports
+func withLanguage(update tgbotapi.Update, languageCode string) tgbotapi.Update {
+    update.Message.From.LanguageCode = languageCode
+    return update
+}
+
+// adminReply is the admin replying to one of the bot's messages in the admin chat
+func adminReply(replyToID int, text string) tgbotapi.Update {
+    update := privateMessage(testAdminID, text)
+    update.Message.ReplyToMessage = &tgbotapi.Message{MessageID: replyToID}
+    return update
+}
+
+// callbackQuery is a press of a button with the payload, signed like the bot does
+func callbackQuery(fromID int64, payload string) tgbotapi.Update {
+    data := signCallbackData(deriveCallbackKey(testConfig()), payload, time.Now().Add(time.Minute))
+    return rawCallbackQuery(fromID, data)
+}
+
+func rawCallbackQuery(fromID int64, data string) tgbotapi.Update {
+    return tgbotapi.Update{
+        CallbackQuery: &tgbotapi.CallbackQuery{
+            ID:   "callback",
+            From: &tgbotapi.User{ID: fromID, FirstName: "Admin"},
+            Message: &tgbotapi.Message{
+                MessageID: 50,
+                Chat:      &tgbotapi.Chat{ID: fromID, Type: "private"},
+                Text:      "User card",
+            },
+            Data: data,
+        },
+    }
+}
+
+// createUser stores the test user, optionally verified
+func createUser(t *testing.T, storage *database.MemoryStorage, verified bool) {
+    t.Helper()
+
+    if _, err := storage.GetOrCreateUser(testUserID, "alice", "Alice", "", "", false); err != nil {
+        t.Fatalf("creating user: %v", err)
+    }
+    if verified {
+        storage.UpdateUserVerification(testUserID, true)
+    }
+}
+
+// addStaff stores a moderator and a viewer next to the owner from the config
+func addStaff(t *testing.T, storage *database.MemoryStorage) {
+    t.Helper()
+
+    for id, role := range map[int64]string{testModeratorID: database.RoleModerator, testViewerID: database.RoleViewer} {
+        err := storage.SaveStaffMember(&database.StaffMember{TelegramID: id, Role: role, Subscribed: true, AddedBy: testAdminID})
+        if err != nil {
+            t.Fatalf("adding staff: %v", err)
+        }
+    }
+}
+
+// giveCaptcha stores an active captcha with a known answer
+func giveCaptcha(t *testing.T, storage *database.MemoryStorage, attempts int) {
+    t.Helper()
+
+    createUser(t, storage, false)
+    for i := 0; i < attempts; i++ {
+        storage.IncrementAttempts(testUserID)
+    }
+
+    storage.SaveCaptcha(testUserID, &database.Captcha{
+        Type:      "math",
+        Question:  "40 + 2",
+        Answer:    "42",
+        Options:   []string{"41", "42"},
+        Nonce:     testNonce,
+        CreatedAt: time.Now(),
+        ExpiresAt: time.Now().Add(time.Minute),
+        ChatID:    testUserID,
+    })
+    storage.RecordCaptchaIssued(&database.CaptchaStat{
+        Nonce:      testNonce,
+        TelegramID: testUserID,
+        Type:       "math",
+        IssuedAt:   time.Now(),
+    })
+}
+
+func getUser(t *testing.T, storage *database.MemoryStorage) *database.User {
+    t.Helper()
+
+    user, err := storage.GetUserByTelegramID(testUserID)
+    if err != nil {
+        t.Fatalf("getting user: %v", err)
+    }
+    return user
+}
+
+func assertSentContains(t *testing.T, sender *recordingSender, chatID int64, want string) {
+    t.Helper()
+
+    texts := sender.textsTo(chatID)
+    for _, text := range texts {
+        if strings.Contains(text, want) {
+            return
+        }
+    }
+    t.Errorf("no message to %d contains %q, got %q", chatID, want, texts)
+}
+
+func TestHandleUpdate(t *testing.T) {
+    tests := []struct {
+        name    string
+        setup   func(t *testing.T, storage *database.MemoryStorage)
+        updates []tgbotapi.Update
+        check   func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage)
+    }{
+        {
+            name:    "start greets a new user",
+            updates: []tgbotapi.Update{privateMessage(testUserID, "/start")},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                assertSentContains(t, sender, testUserID, "Hi, Alice!")
+                assertSentContains(t, sender, testUserID, "/verify")
+                if getUser(t, storage).IsVerified {
+                    t.Error("new user must not be verified")
+                }
+            },
+        },
+        {
+            name: "start for a verified user",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                createUser(t, storage, true)
+            },
+            updates: []tgbotapi.Update{privateMessage(testUserID, "/start")},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                assertSentContains(t, sender, testUserID, "You have already been verified")
+            },
+        },
+        {
+            name:    "verify sends a captcha",
+            updates: []tgbotapi.Update{privateMessage(testUserID, "/verify")},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                assertSentContains(t, sender, testUserID, "Security check")
+
+                captcha := getUser(t, storage).CaptchaData
+                if captcha == nil {
+                    t.Fatal("captcha was not saved")
+                }
+                if captcha.Type != "math" || captcha.Answer == "" {
+                    t.Errorf("unexpected captcha %+v", captcha)
+                }
+                if captcha.MessageID == 0 {
+                    t.Error("captcha message ID was not saved")
+                }
+            },
+        },
+        {
+            name: "status shows attempts",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                createUser(t, storage, false)
+                storage.IncrementAttempts(testUserID)
+            },
+            updates: []tgbotapi.Update{privateMessage(testUserID, "/status")},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                assertSentContains(t, sender, testUserID, "Your status")
+                assertSentContains(t, sender, testUserID, "Attempts: 1/3")
+            },
+        },
+        {
+            name: "correct answer verifies the user",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                giveCaptcha(t, storage, 0)
+            },
+            updates: []tgbotapi.Update{privateMessage(testUserID, " 42 ")},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                if !getUser(t, storage).IsVerified {
+                    t.Error("user was not verified")
+                }
+                assertSentContains(t, sender, testUserID, "Verification passed")
+                assertSentContains(t, sender, testAdminID, "passed the test")
+            },
+        },
+        {
+            name: "incorrect answer costs an attempt",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                giveCaptcha(t, storage, 0)
+            },
+            updates: []tgbotapi.Update{privateMessage(testUserID, "41")},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                user := getUser(t, storage)
+                if user.IsVerified {
+                    t.Error("user must not be verified")
+                }
+                if user.VerificationAttempts != 1 {
+                    t.Errorf("attempts = %d, want 1", user.VerificationAttempts)
+                }
+                assertSentContains(t, sender, testUserID, "Attempts left: 2/3")
+            },
+        },
+        {
+            name: "last incorrect answer blocks the user",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                giveCaptcha(t, storage, 2)
+            },
+            updates: []tgbotapi.Update{
+                privateMessage(testUserID, "41"),
+                privateMessage(testUserID, "hello"),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                if !getUser(t, storage).IsBlocked {
+                    t.Error("user was not blocked")
+                }
+                assertSentContains(t, sender, testUserID, "Access blocked")
+                assertSentContains(t, sender, testUserID, "Your access is blocked")
+                assertSentContains(t, sender, testAdminID, "Number of attempts exceeded")
+            },
+        },
+        {
+            name: "a repeated wrong button press counts once",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                giveCaptcha(t, storage, 0)
+            },
+            updates: []tgbotapi.Update{
+                callbackQuery(testUserID, fmt.Sprintf("captcha_%d_%s_0", testUserID, testNonce)),
+                callbackQuery(testUserID, fmt.Sprintf("captcha_%d_%s_0", testUserID, testNonce)),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                if attempts := getUser(t, storage).VerificationAttempts; attempts != 1 {
+                    t.Errorf("attempts = %d, want 1", attempts)
+                }
+
+                answers := sender.callbackAnswers()
+                if len(answers) != 2 || answers[1] != "Captcha is outdated" {
+                    t.Errorf("callback answers = %q", answers)
+                }
+            },
+        },
+        {
+            name: "a correct button press is accepted once",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                giveCaptcha(t, storage, 0)
+            },
+            updates: []tgbotapi.Update{
+                callbackQuery(testUserID, fmt.Sprintf("captcha_%d_%s_1", testUserID, testNonce)),
+                callbackQuery(testUserID, fmt.Sprintf("captcha_%d_%s_1", testUserID, testNonce)),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                if !getUser(t, storage).IsVerified {
+                    t.Error("user was not verified")
+                }
+
+                answers := sender.callbackAnswers()
+                if len(answers) != 2 || answers[1] != "Captcha is outdated" {
+                    t.Errorf("callback answers = %q", answers)
+                }
+                if notices := len(sender.textsTo(testAdminID)); notices != 1 {
+                    t.Errorf("admin got %d notifications, want 1", notices)
+                }
+            },
+        },
+        {
+            name: "buttons of an older captcha are rejected",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                giveCaptcha(t, storage, 0)
+            },
+            updates: []tgbotapi.Update{
+                callbackQuery(testUserID, fmt.Sprintf("captcha_%d_%s_1", testUserID, "fedcba9876543210")),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                user := getUser(t, storage)
+                if user.IsVerified || user.CaptchaData == nil {
+                    t.Error("the current captcha must stay untouched")
+                }
+            },
+        },
+        {
+            name: "captchas can only be answered by their owner",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                giveCaptcha(t, storage, 0)
+            },
+            updates: []tgbotapi.Update{
+                callbackQuery(testUserID+1, fmt.Sprintf("captcha_%d_%s_1", testUserID, testNonce)),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                if getUser(t, storage).IsVerified {
+                    t.Error("user must not be verified by someone else")
+                }
+                answers := sender.callbackAnswers()
+                if len(answers) != 1 || answers[0] != "This check is not for you" {
+                    t.Errorf("callback answers = %q", answers)
+                }
+            },
+        },
+        {
+            name: "unsigned callback data is rejected",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                giveCaptcha(t, storage, 0)
+            },
+            updates: []tgbotapi.Update{
+                rawCallbackQuery(testUserID, fmt.Sprintf("captcha_%d_%s_1", testUserID, testNonce)),
+                rawCallbackQuery(testAdminID, fmt.Sprintf("accept_%d_zzzzzz_AAAAAAAAAAA", testUserID)),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                if getUser(t, storage).IsVerified {
+                    t.Error("user must not be verified")
+                }
+                answers := sender.callbackAnswers()
+                if len(answers) != 2 || answers[0] != "Data error" || answers[1] != "Data error" {
+                    t.Errorf("callback answers = %q", answers)
+                }
+            },
+        },
+        {
+            name: "expired callback data is rejected",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                createUser(t, storage, false)
+            },
+            updates: []tgbotapi.Update{
+                rawCallbackQuery(testAdminID, signCallbackData(deriveCallbackKey(testConfig()),
+                    fmt.Sprintf("accept_%d", testUserID), time.Now().Add(-time.Minute))),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                if getUser(t, storage).IsVerified {
+                    t.Error("user must not be verified")
+                }
+                answers := sender.callbackAnswers()
+                if len(answers) != 1 || answers[0] != "This button has expired" {
+                    t.Errorf("callback answers = %q", answers)
+                }
+            },
+        },
+        {
+            name: "admin buttons are ignored for other users",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                createUser(t, storage, false)
+            },
+            updates: []tgbotapi.Update{
+                callbackQuery(testUserID, fmt.Sprintf("accept_%d", testUserID)),
+                callbackQuery(testUserID, fmt.Sprintf("block_%d", testUserID)),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                user := getUser(t, storage)
+                if user.IsVerified || user.IsBlocked {
+                    t.Errorf("verified = %t, blocked = %t", user.IsVerified, user.IsBlocked)
+                }
+            },
+        },
+        {
+            name: "messages fan out to all subscribed staff",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                createUser(t, storage, true)
+                addStaff(t, storage)
+            },
+            updates: []tgbotapi.Update{privateMessage(testUserID, "Hello staff")},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                for _, id := range []int64{testAdminID, testModeratorID, testViewerID} {
+                    assertSentContains(t, sender, id, "Sender information")
+                }
+            },
+        },
+        {
+            name: "a moderator accepting a user updates the cards of the others",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                createUser(t, storage, false)
+                addStaff(t, storage)
+                for i, id := range []int64{testAdminID, testModeratorID} {
+                    storage.SaveAdminCard(&database.AdminCard{UserID: testUserID, ChatID: id, MessageID: 50 + i, Text: "User card"})
+                }
+            },
+            updates: []tgbotapi.Update{callbackQuery(testModeratorID, fmt.Sprintf("accept_%d", testUserID))},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                if !getUser(t, storage).IsVerified {
+                    t.Error("user was not verified")
+                }
+                assertSentContains(t, sender, testModeratorID, "Accepted by Admin")
+                assertSentContains(t, sender, testAdminID, "Accepted by Admin")
+
+                if cards, _ := storage.TakeAdminCards(testUserID); len(cards) != 0 {
+                    t.Errorf("%d cards were left", len(cards))
+                }
+            },
+        },
+        {
+            name: "viewers cannot accept users or change settings",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                createUser(t, storage, false)
+                addStaff(t, storage)
+            },
+            updates: []tgbotapi.Update{
+                callbackQuery(testViewerID, fmt.Sprintf("accept_%d", testUserID)),
+                privateMessage(testViewerID, "/set max_attempts 10"),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                if getUser(t, storage).IsVerified {
+                    t.Error("a viewer must not accept users")
+                }
+                assertSentContains(t, sender, testViewerID, "Your role does not allow this command")
+            },
+        },
+        {
+            name: "the owner manages the staff",
+            updates: []tgbotapi.Update{
+                privateMessage(testAdminID, fmt.Sprintf("/addmod %d viewer", testViewerID)),
+                privateMessage(testAdminID, "/staff"),
+                privateMessage(testAdminID, fmt.Sprintf("/removemod %d", testViewerID)),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                assertSentContains(t, sender, testViewerID, "added to the staff as a viewer")
+                assertSentContains(t, sender, testAdminID, fmt.Sprintf("<code>%d</code> — viewer", testViewerID))
+                assertSentContains(t, sender, testViewerID, "removed from the staff")
+
+                if staff, _ := storage.GetStaff(); len(staff) != 0 {
+                    t.Errorf("staff = %+v", staff)
+                }
+            },
+        },
+        {
+            name: "admin accepts a user",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                createUser(t, storage, false)
+            },
+            updates: []tgbotapi.Update{callbackQuery(testAdminID, fmt.Sprintf("accept_%d", testUserID))},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                if !getUser(t, storage).IsVerified {
+                    t.Error("user was not verified")
+                }
+                assertSentContains(t, sender, testAdminID, "Accepted by Admin")
+                assertSentContains(t, sender, testUserID, "has accepted your request")
+            },
+        },
+        {
+            name: "admin rejects a user",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                createUser(t, storage, false)
+            },
+            updates: []tgbotapi.Update{callbackQuery(testAdminID, fmt.Sprintf("reject_%d", testUserID))},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                if getUser(t, storage).IsVerified {
+                    t.Error("rejected user must not be verified")
+                }
+                assertSentContains(t, sender, testAdminID, "Rejected by Admin")
+                assertSentContains(t, sender, testUserID, "has rejected your communication request")
+            },
+        },
+        {
+            name: "admin blocks a user",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                createUser(t, storage, true)
+            },
+            updates: []tgbotapi.Update{callbackQuery(testAdminID, fmt.Sprintf("block_%d", testUserID))},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                user := getUser(t, storage)
+                if !user.IsBlocked || user.IsVerified {
+                    t.Errorf("blocked = %t, verified = %t", user.IsBlocked, user.IsVerified)
+                }
+                assertSentContains(t, sender, testAdminID, "Blocked by Admin forever")
+                assertSentContains(t, sender, testUserID, "has blocked your access")
+
+                answers := sender.callbackAnswers()
+                if len(answers) != 1 || answers[0] != "⛔ User is blocked" {
+                    t.Errorf("callback answers = %q", answers)
+                }
+            },
+        },
+        {
+            name: "unblocking a user updates the cards",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                createUser(t, storage, false)
+                storage.BlockUser(testUserID, nil, "spam")
+                storage.SaveAdminCard(&database.AdminCard{UserID: testUserID, ChatID: testAdminID, MessageID: 50, Text: "User card"})
+            },
+            updates: []tgbotapi.Update{privateMessage(testAdminID, fmt.Sprintf("/unblock %d", testUserID))},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                if getUser(t, storage).IsBlocked {
+                    t.Error("user is still blocked")
+                }
+                assertSentContains(t, sender, testAdminID, "User card\n\n🔓 Unblocked by")
+
+                if cards, _ := storage.TakeAdminCards(testUserID); len(cards) != 0 {
+                    t.Errorf("%d cards were left", len(cards))
+                }
+            },
+        },
+        {
+            name: "stats report captcha outcomes and messages",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                giveCaptcha(t, storage, 0)
+            },
+            updates: []tgbotapi.Update{
+                privateMessage(testUserID, "42"),
+                privateMessage(testUserID, "42"),
+                privateMessage(testUserID, "Hello admin"),
+                privateMessage(testAdminID, "/stats 30d"),
+                privateMessage(testAdminID, "/stats soon"),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                assertSentContains(t, sender, testAdminID, "Statistics for the last 30d")
+                // The admin is stored as a user on their first message too
+                assertSentContains(t, sender, testAdminID, "New users: 2")
+                assertSentContains(t, sender, testAdminID, "math: 1 issued, 100% passed")
+                assertSentContains(t, sender, testAdminID, "2 received, 2 forwarded, 0 replies")
+                assertSentContains(t, sender, testAdminID, "Usage: <code>/stats [period]</code>")
+            },
+        },
+        {
+            name:    "users are answered in the language of their Telegram app",
+            updates: []tgbotapi.Update{withLanguage(privateMessage(testUserID, "/start"), "ru-RU")},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                assertSentContains(t, sender, testUserID, "Привет, Alice!")
+                if code := getUser(t, storage).LanguageCode; code != "ru-RU" {
+                    t.Errorf("language code = %q, want ru-RU", code)
+                }
+            },
+        },
+        {
+            name:    "unsupported languages fall back to the default one",
+            updates: []tgbotapi.Update{withLanguage(privateMessage(testUserID, "/start"), "pt-br")},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                assertSentContains(t, sender, testUserID, "Hi, Alice!")
+            },
+        },
+        {
+            name: "language overrides the Telegram language",
+            updates: []tgbotapi.Update{
+                privateMessage(testUserID, "/language ru"),
+                privateMessage(testUserID, "/help"),
+                privateMessage(testUserID, "/language xx"),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                assertSentContains(t, sender, testUserID, "Язык изменён на русский")
+                assertSentContains(t, sender, testUserID, "Доступные команды")
+                assertSentContains(t, sender, testUserID, "Неизвестный язык")
+                if lang := getUser(t, storage).Language; lang != "ru" {
+                    t.Errorf("language = %q, want ru", lang)
+                }
+            },
+        },
+        {
+            name: "language auto follows the Telegram language again",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                createUser(t, storage, false)
+                storage.SetUserLanguage(testUserID, "ru")
+            },
+            updates: []tgbotapi.Update{privateMessage(testUserID, "/language auto")},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                assertSentContains(t, sender, testUserID, "now follows your Telegram settings")
+                if lang := getUser(t, storage).Language; lang != "" {
+                    t.Errorf("language = %q, want it cleared", lang)
+                }
+            },
+        },
+        {
+            name: "admin cards stay in the default language",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                createUser(t, storage, true)
+                storage.SetUserLanguage(testUserID, "ru")
+            },
+            updates: []tgbotapi.Update{privateMessage(testUserID, "Hello admin")},
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                assertSentContains(t, sender, testAdminID, "Sender information")
+                assertSentContains(t, sender, testUserID, "Ваше сообщение отправлено администратору")
+            },
+        },
+        {
+            name: "templates replace the built-in texts",
+            updates: []tgbotapi.Update{
+                privateMessage(testAdminID, "/template set welcome <b>Hello {{.FirstName}}</b>,\n{{.AttemptsLeft}} of {{.MaxAttempts}} attempts"),
+                privateMessage(testUserID, "/start"),
+                privateMessage(testAdminID, "/template reset welcome"),
+                privateMessage(testUserID, "/start"),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                assertSentContains(t, sender, testAdminID, "is saved")
+                assertSentContains(t, sender, testUserID, "<b>Hello Alice</b>,\n3 of 3 attempts")
+                assertSentContains(t, sender, testUserID, "Hi, Alice!")
+            },
+        },
+        {
+            name: "invalid templates are not saved",
+            updates: []tgbotapi.Update{
+                privateMessage(testAdminID, "/template set welcome Hi {{.Nickname}}"),
+                privateMessage(testAdminID, "/template set welcome <b>Hi {{.FirstName}}"),
+                privateMessage(testAdminID, "/template set captcha Please solve"),
+                privateMessage(testUserID, "/start"),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                assertSentContains(t, sender, testAdminID, "unknown placeholder {{.Nickname}}")
+                assertSentContains(t, sender, testAdminID, "&lt;b&gt; is not closed")
+                assertSentContains(t, sender, testAdminID, "must show the question")
+                if settings, _ := storage.GetSettings(testAdminID); settings != nil && len(settings.Templates) > 0 {
+                    t.Errorf("templates = %q, want none saved", settings.Templates)
+                }
+                assertSentContains(t, sender, testUserID, "Hi, Alice!")
+            },
+        },
+        {
+            name: "messages of verified users reach the admin and replies come back",
+            setup: func(t *testing.T, storage *database.MemoryStorage) {
+                createUser(t, storage, true)
+            },
+            updates: []tgbotapi.Update{
+                privateMessage(testUserID, "Hello admin"),
+                // The forward gets message ID 1 and the sender information card ID 2
+                adminReply(2, "Hi Alice"),
+            },
+            check: func(t *testing.T, sender *recordingSender, storage *database.MemoryStorage) {
+                assertSentContains(t, sender, testAdminID, "Hello admin")
+                assertSentContains(t, sender, testUserID, "has been sent to the administrator")
+                assertSentContains(t, sender, testUserID, "Hi Alice")
+                assertSentContains(t, sender, testAdminID, "Reply delivered")
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            handler, sender, storage := newTestHandler()
+            if tt.setup != nil {
+                tt.setup(t, storage)
+            }
+
+            for _, update := range tt.updates {
+                handler.HandleUpdate(update)
+            }
+
+            tt.check(t, sender, storage)
+        })
+    }
+}
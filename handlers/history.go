@@ -0,0 +1,212 @@
+package handlers
+
+import (
+    "errors"
+    "fmt"
+    "html"
+    "log/slog"
+    "strconv"
+    "strings"
+
+    "telegram-gatekeeper/database"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+    historyPageSize  = 10
+    historyTextLimit = 300
+)
+
+// recordMessage stores a message of the conversation between the user and the admin
+func (h *BotHandler) recordMessage(message *tgbotapi.Message, user *database.User, fromAdmin bool, forwardedTo []int64) {
+    contentType, text := messageContent(message)
+
+    err := h.db.SaveMessage(&database.Message{
+        TelegramID:  message.MessageID,
+        UserID:      user.ID,
+        Text:        text,
+        ContentType: contentType,
+        FromAdmin:   fromAdmin,
+        IsForwarded: len(forwardedTo) > 0,
+        ForwardedTo: forwardedTo,
+    })
+    if err != nil {
+        slog.Error("Error saving message", "user_id", user.TelegramID, "error", err)
+    }
+}
+
+// messageContent returns the type of the message and its text or caption
+func messageContent(message *tgbotapi.Message) (string, string) {
+    switch {
+    case message.Text != "":
+        return "text", message.Text
+    case len(message.Photo) > 0:
+        return "photo", message.Caption
+    case message.Document != nil:
+        return "document", message.Caption
+    case message.Voice != nil:
+        return "voice", message.Caption
+    case message.Sticker != nil:
+        return "sticker", message.Sticker.Emoji
+    }
+
+    return "other", message.Caption
+}
+
+func (h *BotHandler) handleHistoryCommand(message *tgbotapi.Message) {
+    args := strings.Fields(message.CommandArguments())
+    if len(args) == 0 || len(args) > 2 {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.history.usage"))
+        return
+    }
+
+    telegramID, err := strconv.ParseInt(args[0], 10, 64)
+    if err != nil {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.invalid_id"))
+        return
+    }
+
+    page := 1
+    if len(args) == 2 {
+        page, err = strconv.Atoi(args[1])
+        if err != nil || page < 1 {
+            h.sendMessage(message.Chat.ID, h.staffText("staff.history.invalid_page"))
+            return
+        }
+    }
+
+    text, keyboard, err := h.renderHistory(telegramID, page)
+    if err != nil {
+        h.sendMessage(message.Chat.ID, err.Error())
+        return
+    }
+
+    msg := tgbotapi.NewMessage(message.Chat.ID, text)
+    msg.ParseMode = "HTML"
+    if keyboard != nil {
+        msg.ReplyMarkup = *keyboard
+    }
+
+    _, err = h.bot.Send(msg)
+    if err != nil {
+        slog.Error("Error sending history", "error", err)
+    }
+}
+
+func (h *BotHandler) handleHistoryCallback(callback *tgbotapi.CallbackQuery) {
+    parts := strings.Split(callback.Data, "_")
+    if len(parts) != 3 {
+        h.answerCallback(callback.ID, h.staffText("error.data"))
+        return
+    }
+
+    telegramID, err := strconv.ParseInt(parts[1], 10, 64)
+    if err != nil {
+        h.answerCallback(callback.ID, h.staffText("error.id"))
+        return
+    }
+
+    page, err := strconv.Atoi(parts[2])
+    if err != nil {
+        h.answerCallback(callback.ID, h.staffText("staff.history.page_error"))
+        return
+    }
+
+    text, keyboard, err := h.renderHistory(telegramID, page)
+    if err != nil {
+        h.answerCallback(callback.ID, err.Error())
+        return
+    }
+
+    editMsg := tgbotapi.NewEditMessageText(
+        callback.Message.Chat.ID,
+        callback.Message.MessageID,
+        text,
+    )
+    editMsg.ParseMode = "HTML"
+    editMsg.ReplyMarkup = keyboard
+
+    _, err = h.bot.Send(editMsg)
+    if err != nil {
+        slog.Error("Error editing history", "error", err)
+    }
+
+    h.answerCallback(callback.ID, h.staffText("staff.history.page", page))
+}
+
+// renderHistory builds one page of the conversation and the paging buttons
+func (h *BotHandler) renderHistory(telegramID int64, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+    user, err := h.db.GetUserByTelegramID(telegramID)
+    if err != nil {
+        if errors.Is(err, database.ErrNotFound) {
+            return "", nil, errors.New(h.staffText("staff.user_not_found"))
+        }
+        slog.Error("Error getting user", "user_id", telegramID, "error", err)
+        return "", nil, errors.New(h.staffText("error.server_message"))
+    }
+
+    messages, total, err := h.db.GetUserMessages(user.ID, page, historyPageSize)
+    if err != nil {
+        slog.Error("Error getting messages", "error", err)
+        return "", nil, errors.New(h.staffText("error.server_message"))
+    }
+
+    pages := int((total + historyPageSize - 1) / historyPageSize)
+    if pages == 0 {
+        pages = 1
+    }
+
+    var sb strings.Builder
+    sb.WriteString(h.staffText("staff.history.header", html.EscapeString(user.FirstName), user.TelegramID, page, pages, total))
+
+    if len(messages) == 0 {
+        sb.WriteString("\n" + h.staffText("staff.history.empty"))
+    }
+
+    // The page is loaded newest first, but reads better in chronological order
+    for i := len(messages) - 1; i >= 0; i-- {
+        m := messages[i]
+
+        author := "👤"
+        if m.FromAdmin {
+            author = h.staffText("staff.history.admin")
+        }
+
+        text := m.Text
+        if m.ContentType != "" && m.ContentType != "text" {
+            text = strings.TrimSpace("[" + m.ContentType + "] " + text)
+        }
+
+        fmt.Fprintf(&sb, "\n<i>%s</i> %s: %s",
+            m.CreatedAt.Format("02.01 15:04"),
+            author,
+            html.EscapeString(truncateText(text, historyTextLimit)),
+        )
+    }
+
+    var buttons []tgbotapi.InlineKeyboardButton
+    if page > 1 {
+        buttons = append(buttons, h.adminButton(h.staffText("staff.history.newer"),
+            fmt.Sprintf("history_%d_%d", user.TelegramID, page-1)))
+    }
+    if page < pages {
+        buttons = append(buttons, h.adminButton(h.staffText("staff.history.older"),
+            fmt.Sprintf("history_%d_%d", user.TelegramID, page+1)))
+    }
+
+    if len(buttons) == 0 {
+        return sb.String(), nil, nil
+    }
+
+    keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(buttons...))
+    return sb.String(), &keyboard, nil
+}
+
+func truncateText(text string, limit int) string {
+    runes := []rune(text)
+    if len(runes) <= limit {
+        return text
+    }
+    return string(runes[:limit]) + "…"
+}
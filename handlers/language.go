@@ -0,0 +1,85 @@
+package handlers
+
+import (
+    "fmt"
+    "log/slog"
+    "strings"
+    "time"
+
+    "telegram-gatekeeper/database"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// lang returns the locale of the user: the one chosen with /language, then
+// the language of their Telegram app, then the default one
+func (h *BotHandler) lang(user *database.User) string {
+    return h.catalog.Match(user.Language, user.LanguageCode)
+}
+
+// langOf is for answers given before the user is loaded from the database
+func (h *BotHandler) langOf(from *tgbotapi.User) string {
+    return h.catalog.Match(from.LanguageCode)
+}
+
+// tr returns the text of the key in the locale
+func (h *BotHandler) tr(lang, key string, args ...any) string {
+    return h.catalog.T(lang, key, args...)
+}
+
+// staffText returns a text of admin cards, notifications and replies to staff.
+// Staff share the cards, so they are always in the default language
+func (h *BotHandler) staffText(key string, args ...any) string {
+    return h.catalog.T(h.catalog.Fallback(), key, args...)
+}
+
+// attemptsBlockedReason is the reason staff are notified with when a user is
+// blocked for failing the captcha too many times
+func (h *BotHandler) attemptsBlockedReason(until *time.Time) string {
+    return h.staffText("reason.attempts_blocked", h.blockDescription(h.catalog.Fallback(), until))
+}
+
+func (h *BotHandler) handleLanguageCommand(message *tgbotapi.Message, user *database.User) {
+    chatID := message.Chat.ID
+    arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+
+    switch {
+    case arg == "":
+        lang := h.lang(user)
+        h.sendMessageHTML(chatID, h.tr(lang, "language.current", h.tr(lang, "language.name"), h.languageList()))
+        return
+
+    case arg == "auto":
+        if err := h.db.SetUserLanguage(user.TelegramID, ""); err != nil {
+            slog.Error("Error resetting language", "user_id", user.TelegramID, "error", err)
+            h.sendMessage(chatID, h.tr(h.lang(user), "error.server_message"))
+            return
+        }
+
+        user.Language = ""
+        h.sendMessage(chatID, h.tr(h.lang(user), "language.auto"))
+        return
+
+    case !h.catalog.Has(arg):
+        h.sendMessageHTML(chatID, h.tr(h.lang(user), "language.unknown", h.languageList()))
+        return
+    }
+
+    if err := h.db.SetUserLanguage(user.TelegramID, arg); err != nil {
+        slog.Error("Error setting language", "user_id", user.TelegramID, "language", arg, "error", err)
+        h.sendMessage(chatID, h.tr(h.lang(user), "error.server_message"))
+        return
+    }
+
+    // Answered in the new language already
+    h.sendMessage(chatID, h.tr(arg, "language.changed"))
+}
+
+// languageList lists the supported locales as "<code>en</code> English, ..."
+func (h *BotHandler) languageList() string {
+    var items []string
+    for _, locale := range h.catalog.Locales() {
+        items = append(items, fmt.Sprintf("<code>%s</code> %s", locale, h.tr(locale, "language.name")))
+    }
+    return strings.Join(items, ", ")
+}
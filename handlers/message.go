@@ -1,37 +1,73 @@
 package handlers
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"html"
-	"log"
+	"log/slog"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"telegram-gatekeeper/config"
 	"telegram-gatekeeper/database"
+	"telegram-gatekeeper/i18n"
+	"telegram-gatekeeper/metrics"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
+// Sender is the part of the Telegram Bot API the handlers use.
+// *tgbotapi.BotAPI implements it
+type Sender interface {
+    Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+    Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+}
+
 type BotHandler struct {
-    bot     *tgbotapi.BotAPI
-    db      *database.MongoDB
+    bot     Sender
+    db      database.Storage
     adminID int64
     config *config.Config
+    catalog *i18n.Catalog // Texts of the bot, see language.go
+
+    // Admin settings cache, see settings()
+    settingsMu       sync.Mutex
+    settingsCache    *adminSettings
+    settingsLoadedAt time.Time
+
+    blacklist blacklistCache
+    staff     staffCache
+    questions questionCache
+
+    callbackKey []byte // Signs callback data, see signing.go
 }
 
-func NewBotHandler(bot *tgbotapi.BotAPI, db *database.MongoDB, adminID int64) *BotHandler {
+func NewBotHandler(bot Sender, db database.Storage, cfg *config.Config, catalog *i18n.Catalog) *BotHandler {
     return &BotHandler{
         bot:     bot,
         db:      db,
-        adminID: adminID,
+        adminID: cfg.AdminID,
+        config:  cfg,
+        catalog: catalog,
+
+        callbackKey: deriveCallbackKey(cfg),
     }
 }
 
 func (h *BotHandler) HandleUpdate(update tgbotapi.Update) {
+    defer metrics.ObserveUpdate(updateType(update), time.Now())
+
+    attrs := []any{"update_id", update.UpdateID, "type", updateType(update)}
+    if user := update.SentFrom(); user != nil {
+        attrs = append(attrs, "user_id", user.ID)
+    }
+    if chat := update.FromChat(); chat != nil {
+        attrs = append(attrs, "chat_id", chat.ID)
+    }
+    slog.Debug("Update received", attrs...)
+
     if update.Message != nil {
         h.handleMessage(update.Message)
     } else if update.CallbackQuery != nil {
@@ -43,8 +79,36 @@ func (h *BotHandler) handleMessage(message *tgbotapi.Message) {
     user := message.From
     chatID := message.Chat.ID
 
-    log.Printf("Message from %s (%d): %s", user.FirstName, user.ID, message.Text)
-    log.Printf("Chat ID: %d, Message Type: %T", chatID, message)
+    // Groups are only gatekept for new members
+    if !message.Chat.IsPrivate() && chatID != h.adminID {
+        h.handleGroupMessage(message)
+        return
+    }
+
+    // Blacklisted users get no feedback at all
+    if h.isBlacklisted(user) {
+        return
+    }
+
+    slog.Debug("Message received",
+        "user_id", user.ID,
+        "chat_id", chatID,
+        "message_id", message.MessageID,
+        "name", user.FirstName,
+        "text", message.Text,
+    )
+
+    // Staff import questions by sending a document captioned /questions import
+    if message.Document != nil && isQuestionImport(message.Caption) && h.can(user.ID, PermSettings) {
+        h.importQuestions(chatID, user.ID, message.Document)
+        return
+    }
+
+    // Staff replies are relayed back to the original user
+    if message.ReplyToMessage != nil && !message.IsCommand() && h.can(user.ID, PermReply) {
+        h.handleAdminReply(message)
+        return
+    }
 
     // Getting or creating a user in the database
     dbUser, err := h.db.GetOrCreateUser(
@@ -52,11 +116,12 @@ func (h *BotHandler) handleMessage(message *tgbotapi.Message) {
         user.UserName,
         user.FirstName,
         user.LastName,
+        user.LanguageCode,
         user.IsBot,
     )
 
     if err != nil {
-        log.Printf("Error getting user from DB: %v", err)
+        slog.Error("Error getting user from DB", "error", err)
         return
     }
 
@@ -67,8 +132,8 @@ func (h *BotHandler) handleMessage(message *tgbotapi.Message) {
     }
 
     // Checking the lock
-    if dbUser.IsBlocked {
-        h.sendBlockedMessage(chatID)
+    if dbUser.IsBlocked && !h.liftExpiredBlock(dbUser) {
+        h.sendBlockedMessage(chatID, dbUser)
         return
     }
 
@@ -85,22 +150,81 @@ func (h *BotHandler) handleMessage(message *tgbotapi.Message) {
     }
 
     // Messaging message admin
+    if !h.settings().AutoForwardEnabled {
+        h.recordMessage(message, dbUser, false, nil)
+        h.sendConfirmationToUser(message.Chat.ID, dbUser, "message.received")
+        return
+    }
+
     h.forwardToAdminHTML(message, dbUser)
-    h.sendConfirmationToUser(message.Chat.ID)
+    h.sendConfirmationToUser(message.Chat.ID, dbUser, "message.sent")
 }
 
-func (h *BotHandler) sendConfirmationToUser(chatID int64) {
-    h.sendMessage(chatID, "✅ Your message has been sent to the administrator. Wait for a response.")
+// verifiedMessage is the HTML text for users who passed verification. The
+// template set by the admin is the same in every language
+func (h *BotHandler) verifiedMessage(user *database.User) string {
+    if text, ok := h.customMessage(templateVerified, h.templateData(user)); ok {
+        return text
+    }
+    return html.EscapeString(h.tr(h.lang(user), "captcha.verified"))
+}
+
+// sendConfirmationToUser acknowledges a message, key is the built-in text
+func (h *BotHandler) sendConfirmationToUser(chatID int64, user *database.User, key string) {
+    if text, ok := h.customMessage(templateConfirmation, h.templateData(user)); ok {
+        h.sendMessageHTML(chatID, text)
+        return
+    }
+    h.sendMessage(chatID, h.tr(h.lang(user), key))
+}
+
+// Permissions needed for the buttons of admin cards
+var callbackPermissions = map[string]string{
+    "accept_":  PermAccept,
+    "reject_":  PermAccept,
+    "block_":   PermBlock,
+    "history_": PermHistory,
 }
 
 func (h *BotHandler) handleCallback(callback *tgbotapi.CallbackQuery) {
-    data := callback.Data
     userID := callback.From.ID
+    lang := h.langOf(callback.From)
+
+    // Blacklisted users get no feedback at all
+    if h.isBlacklisted(callback.From) {
+        return
+    }
+
+    slog.Debug("Callback received", "user_id", userID, "data", callback.Data)
+
+    // All buttons are signed, forged and outdated data goes no further
+    data, err := verifyCallbackData(h.callbackKey, callback.Data, time.Now())
+    if err != nil {
+        slog.Warn("Rejected callback", "user_id", userID, "error", err)
+        if errors.Is(err, errCallbackExpired) {
+            h.answerCallback(callback.ID, h.tr(lang, "callback.expired"))
+        } else {
+            h.answerCallback(callback.ID, h.tr(lang, "error.data"))
+        }
+        return
+    }
+    // The handlers below only see the verified payload
+    callback.Data = data
 
-    log.Printf("Callback from user %d: %s", userID, data)
+    for prefix, permission := range callbackPermissions {
+        if strings.HasPrefix(data, prefix) && !h.can(userID, permission) {
+            slog.Warn("Rejected admin callback", "user_id", userID, "data", data)
+            h.answerCallback(callback.ID, h.tr(lang, "callback.unknown"))
+            return
+        }
+    }
 
     // Captcha callback handling
     if strings.HasPrefix(data, "captcha_") {
+        if callback.Message != nil && !callback.Message.Chat.IsPrivate() {
+            h.handleGroupCaptchaCallback(callback)
+            return
+        }
         h.handleCaptchaCallback(callback)
         return
     }
@@ -121,152 +245,164 @@ func (h *BotHandler) handleCallback(callback *tgbotapi.CallbackQuery) {
         return
     }
 
+    if strings.HasPrefix(data, "history_") {
+        h.handleHistoryCallback(callback)
+        return
+    }
+
     // Response to unknown callback
-    h.answerCallback(callback.ID, "Unknown command")
+    h.answerCallback(callback.ID, h.tr(lang, "callback.unknown"))
 }
 
 func (h *BotHandler) handleCaptchaCallback(callback *tgbotapi.CallbackQuery) {
-    parts := strings.Split(callback.Data, "_")
-    if len(parts) != 3 {
-        h.answerCallback(callback.ID, "Data error")
-        return
-    }
+    lang := h.langOf(callback.From)
 
-    telegramID, err := strconv.ParseInt(parts[1], 10, 64)
+    telegramID, nonce, optionIndex, err := parseCaptchaCallback(callback.Data)
     if err != nil {
-        h.answerCallback(callback.ID, "Error ID")
+        h.answerCallback(callback.ID, h.tr(lang, "error.data"))
         return
     }
 
-    optionIndex, err := strconv.Atoi(parts[2])
-    if err != nil {
-        h.answerCallback(callback.ID, "Index error")
+    // Only the user the captcha was given to can answer it
+    if callback.From.ID != telegramID {
+        h.answerCallback(callback.ID, h.tr(lang, "captcha.not_yours"))
         return
     }
 
     // Getting the user from the database
     user, err := h.db.GetUserByTelegramID(telegramID)
     if err != nil {
-        log.Printf("Error getting user: %v", err)
-        h.answerCallback(callback.ID, "Error receiving data")
+        slog.Error("Error getting user", "user_id", telegramID, "error", err)
+        h.answerCallback(callback.ID, h.tr(lang, "error.receiving"))
         return
     }
+    lang = h.lang(user)
 
-    if user.CaptchaData == nil {
-        h.answerCallback(callback.ID, "Captcha is outdated")
+    // Buttons of an older captcha, or of one that was already answered
+    if user.CaptchaData == nil || user.CaptchaData.Nonce != nonce {
+        h.answerCallback(callback.ID, h.tr(lang, "captcha.outdated"))
         return
     }
 
     // Checking the captcha expiration date
     if time.Now().After(user.CaptchaData.ExpiresAt) {
-        h.answerCallback(callback.ID, "Captcha time has expired")
+        h.answerCallback(callback.ID, h.tr(lang, "captcha.expired_short"))
 
         // Sending a new captcha
         h.sendNewCaptcha(callback.Message.Chat.ID, user)
         return
     }
 
-    // Checking the answer
-    if optionIndex < len(user.CaptchaData.Options) {
-        selectedAnswer := user.CaptchaData.Options[optionIndex]
+    if optionIndex < 0 || optionIndex >= len(user.CaptchaData.Options) {
+        h.answerCallback(callback.ID, h.tr(lang, "error.index"))
+        return
+    }
 
-        if selectedAnswer == user.CaptchaData.Answer {
-            // Successful check
-            err := h.db.UpdateUserVerification(user.TelegramID, true)
-            if err != nil {
-                log.Printf("Error updating verification: %v", err)
-                h.answerCallback(callback.ID, "Server error")
-                return
-            }
+    // Checking and consuming the answer in one step, so a double tap counts once
+    captcha := user.CaptchaData
+    correct := captcha.Options[optionIndex] == captcha.Answer
+    user, err = h.db.AnswerCaptcha(telegramID, nonce, correct)
+    if errors.Is(err, database.ErrNotFound) {
+        h.answerCallback(callback.ID, h.tr(lang, "captcha.outdated"))
+        return
+    }
+    if err != nil {
+        slog.Error("Error answering captcha", "user_id", telegramID, "captcha_type", captcha.Type, "error", err)
+        h.answerCallback(callback.ID, h.tr(lang, "error.server"))
+        return
+    }
+    h.recordCaptchaOutcome(captcha, captchaOutcome(correct))
 
-            // Editing a message with captcha
-            editMsg := tgbotapi.NewEditMessageText(
-                callback.Message.Chat.ID,
-                callback.Message.MessageID,
-                "✅ Verification passed!\n\nNow your messages will be forwarded to the administrator.",
-            )
-            editMsg.ParseMode = ""
-            _, err = h.bot.Send(editMsg)
-            if err != nil {
-                log.Printf("Error editing message: %v", err)
-            }
+    if correct {
+        // Editing a message with captcha
+        editMsg := tgbotapi.NewEditMessageText(
+            callback.Message.Chat.ID,
+            callback.Message.MessageID,
+            h.verifiedMessage(user),
+        )
+        editMsg.ParseMode = "HTML"
+        _, err = h.bot.Send(editMsg)
+        if err != nil {
+            slog.Error("Error editing message", "error", err)
+        }
 
-            // Removing buttons
-            h.removeButtons(callback.Message.Chat.ID, callback.Message.MessageID)
+        // Removing buttons
+        h.removeButtons(callback.Message.Chat.ID, callback.Message.MessageID)
 
-            // Send confirmation callback
-            h.answerCallback(callback.ID, "✅ Right! Verification passed.")
+        // Send confirmation callback
+        h.answerCallback(callback.ID, h.tr(lang, "captcha.right"))
 
-            // We notify the admin
-            h.notifyAdmin(user, true, "")
-        } else {
-            // Wrong answer
-            h.db.IncrementAttempts(user.TelegramID)
-
-            // Receiving updated user data
-            user, _ = h.db.GetUserByTelegramID(telegramID)
-
-            // Checking the number of attempts
-            if user.VerificationAttempts >= 3 {
-                // Blocking a user
-                h.blockUser(user.TelegramID)
-
-                editMsg := tgbotapi.NewEditMessageText(
-                    callback.Message.Chat.ID,
-                    callback.Message.MessageID,
-                    "❌ Access blocked\n\nYou have exceeded the maximum number of attempts.",
-                )
-                editMsg.ParseMode = ""
-                _, err = h.bot.Send(editMsg)
-                if err != nil {
-                    log.Printf("Error editing message: %v", err)
-                }
-
-                h.answerCallback(callback.ID, "❌ Number of attempts exceeded")
-
-                h.notifyAdmin(user, false, "Number of attempts exceeded")
-            } else {
-                h.answerCallback(callback.ID,
-                    fmt.Sprintf("❌ Wrong. Attempts left: %d/3", 3-user.VerificationAttempts))
-
-                // Sending a new captcha
-                time.Sleep(500 * time.Millisecond) 
-                h.sendNewCaptcha(callback.Message.Chat.ID, user)
-            }
+        // We notify the admin
+        h.notifyAdmin(user, true, "")
+        return
+    }
+
+    // Wrong answer
+    maxAttempts := h.settings().MaxAttempts
+
+    // Checking the number of attempts
+    if user.VerificationAttempts >= maxAttempts {
+        // Blocking a user
+        until, err := h.blockUser(user.TelegramID, h.settings().BlockDuration, h.staffText("reason.attempts_exceeded"))
+        if err != nil {
+            slog.Error("Error blocking user", "user_id", user.TelegramID, "error", err)
         }
+
+        editMsg := tgbotapi.NewEditMessageText(
+            callback.Message.Chat.ID,
+            callback.Message.MessageID,
+            h.tr(lang, "captcha.blocked")+h.blockedUntilText(lang, until),
+        )
+        editMsg.ParseMode = ""
+        _, err = h.bot.Send(editMsg)
+        if err != nil {
+            slog.Error("Error editing message", "error", err)
+        }
+
+        h.answerCallback(callback.ID, h.tr(lang, "captcha.attempts_exceeded"))
+
+        h.notifyAdmin(user, false, h.attemptsBlockedReason(until))
+    } else {
+        h.answerCallback(callback.ID,
+            h.tr(lang, "captcha.wrong_short", maxAttempts-user.VerificationAttempts, maxAttempts))
+
+        // Sending a new captcha
+        time.Sleep(500 * time.Millisecond) 
+        h.sendNewCaptcha(callback.Message.Chat.ID, user)
     }
 }
 
 func (h *BotHandler) handleAcceptUser(callback *tgbotapi.CallbackQuery) {
     parts := strings.Split(callback.Data, "_")
     if len(parts) != 2 {
-        h.answerCallback(callback.ID, "Data error")
+        h.answerCallback(callback.ID, h.staffText("error.data"))
         return
     }
 
     telegramID, err := strconv.ParseInt(parts[1], 10, 64)
     if err != nil {
-        h.answerCallback(callback.ID, "Error ID")
+        h.answerCallback(callback.ID, h.staffText("error.id"))
         return
     }
 
     // Update the user as verified
     err = h.db.UpdateUserVerification(telegramID, true)
     if err != nil {
-        log.Printf("Error accepting user: %v", err)
-        h.answerCallback(callback.ID, "Server error")
+        slog.Error("Error accepting user", "user_id", telegramID, "error", err)
+        h.answerCallback(callback.ID, h.staffText("error.server"))
         return
     }
 
     // Getting information about the user
     user, err := h.db.GetUserByTelegramID(telegramID)
     if err != nil {
-        log.Printf("Error getting user: %v", err)
+        slog.Error("Error getting user", "user_id", telegramID, "error", err)
     }
 
     // Editing the message text
+    status := h.staffText("card.accepted_by", staffName(callback.From))
     cleanText := h.removeMarkdown(callback.Message.Text)
-    newText := cleanText + "\n\n✅ Accepted by administrator"
+    newText := cleanText + "\n\n" + status
 
     editMsg := tgbotapi.NewEditMessageText(
         callback.Message.Chat.ID,
@@ -276,44 +412,46 @@ func (h *BotHandler) handleAcceptUser(callback *tgbotapi.CallbackQuery) {
     editMsg.ParseMode = ""
     _, err = h.bot.Send(editMsg)
     if err != nil {
-        log.Printf("Error editing message: %v", err)
+        slog.Error("Error editing message", "error", err)
     }
 
     // Removing buttons
     h.removeButtons(callback.Message.Chat.ID, callback.Message.MessageID)
 
-    h.answerCallback(callback.ID, "✅ User accepted")
+    h.answerCallback(callback.ID, h.staffText("card.user_accepted"))
+
+    // The same cards in other staff chats
+    h.syncAdminCards(telegramID, status, callback.Message)
 
     // We notify the user
     if user != nil {
-        h.sendMessage(user.TelegramID,
-            "✅ The administrator has accepted your request. You can now send messages.",
-        )
+        h.sendMessage(user.TelegramID, h.tr(h.lang(user), "user.accepted"))
     }
 }
 
 func (h *BotHandler) handleRejectUser(callback *tgbotapi.CallbackQuery) {
     parts := strings.Split(callback.Data, "_")
     if len(parts) != 2 {
-        h.answerCallback(callback.ID, "Data error")
+        h.answerCallback(callback.ID, h.staffText("error.data"))
         return
     }
 
     telegramID, err := strconv.ParseInt(parts[1], 10, 64)
     if err != nil {
-        h.answerCallback(callback.ID, "Error ID")
+        h.answerCallback(callback.ID, h.staffText("error.id"))
         return
     }
 
     // Getting information about the user
     user, err := h.db.GetUserByTelegramID(telegramID)
     if err != nil {
-        log.Printf("Error getting user: %v", err)
+        slog.Error("Error getting user", "user_id", telegramID, "error", err)
     }
 
     // Editing the text
+    status := h.staffText("card.rejected_by", staffName(callback.From))
     cleanText := h.removeMarkdown(callback.Message.Text)
-    newText := cleanText + "\n\n❌ Rejected by administrator"
+    newText := cleanText + "\n\n" + status
 
     editMsg := tgbotapi.NewEditMessageText(
         callback.Message.Chat.ID,
@@ -323,47 +461,58 @@ func (h *BotHandler) handleRejectUser(callback *tgbotapi.CallbackQuery) {
     editMsg.ParseMode = ""
     _, err = h.bot.Send(editMsg)
     if err != nil {
-        log.Printf("Error editing message: %v", err)
+        slog.Error("Error editing message", "error", err)
     }
 
     // Removing buttons
     h.removeButtons(callback.Message.Chat.ID, callback.Message.MessageID)
 
-    h.answerCallback(callback.ID, "❌ User rejected")
+    h.answerCallback(callback.ID, h.staffText("card.user_rejected"))
+
+    // The same cards in other staff chats
+    h.syncAdminCards(telegramID, status, callback.Message)
 
     // We notify the user
     if user != nil {
-        h.sendMessage(user.TelegramID,
-            "❌ The administrator has rejected your communication request.",
-        )
+        if text, ok := h.customMessage(templateRejected, h.templateData(user)); ok {
+            h.sendMessageHTML(user.TelegramID, text)
+        } else {
+            h.sendMessage(user.TelegramID, h.tr(h.lang(user), "user.rejected"))
+        }
     }
 }
 
 func (h *BotHandler) handleBlockUser(callback *tgbotapi.CallbackQuery) {
     parts := strings.Split(callback.Data, "_")
     if len(parts) != 2 {
-        h.answerCallback(callback.ID, "Data error")
+        h.answerCallback(callback.ID, h.staffText("error.data"))
         return
     }
 
     telegramID, err := strconv.ParseInt(parts[1], 10, 64)
     if err != nil {
-        h.answerCallback(callback.ID, "Error ID")
+        h.answerCallback(callback.ID, h.staffText("error.id"))
         return
     }
 
     // Blocking a user
-    h.blockUser(telegramID)
+    until, err := h.blockUser(telegramID, h.settings().BlockDuration, h.staffText("reason.blocked_by_admin"))
+    if err != nil {
+        slog.Error("Error blocking user", "user_id", telegramID, "error", err)
+        h.answerCallback(callback.ID, h.staffText("error.server"))
+        return
+    }
 
     // Getting information about the user
     user, err := h.db.GetUserByTelegramID(telegramID)
     if err != nil {
-        log.Printf("Error getting user: %v", err)
+        slog.Error("Error getting user", "user_id", telegramID, "error", err)
     }
 
     // Editing the text
+    status := h.staffText("card.blocked_by", staffName(callback.From), h.blockDescription(h.catalog.Fallback(), until))
     cleanText := h.removeMarkdown(callback.Message.Text)
-    newText := cleanText + "\n\n⛔ Blocked by administrator"
+    newText := cleanText + "\n\n" + status
 
     editMsg := tgbotapi.NewEditMessageText(
         callback.Message.Chat.ID,
@@ -373,19 +522,21 @@ func (h *BotHandler) handleBlockUser(callback *tgbotapi.CallbackQuery) {
     editMsg.ParseMode = ""
     _, err = h.bot.Send(editMsg)
     if err != nil {
-        log.Printf("Error editing message: %v", err)
+        slog.Error("Error editing message", "error", err)
     }
 
     // Removing buttons
     h.removeButtons(callback.Message.Chat.ID, callback.Message.MessageID)
 
-    h.answerCallback(callback.ID, "⛔ User is blocked")
+    h.answerCallback(callback.ID, h.staffText("card.user_blocked"))
+
+    // The same cards in other staff chats
+    h.syncAdminCards(telegramID, status, callback.Message)
 
     // Notify the user
     if user != nil {
-        h.sendMessage(user.TelegramID,
-            "⛔ The administrator has blocked your access.",
-        )
+        lang := h.lang(user)
+        h.sendMessage(user.TelegramID, h.tr(lang, "user.blocked_by_admin")+h.blockedUntilText(lang, until))
     }
 }
 
@@ -404,7 +555,7 @@ func (h *BotHandler) removeButtons(chatID int64, messageID int) {
     
     _, err := h.bot.Send(editMarkup)
     if err != nil {
-        log.Printf("Error removing buttons (method 1): %v", err)
+        slog.Error("Error removing buttons (method 1)", "error", err)
         
         // Method 2: Let's try it via NewInlineKeyboardMarkup()
         editMarkup2 := tgbotapi.NewEditMessageReplyMarkup(
@@ -415,13 +566,13 @@ func (h *BotHandler) removeButtons(chatID int64, messageID int) {
         
         _, err = h.bot.Send(editMarkup2)
         if err != nil {
-            log.Printf("Error removing buttons (method 2): %v", err)
+            slog.Error("Error removing buttons (method 2)", "error", err)
             
             // Method 3: Delete the entire message
             deleteMsg := tgbotapi.NewDeleteMessage(chatID, messageID)
             _, err = h.bot.Send(deleteMsg)
             if err != nil {
-                log.Printf("Error deleting message: %v", err)
+                slog.Error("Error deleting message", "error", err)
             }
         }
     }
@@ -462,42 +613,25 @@ func (h *BotHandler) answerCallback(callbackID string, text string) {
 
     _, err := h.bot.Request(callbackConfig)
     if err != nil {
-        log.Printf("Error answering callback: %v", err)
-    }
-}
-
-func (h *BotHandler) blockUser(telegramID int64) {
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
-
-    _, err := h.db.Users.UpdateOne(
-        ctx,
-        bson.M{"telegram_id": telegramID},
-        bson.M{
-            "$set": bson.M{
-                "is_blocked":  true,
-                "is_verified": false,
-                "updated_at":  time.Now(),
-            },
-        },
-    )
-
-    if err != nil {
-        log.Printf("Error blocking user: %v", err)
+        slog.Error("Error answering callback", "error", err)
     }
 }
 
 func (h *BotHandler) handleBotUser(chatID int64, user *database.User) {
-    h.sendMessage(chatID,
-        "❌ Bots cannot be verified.")
+    h.sendMessage(chatID, h.tr(h.lang(user), "user.bot"))
 
     // Notify the admin about the bot attempt
-    h.notifyAdmin(user, false, "Bot attempt")
+    h.notifyAdmin(user, false, h.staffText("reason.bot"))
 }
 
-func (h *BotHandler) sendBlockedMessage(chatID int64) {
-    h.sendMessage(chatID,
-        "⛔ Your access is blocked.")
+func (h *BotHandler) sendBlockedMessage(chatID int64, user *database.User) {
+    if text, ok := h.customMessage(templateBlocked, h.templateData(user)); ok {
+        h.sendMessageHTML(chatID, text)
+        return
+    }
+
+    lang := h.lang(user)
+    h.sendMessage(chatID, h.tr(lang, "user.blocked")+h.blockedUntilText(lang, user.BlockedUntil))
 }
 
 func (h *BotHandler) handleUnverifiedUser(chatID int64, messageText string, user *database.User) {
@@ -512,125 +646,151 @@ func (h *BotHandler) handleUnverifiedUser(chatID int64, messageText string, user
 }
 
 func (h *BotHandler) checkCaptchaAnswer(chatID int64, answer string, user *database.User) {
-    // Increase the attempt counter
-    h.db.IncrementAttempts(user.TelegramID)
+    // Checking and consuming the answer in one step, so that a repeated
+    // message cannot be counted twice
+    captcha := user.CaptchaData
+    lang := h.lang(user)
+    correct := answerMatches(answer, captcha)
+    user, err := h.db.AnswerCaptcha(user.TelegramID, captcha.Nonce, correct)
+    if errors.Is(err, database.ErrNotFound) {
+        h.sendMessage(chatID, h.tr(lang, "captcha.invalid"))
+        return
+    }
+    if err != nil {
+        slog.Error("Error answering captcha", "chat_id", chatID, "captcha_type", captcha.Type, "error", err)
+        h.sendMessage(chatID, h.tr(lang, "error.server_message"))
+        return
+    }
+    h.recordCaptchaOutcome(captcha, captchaOutcome(correct))
 
-    // Checking the answer
-    if strings.EqualFold(strings.TrimSpace(answer), user.CaptchaData.Answer) {
+    if correct {
         // Successful check
-        h.db.UpdateUserVerification(user.TelegramID, true)
-
-        h.sendMessage(chatID,
-            "✅ Verification passed!\n\nNow your messages will be forwarded to the administrator.")
+        h.sendMessageHTML(chatID, h.verifiedMessage(user))
 
         // Notice to admin
         h.notifyAdmin(user, true, "")
     } else {
         // Failed attempt
-        attempts := user.VerificationAttempts + 1
+        attempts := user.VerificationAttempts
+        maxAttempts := h.settings().MaxAttempts
 
-        if attempts >= 3 {
+        if attempts >= maxAttempts {
             // Blocking when attempts are exceeded
-            h.blockUser(user.TelegramID)
-            h.sendMessage(chatID,
-                "❌ Access blocked\n\nYou have exceeded the maximum number of attempts.")
-            h.notifyAdmin(user, false, "Number of attempts exceeded")
+            until, err := h.blockUser(user.TelegramID, h.settings().BlockDuration, h.staffText("reason.attempts_exceeded"))
+            if err != nil {
+                slog.Error("Error blocking user", "user_id", user.TelegramID, "error", err)
+            }
+            h.sendMessage(chatID, h.tr(lang, "captcha.blocked")+h.blockedUntilText(lang, until))
+            h.notifyAdmin(user, false, h.attemptsBlockedReason(until))
         } else {
-            h.sendMessage(chatID,
-                fmt.Sprintf("❌ Wrong answer. Attempts left: %d/3", 3-attempts))
+            h.sendMessage(chatID, h.tr(lang, "captcha.wrong", maxAttempts-attempts, maxAttempts))
             h.sendNewCaptcha(chatID, user)
         }
     }
 }
 
+// forwardToAdminHTML sends the message and a card about the sender to every
+// subscribed staff member
 func (h *BotHandler) forwardToAdminHTML(message *tgbotapi.Message, user *database.User) {
+    recipients := h.staffRecipients()
+
     // We send the original
-    forwardMsg := tgbotapi.NewForward(h.adminID, message.Chat.ID, message.MessageID)
-    _, err := h.bot.Send(forwardMsg)
-    if err != nil {
-        log.Printf("Error forwarding message: %v", err)
+    var forwardedTo []int64
+    for _, chatID := range recipients {
+        forwardMsg := tgbotapi.NewForward(chatID, message.Chat.ID, message.MessageID)
+        forwarded, err := h.bot.Send(forwardMsg)
+        if err != nil {
+            slog.Error("Error forwarding message", "chat_id", chatID, "user_id", user.TelegramID, "error", err)
+            continue
+        }
+        h.saveRelayLink(forwarded, user.TelegramID)
+        forwardedTo = append(forwardedTo, chatID)
     }
 
+    // Keeping the message for /history
+    h.recordMessage(message, user, false, forwardedTo)
+
     // Escaping HTML
     safeFirstName := html.EscapeString(user.FirstName)
     safeLastName := html.EscapeString(user.LastName)
     safeText := html.EscapeString(message.Text)
 
-    username := "not indicated"
-    if user.Username != "" {
-        username = "@" + user.Username
-    }
-
-    text := fmt.Sprintf(
-        "<b>📨 Sender information</b>\n\n"+
-            "👤 From: %s %s\n"+
-            "🆔 ID: <code>%d</code>\n"+
-            "📝 Username: %s\n"+
-            "⏰ Time: %s\n\n"+
-            "💬 <b>Message:</b>\n%s",
+    text := h.staffText("card.sender",
         safeFirstName,
         safeLastName,
         user.TelegramID,
-        username,
+        h.usernameText(h.catalog.Fallback(), user),
         time.Now().Format("15:04:05"),
         safeText,
     )
 
-    infoMsg := tgbotapi.NewMessage(h.adminID, text)
-    infoMsg.ParseMode = "HTML"
-
-    replyMarkup := tgbotapi.NewInlineKeyboardMarkup(
-        tgbotapi.NewInlineKeyboardRow(
-            tgbotapi.NewInlineKeyboardButtonData("✅ Accept", fmt.Sprintf("accept_%d", user.TelegramID)),
-            tgbotapi.NewInlineKeyboardButtonData("❌ Reject", fmt.Sprintf("reject_%d", user.TelegramID)),
-            tgbotapi.NewInlineKeyboardButtonData("⛔ Block", fmt.Sprintf("block_%d", user.TelegramID)),
-        ),
-    )
-    infoMsg.ReplyMarkup = replyMarkup
+    for _, chatID := range recipients {
+        infoMsg := tgbotapi.NewMessage(chatID, text)
+        infoMsg.ParseMode = "HTML"
+
+        // Signed per card, the buttons are the same for everyone
+        infoMsg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+            tgbotapi.NewInlineKeyboardRow(
+                h.adminButton(h.staffText("card.accept"), fmt.Sprintf("accept_%d", user.TelegramID)),
+                h.adminButton(h.staffText("card.reject"), fmt.Sprintf("reject_%d", user.TelegramID)),
+                h.adminButton(h.staffText("card.block"), fmt.Sprintf("block_%d", user.TelegramID)),
+            ),
+        )
 
-    _, err = h.bot.Send(infoMsg)
-    if err != nil {
-        log.Printf("Error sending HTML message to admin: %v", err)
+        info, err := h.bot.Send(infoMsg)
+        if err != nil {
+            slog.Error("Error sending sender card", "chat_id", chatID, "user_id", user.TelegramID, "error", err)
+            continue
+        }
+        h.saveRelayLink(info, user.TelegramID)
+
+        // Remembered so that the card can be updated when another staff member acts
+        err = h.db.SaveAdminCard(&database.AdminCard{
+            UserID:    user.TelegramID,
+            ChatID:    chatID,
+            MessageID: info.MessageID,
+            Text:      info.Text,
+        })
+        if err != nil {
+            slog.Error("Error saving admin card", "error", err)
+        }
     }
 }
 
+// notifyAdmin tells every subscribed staff member about a verification result
 func (h *BotHandler) notifyAdmin(user *database.User, success bool, reason string) {
-    status := "✅ passed the test"
+    status := h.staffText("notify.passed")
     if !success {
-        status = "❌ failed verification"
+        status = h.staffText("notify.failed")
     }
 
-    username := "not indicated"
-    if user.Username != "" {
-        username = "@" + user.Username
-    }
-
-    text := fmt.Sprintf(
-        "<b>👤 User %s</b>\n"+
-            "🆔 ID: <code>%d</code>\n"+
-            "📝 Username: %s\n"+
-            "📊 Status: %s\n"+
-            "⏰ Time: %s",
+    text := h.staffText("notify.user",
         html.EscapeString(user.FirstName),
         user.TelegramID,
-        username,
+        h.usernameText(h.catalog.Fallback(), user),
         status,
         time.Now().Format("15:04:05"),
     )
 
     if reason != "" {
-        text += fmt.Sprintf("\n📋 Reason: %s", html.EscapeString(reason))
+        text += h.staffText("notify.reason", html.EscapeString(reason))
     }
 
-    msg := tgbotapi.NewMessage(h.adminID, text)
-    msg.ParseMode = "HTML"
-    _, err := h.bot.Send(msg)
-    if err != nil {
-        log.Printf("Error notifying admin: %v", err)
+    for _, chatID := range h.staffRecipients() {
+        msg := tgbotapi.NewMessage(chatID, text)
+        msg.ParseMode = "HTML"
+        if _, err := h.bot.Send(msg); err != nil {
+            slog.Error("Error notifying staff member", "chat_id", chatID, "user_id", user.TelegramID, "error", err)
+        }
     }
 }
 
 func (h *BotHandler) handleCommand(message *tgbotapi.Message, user *database.User) {
+    // Admin commands are available only to the admin
+    if h.handleAdminCommand(message) {
+        return
+    }
+
     switch message.Command() {
     case "start":
         h.handleStartCommand(message, user)
@@ -638,24 +798,21 @@ func (h *BotHandler) handleCommand(message *tgbotapi.Message, user *database.Use
         h.handleVerifyCommand(message, user)
     case "status":
         h.handleStatusCommand(message, user)
+    case "language":
+        h.handleLanguageCommand(message, user)
     case "help":
-        h.handleHelpCommand(message)
+        h.handleHelpCommand(message, user)
     default:
-        h.handleUnknownCommand(message)
+        h.handleUnknownCommand(message, user)
     }
 }
 
 func (h *BotHandler) handleStartCommand(message *tgbotapi.Message, user *database.User) {
     chatID := message.Chat.ID
+    lang := h.lang(user)
 
     if user.IsVerified {
-        msgText := fmt.Sprintf(
-            "✅ <b>Hi, %s!</b>\n\n"+
-                "You have already been verified.\n"+
-                "You can send messages, they will be forwarded to the administrator.\n\n"+
-                "🆔 Your ID: <code>%d</code>\n"+
-                "📊 Status: ✅ Checked\n"+
-                "📅 Registration: %s",
+        msgText := h.tr(lang, "start.verified",
             html.EscapeString(user.FirstName),
             user.TelegramID,
             user.CreatedAt.Format("02.01.2006"),
@@ -665,25 +822,24 @@ func (h *BotHandler) handleStartCommand(message *tgbotapi.Message, user *databas
         return
     }
 
-    // Sending a greeting
-    welcomeMsg := fmt.Sprintf(
-        "👋 <b>Hi, %s!</b>\n\n"+
-            "I'm a helper bot. To contact the administrator, you need to pass a simple verification.\n\n"+
-            "Use the /verify command to start checking.",
-        html.EscapeString(user.FirstName),
-    )
+    // The greeting set by the admin takes precedence
+    if welcome, ok := h.customMessage(templateWelcome, h.templateData(user)); ok {
+        h.sendMessageHTML(chatID, welcome)
+        return
+    }
 
-    h.sendMessageHTML(chatID, welcomeMsg)
+    // Sending a greeting
+    h.sendMessageHTML(chatID, h.tr(lang, "start.welcome", html.EscapeString(user.FirstName)))
 }
 
 func (h *BotHandler) handleVerifyCommand(message *tgbotapi.Message, user *database.User) {
     if user.IsVerified {
-        h.sendMessage(message.Chat.ID, "✅ You have already been verified.")
+        h.sendMessage(message.Chat.ID, h.tr(h.lang(user), "verify.already"))
         return
     }
 
     if user.IsBlocked {
-        h.sendMessage(message.Chat.ID, "⛔ Your access is blocked.")
+        h.sendBlockedMessage(message.Chat.ID, user)
         return
     }
 
@@ -693,64 +849,44 @@ func (h *BotHandler) handleVerifyCommand(message *tgbotapi.Message, user *databa
 
 func (h *BotHandler) handleStatusCommand(message *tgbotapi.Message, user *database.User) {
     chatID := message.Chat.ID
+    lang := h.lang(user)
     var status string
 
     if user.IsBlocked {
-        status = "⛔ Blocked"
+        status = h.tr(lang, "status.blocked", h.blockDescription(lang, user.BlockedUntil))
     } else if user.IsVerified {
-        status = "✅ Checked"
+        status = h.tr(lang, "status.verified")
     } else {
-        status = "⏳ Awaiting review"
+        status = h.tr(lang, "status.pending")
     }
 
-    username := "not indicated"
-    if user.Username != "" {
-        username = "@" + user.Username
-    }
-
-    msgText := fmt.Sprintf(
-        "📊 <b>Your status</b>\n\n"+
-            "👤 Name: %s\n"+
-            "🆔 ID: <code>%d</code>\n"+
-            "📝 Username: %s\n"+
-            "📊 Status: %s\n"+
-            "🔄 Attempts: %d/3\n"+
-            "📅 Registration: %s",
+    msgText := h.tr(lang, "status.card",
         html.EscapeString(user.FirstName),
         user.TelegramID,
-        username,
+        h.usernameText(lang, user),
         status,
         user.VerificationAttempts,
+        h.settings().MaxAttempts,
         user.CreatedAt.Format("02.01.2006"),
     )
 
     h.sendMessageHTML(chatID, msgText)
 }
 
-func (h *BotHandler) handleHelpCommand(message *tgbotapi.Message) {
-    helpText := `🆘 <b>Available commands</b>
-
-/start - Start working with the bot
-/verify - Pass verification
-/status - Find out your status
-/help - Show this message
-
-<b>How does it work?</b>
-1. You send a message to a bot
-2. Pass a simple verification (captcha)
-3. After successful verification, your messages are forwarded to the administrator
-4. The administrator can answer you
-
-<b>Rules:</b>
-- You have 3 attempts to pass the test
-- It is prohibited to use bots to bypass verification
-- Messages with insults will not be forwarded`
+func (h *BotHandler) handleHelpCommand(message *tgbotapi.Message, user *database.User) {
+    h.sendMessageHTML(message.Chat.ID, h.tr(h.lang(user), "help", h.settings().MaxAttempts))
+}
 
-    h.sendMessageHTML(message.Chat.ID, helpText)
+func (h *BotHandler) handleUnknownCommand(message *tgbotapi.Message, user *database.User) {
+    h.sendMessage(message.Chat.ID, h.tr(h.lang(user), "command.unknown"))
 }
 
-func (h *BotHandler) handleUnknownCommand(message *tgbotapi.Message) {
-    h.sendMessage(message.Chat.ID, "❌ Unknown team. Use /help for a list of commands.")
+// usernameText is the @username of the user for cards and statuses
+func (h *BotHandler) usernameText(lang string, user *database.User) string {
+    if user.Username == "" {
+        return h.tr(lang, "username.none")
+    }
+    return "@" + user.Username
 }
 
 func (h *BotHandler) sendMessage(chatID int64, text string) {
@@ -759,9 +895,9 @@ func (h *BotHandler) sendMessage(chatID int64, text string) {
 
     _, err := h.bot.Send(msg)
     if err != nil {
-        log.Printf("Error sending message to %d: %v", chatID, err)
+        slog.Error("Error sending message", "chat_id", chatID, "error", err)
         if strings.Contains(err.Error(), "Forbidden") {
-            log.Printf("Bot was blocked by user %d", chatID)
+            slog.Info("Bot was blocked by user", "user_id", chatID)
         }
     }
 }
@@ -772,7 +908,7 @@ func (h *BotHandler) sendMessageHTML(chatID int64, text string) {
 
     _, err := h.bot.Send(msg)
     if err != nil {
-        log.Printf("Error sending HTML message to %d: %v", chatID, err)
+        slog.Error("Error sending message", "chat_id", chatID, "error", err)
         h.sendMessage(chatID, text)
     }
 }
\ No newline at end of file
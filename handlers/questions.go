@@ -0,0 +1,767 @@
+package handlers
+
+import (
+    "bytes"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "html"
+    "io"
+    "log/slog"
+    "math/rand"
+    "net/http"
+    "net/url"
+    "path"
+    "slices"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "unicode"
+
+    "telegram-gatekeeper/database"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How long the loaded question bank is used before it is read from the database again
+const questionsCacheTTL = 30 * time.Second
+
+// Limits of an imported document
+const (
+    maxImportSize      = 1 << 20
+    maxImportQuestions = 1000
+    maxImportProblems  = 10 // Reported to the admin, the rest are counted
+)
+
+// Most typos a question can forgive
+const maxTolerance = 3
+
+var questionDifficulties = []string{database.DifficultyEasy, database.DifficultyMedium, database.DifficultyHard}
+
+// Documents sent to the bot are downloaded with it
+var fileClient = &http.Client{Timeout: 30 * time.Second}
+
+// questionCache keeps the active questions in memory, a captcha is drawn
+// from them for every text captcha
+type questionCache struct {
+    mu       sync.Mutex
+    active   []database.Question
+    loaded   bool
+    loadedAt time.Time
+}
+
+func (h *BotHandler) activeQuestions() []database.Question {
+    c := &h.questions
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if !c.loaded || time.Since(c.loadedAt) >= questionsCacheTTL {
+        h.reloadQuestionsLocked()
+    }
+
+    return c.active
+}
+
+func (h *BotHandler) reloadQuestionsLocked() {
+    c := &h.questions
+
+    questions, err := h.db.GetQuestions()
+    if err != nil {
+        slog.Error("Error loading questions", "error", err)
+        if c.loaded {
+            return
+        }
+    }
+
+    c.active = slices.DeleteFunc(questions, func(q database.Question) bool { return !q.Active })
+    c.loaded = true
+    c.loadedAt = time.Now()
+}
+
+func (h *BotHandler) invalidateQuestions() {
+    h.questions.mu.Lock()
+    h.questions.loaded = false
+    h.questions.mu.Unlock()
+}
+
+// pickQuestion draws an active question in the language of the user, or in
+// the default one. Questions of the difficulty chosen with /set are preferred
+func (h *BotHandler) pickQuestion(lang string) (database.Question, bool) {
+    questions := h.activeQuestions()
+    difficulty := h.settings().QuestionDifficulty
+
+    for _, language := range []string{lang, h.catalog.Fallback()} {
+        var inLanguage, matching []database.Question
+        for _, q := range questions {
+            if q.Language != language {
+                continue
+            }
+            inLanguage = append(inLanguage, q)
+            if difficulty == "" || q.Difficulty == difficulty {
+                matching = append(matching, q)
+            }
+        }
+
+        if len(matching) > 0 {
+            return matching[rand.Intn(len(matching))], true
+        }
+        if len(inLanguage) > 0 {
+            return inLanguage[rand.Intn(len(inLanguage))], true
+        }
+    }
+
+    return database.Question{}, false
+}
+
+// SeedQuestions imports the questions of the config when the question bank is
+// empty, so that a new database starts with them
+func (h *BotHandler) SeedQuestions() error {
+    if len(h.config.Captcha.TextQuestions) == 0 {
+        return nil
+    }
+
+    existing, err := h.db.GetQuestions()
+    if err != nil {
+        return err
+    }
+    if len(existing) > 0 {
+        return nil
+    }
+
+    questions := make([]database.Question, 0, len(h.config.Captcha.TextQuestions))
+    for _, q := range h.config.Captcha.TextQuestions {
+        question := database.Question{
+            Question:     q.Question,
+            Answer:       q.Answer,
+            Alternatives: q.Alternatives,
+            Check:        newAnswerCheck(q.Normalize, q.Tolerance),
+            Language:     q.Language,
+            Difficulty:   q.Difficulty,
+            Active:       true,
+        }
+        if err := h.checkQuestion(&question); err != nil {
+            return fmt.Errorf("question %q: %w", q.Question, err)
+        }
+        questions = append(questions, question)
+    }
+
+    if err := h.db.AddQuestions(questions); err != nil {
+        return err
+    }
+    h.invalidateQuestions()
+
+    slog.Info("Question bank seeded from the config", "questions", len(questions))
+    return nil
+}
+
+// checkQuestion fills in the defaults and reports what is wrong with the question
+func (h *BotHandler) checkQuestion(q *database.Question) error {
+    q.Question = strings.TrimSpace(q.Question)
+    q.Answer = strings.TrimSpace(q.Answer)
+    q.Language = strings.ToLower(strings.TrimSpace(q.Language))
+    q.Difficulty = strings.ToLower(strings.TrimSpace(q.Difficulty))
+
+    // Alternatives repeating an accepted answer are dropped
+    var alternatives []string
+    for _, alternative := range q.Alternatives {
+        alternative = strings.TrimSpace(alternative)
+        if alternative != "" && !strings.EqualFold(alternative, q.Answer) && !slices.Contains(alternatives, alternative) {
+            alternatives = append(alternatives, alternative)
+        }
+    }
+    q.Alternatives = alternatives
+
+    if q.Language == "" {
+        q.Language = h.catalog.Fallback()
+    }
+    if q.Difficulty == "" {
+        q.Difficulty = database.DifficultyMedium
+    }
+
+    switch {
+    case q.Question == "":
+        return errors.New("the question is empty")
+    case q.Answer == "":
+        return errors.New("the answer is empty")
+    case len(q.Question) > 512:
+        return errors.New("the question is longer than 512 characters")
+    case !h.catalog.Has(q.Language):
+        return fmt.Errorf("unknown language %q, expected one of %s", q.Language, strings.Join(h.catalog.Locales(), ", "))
+    case !slices.Contains(questionDifficulties, q.Difficulty):
+        return fmt.Errorf("unknown difficulty %q, expected one of %s", q.Difficulty, strings.Join(questionDifficulties, ", "))
+    }
+
+    // The question is sent the way a text captcha asks it
+    prompt := h.captchaPrompt(q.Language, &database.Captcha{Type: "text", Question: q.Question})
+    if err := checkTelegramHTML(prompt); err != nil {
+        return fmt.Errorf("the question cannot be sent: %v", err)
+    }
+
+    return checkAnswerCheck(q.Check)
+}
+
+// newAnswerCheck builds the answer check of a question, nil when it keeps the
+// default one. Without steps the default steps are used, "none" turns them off
+func newAnswerCheck(steps []string, tolerance int) *database.AnswerCheck {
+    if steps == nil && tolerance == 0 {
+        return nil
+    }
+
+    check := &database.AnswerCheck{Steps: answerSteps, Tolerance: tolerance}
+    if steps != nil {
+        check.Steps = []string{}
+        for _, step := range steps {
+            step = strings.ToLower(strings.TrimSpace(step))
+            if step != "" && step != "none" && !slices.Contains(check.Steps, step) {
+                check.Steps = append(check.Steps, step)
+            }
+        }
+    }
+
+    return check
+}
+
+func checkAnswerCheck(check *database.AnswerCheck) error {
+    if check == nil {
+        return nil
+    }
+
+    for _, step := range check.Steps {
+        if !slices.Contains(answerSteps, step) {
+            return fmt.Errorf("unknown normalization step %q, expected %s or none", step, strings.Join(answerSteps, ", "))
+        }
+    }
+    if check.Tolerance < 0 || check.Tolerance > maxTolerance {
+        return fmt.Errorf("the tolerance must be 0-%d typos", maxTolerance)
+    }
+
+    return nil
+}
+
+// describeAnswerCheck is how the check of a question is shown to staff
+func (h *BotHandler) describeAnswerCheck(check *database.AnswerCheck) string {
+    if check == nil {
+        return h.staffText("staff.questions.check_default")
+    }
+
+    steps := h.staffText("staff.questions.check_exact")
+    if len(check.Steps) > 0 {
+        steps = strings.Join(check.Steps, ", ")
+    }
+    if check.Tolerance > 0 {
+        steps += ", " + h.staffText("staff.questions.check_typos", check.Tolerance)
+    }
+    return steps
+}
+
+// splitList splits "a, b|c d" into its items
+func splitList(s string) []string {
+    return strings.FieldsFunc(s, func(r rune) bool {
+        return r == ',' || r == '|' || unicode.IsSpace(r)
+    })
+}
+
+func (h *BotHandler) handleQuestionsCommand(message *tgbotapi.Message) {
+    action, rest := cutWord(message.CommandArguments())
+
+    switch strings.ToLower(action) {
+    case "", "list":
+        h.listQuestions(message.Chat.ID)
+    case "add":
+        h.addQuestion(message, rest)
+    case "enable":
+        h.setQuestionActive(message.Chat.ID, rest, true)
+    case "disable":
+        h.setQuestionActive(message.Chat.ID, rest, false)
+    case "delete":
+        h.deleteQuestion(message.Chat.ID, rest)
+    case "check":
+        h.setQuestionCheck(message.Chat.ID, rest)
+    case "import":
+        if reply := message.ReplyToMessage; reply != nil && reply.Document != nil {
+            h.importQuestions(message.Chat.ID, message.From.ID, reply.Document)
+            return
+        }
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.questions.import_help"))
+    default:
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.questions.usage"))
+    }
+}
+
+func (h *BotHandler) listQuestions(chatID int64) {
+    questions, err := h.db.GetQuestions()
+    if err != nil {
+        slog.Error("Error loading questions", "error", err)
+        h.sendMessage(chatID, h.staffText("error.server_message"))
+        return
+    }
+
+    if len(questions) == 0 {
+        h.sendMessageHTML(chatID, h.staffText("staff.questions.empty")+"\n\n"+h.staffText("staff.questions.usage"))
+        return
+    }
+
+    active := 0
+    for _, q := range questions {
+        if q.Active {
+            active++
+        }
+    }
+
+    var sb strings.Builder
+    sb.WriteString(h.staffText("staff.questions.title", len(questions), active))
+
+    for i, q := range questions {
+        state := "✅"
+        if !q.Active {
+            state = "⏸"
+        }
+        answers := strings.Join(append([]string{q.Answer}, q.Alternatives...), " / ")
+        fmt.Fprintf(&sb, "\n%s <code>%s</code> %s · %s · %s\n%s → <i>%s</i>\n",
+            state, q.ID.Hex(), q.Language, q.Difficulty, h.describeAnswerCheck(q.Check),
+            html.EscapeString(q.Question), html.EscapeString(answers))
+
+        // Telegram does not accept messages longer than 4096 characters
+        if sb.Len() > 3800 && i < len(questions)-1 {
+            sb.WriteString("\n" + h.staffText("staff.and_more", len(questions)-i-1))
+            break
+        }
+    }
+
+    h.sendMessageHTML(chatID, sb.String())
+}
+
+func (h *BotHandler) addQuestion(message *tgbotapi.Message, args string) {
+    language, rest := cutWord(args)
+    difficulty, rest := cutWord(rest)
+    parts := strings.Split(rest, "|")
+    if len(parts) < 2 {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.questions.usage"))
+        return
+    }
+
+    question := database.Question{
+        Question:     parts[0],
+        Answer:       parts[1],
+        Alternatives: parts[2:],
+        Language:     language,
+        Difficulty:   difficulty,
+        Active:       true,
+        AddedBy:      message.From.ID,
+    }
+    if err := h.checkQuestion(&question); err != nil {
+        h.sendMessageHTML(message.Chat.ID, "❌ "+html.EscapeString(err.Error()))
+        return
+    }
+
+    if err := h.db.AddQuestions([]database.Question{question}); err != nil {
+        slog.Error("Error adding question", "error", err)
+        h.sendMessage(message.Chat.ID, h.staffText("staff.questions.add_failed"))
+        return
+    }
+    h.invalidateQuestions()
+
+    h.sendMessageHTML(message.Chat.ID, h.staffText("staff.questions.added"))
+}
+
+func (h *BotHandler) setQuestionActive(chatID int64, arg string, active bool) {
+    id, err := primitive.ObjectIDFromHex(strings.TrimSpace(arg))
+    if err != nil {
+        h.sendMessageHTML(chatID, h.staffText("staff.questions.expected_id"))
+        return
+    }
+
+    found, err := h.db.SetQuestionActive(id, active)
+    if err != nil {
+        slog.Error("Error updating question", "question_id", id.Hex(), "error", err)
+        h.sendMessage(chatID, h.staffText("error.server_message"))
+        return
+    }
+    if !found {
+        h.sendMessageHTML(chatID, h.staffText("staff.questions.not_found", id.Hex()))
+        return
+    }
+    h.invalidateQuestions()
+
+    if active {
+        h.sendMessageHTML(chatID, h.staffText("staff.questions.enabled", id.Hex()))
+        return
+    }
+    h.sendMessageHTML(chatID, h.staffText("staff.questions.disabled", id.Hex()))
+}
+
+// setQuestionCheck changes how answers to a question are compared:
+// "default", "none" or a list of steps, optionally followed by the typos forgiven
+func (h *BotHandler) setQuestionCheck(chatID int64, args string) {
+    idArg, rest := cutWord(args)
+    id, err := primitive.ObjectIDFromHex(idArg)
+    if err != nil {
+        h.sendMessageHTML(chatID, h.staffText("staff.questions.expected_id"))
+        return
+    }
+
+    fields := strings.Fields(rest)
+    tolerance := 0
+    if len(fields) > 1 {
+        if tolerance, err = strconv.Atoi(fields[len(fields)-1]); err != nil {
+            h.sendMessageHTML(chatID, h.staffText("staff.questions.usage"))
+            return
+        }
+        fields = fields[:len(fields)-1]
+    }
+    if len(fields) == 0 {
+        h.sendMessageHTML(chatID, h.staffText("staff.questions.usage"))
+        return
+    }
+
+    var steps []string
+    if spec := strings.ToLower(strings.Join(fields, ",")); spec != "default" {
+        steps = splitList(spec)
+    }
+    check := newAnswerCheck(steps, tolerance)
+    if err := checkAnswerCheck(check); err != nil {
+        h.sendMessageHTML(chatID, "❌ "+html.EscapeString(err.Error()))
+        return
+    }
+
+    found, err := h.db.SetQuestionCheck(id, check)
+    if err != nil {
+        slog.Error("Error updating question", "question_id", id.Hex(), "error", err)
+        h.sendMessage(chatID, h.staffText("error.server_message"))
+        return
+    }
+    if !found {
+        h.sendMessageHTML(chatID, h.staffText("staff.questions.not_found", id.Hex()))
+        return
+    }
+    h.invalidateQuestions()
+
+    h.sendMessageHTML(chatID, h.staffText("staff.questions.check_set", id.Hex(), h.describeAnswerCheck(check)))
+}
+
+func (h *BotHandler) deleteQuestion(chatID int64, arg string) {
+    id, err := primitive.ObjectIDFromHex(strings.TrimSpace(arg))
+    if err != nil {
+        h.sendMessageHTML(chatID, h.staffText("staff.questions.expected_id"))
+        return
+    }
+
+    deleted, err := h.db.DeleteQuestion(id)
+    if err != nil {
+        slog.Error("Error deleting question", "question_id", id.Hex(), "error", err)
+        h.sendMessage(chatID, h.staffText("error.server_message"))
+        return
+    }
+    if !deleted {
+        h.sendMessageHTML(chatID, h.staffText("staff.questions.not_found", id.Hex()))
+        return
+    }
+    h.invalidateQuestions()
+
+    h.sendMessageHTML(chatID, h.staffText("staff.questions.deleted", id.Hex()))
+}
+
+// isQuestionImport reports whether the caption of a document asks to import it
+func isQuestionImport(caption string) bool {
+    fields := strings.Fields(caption)
+    if len(fields) < 2 {
+        return false
+    }
+
+    command, _, _ := strings.Cut(fields[0], "@")
+    return command == "/questions" && strings.EqualFold(fields[1], "import")
+}
+
+// importQuestions adds the questions of the document to the bank, all of them or none
+func (h *BotHandler) importQuestions(chatID, fromID int64, document *tgbotapi.Document) {
+    if document.FileSize > maxImportSize {
+        h.sendMessage(chatID, h.staffText("staff.questions.too_big", maxImportSize>>10))
+        return
+    }
+
+    data, err := h.downloadFile(document.FileID, maxImportSize)
+    if err != nil {
+        slog.Error("Error downloading document", "file_name", document.FileName, "error", err)
+        h.sendMessage(chatID, h.staffText("staff.questions.download_failed", err))
+        return
+    }
+
+    rows, err := parseQuestionFile(document.FileName, data)
+    if err != nil {
+        h.sendMessageHTML(chatID, "❌ "+html.EscapeString(err.Error())+"\n\n"+h.staffText("staff.questions.import_help"))
+        return
+    }
+    if len(rows) > maxImportQuestions {
+        h.sendMessage(chatID, h.staffText("staff.questions.too_many", len(rows), maxImportQuestions))
+        return
+    }
+
+    existing, err := h.db.GetQuestions()
+    if err != nil {
+        slog.Error("Error loading questions", "error", err)
+        h.sendMessage(chatID, h.staffText("staff.questions.import_failed"))
+        return
+    }
+
+    seen := make(map[string]bool, len(existing))
+    for _, q := range existing {
+        seen[questionKey(q)] = true
+    }
+
+    var questions []database.Question
+    var problems []string
+    skipped := 0
+    for _, row := range rows {
+        q := row.question
+        q.AddedBy = fromID
+        if err := h.checkQuestion(&q); err != nil {
+            problems = append(problems, fmt.Sprintf("%s: %v", row.where, err))
+            continue
+        }
+
+        if seen[questionKey(q)] {
+            skipped++
+            continue
+        }
+        seen[questionKey(q)] = true
+        questions = append(questions, q)
+    }
+
+    if len(problems) > 0 {
+        h.sendMessageHTML(chatID, h.importProblemsText(problems))
+        return
+    }
+
+    if err := h.db.AddQuestions(questions); err != nil {
+        slog.Error("Error importing questions", "error", err)
+        h.sendMessage(chatID, h.staffText("staff.questions.import_failed"))
+        return
+    }
+    h.invalidateQuestions()
+
+    text := h.staffText("staff.questions.imported", len(questions))
+    if skipped > 0 {
+        text += h.staffText("staff.questions.skipped", skipped)
+    }
+    h.sendMessage(chatID, text)
+}
+
+// questionKey identifies a question for finding duplicates
+func questionKey(q database.Question) string {
+    return q.Language + "\x00" + strings.ToLower(q.Question)
+}
+
+func (h *BotHandler) importProblemsText(problems []string) string {
+    var sb strings.Builder
+    sb.WriteString(h.staffText("staff.questions.invalid", len(problems)))
+
+    for _, problem := range problems[:min(len(problems), maxImportProblems)] {
+        fmt.Fprintf(&sb, "\n• %s", html.EscapeString(problem))
+    }
+    if len(problems) > maxImportProblems {
+        sb.WriteString("\n" + h.staffText("staff.and_more", len(problems)-maxImportProblems))
+    }
+
+    return sb.String()
+}
+
+// importRow is a question of an imported document and where it was found
+type importRow struct {
+    where    string // "line 3" or "item 3"
+    question database.Question
+}
+
+// parseQuestionFile reads a CSV or a JSON document. The format is told by the
+// extension, or by the content when the name does not say
+func parseQuestionFile(name string, data []byte) ([]importRow, error) {
+    data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // Byte order mark of Excel
+
+    switch strings.ToLower(path.Ext(name)) {
+    case ".json":
+        return parseQuestionJSON(data)
+    case ".csv", ".txt":
+        return parseQuestionCSV(data)
+    }
+
+    if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+        return parseQuestionJSON(data)
+    }
+    return parseQuestionCSV(data)
+}
+
+func parseQuestionJSON(data []byte) ([]importRow, error) {
+    var items []struct {
+        Question     string   `json:"question"`
+        Answer       string   `json:"answer"`
+        Alternatives []string `json:"alternatives"`
+        Language     string   `json:"language"`
+        Difficulty   string   `json:"difficulty"`
+        Normalize    []string `json:"normalize"` // Missing - the default steps, [] - none
+        Tolerance    int      `json:"tolerance"`
+        Active       *bool    `json:"active"`
+    }
+
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.DisallowUnknownFields()
+    if err := decoder.Decode(&items); err != nil {
+        return nil, fmt.Errorf("invalid JSON: %v", err)
+    }
+    if len(items) == 0 {
+        return nil, errors.New("the document has no questions")
+    }
+
+    rows := make([]importRow, len(items))
+    for i, item := range items {
+        rows[i] = importRow{
+            where: fmt.Sprintf("item %d", i+1),
+            question: database.Question{
+                Question:     item.Question,
+                Answer:       item.Answer,
+                Alternatives: item.Alternatives,
+                Check:        newAnswerCheck(item.Normalize, item.Tolerance),
+                Language:     item.Language,
+                Difficulty:   item.Difficulty,
+                Active:       item.Active == nil || *item.Active,
+            },
+        }
+    }
+
+    return rows, nil
+}
+
+var csvColumns = []string{"question", "answer", "alternatives", "language", "difficulty", "normalize", "tolerance", "active"}
+
+func parseQuestionCSV(data []byte) ([]importRow, error) {
+    reader := csv.NewReader(bytes.NewReader(data))
+    reader.FieldsPerRecord = -1
+    reader.TrimLeadingSpace = true
+
+    // Spreadsheets in many locales save CSV with semicolons
+    header, _, _ := bytes.Cut(data, []byte("\n"))
+    if bytes.Count(header, []byte(";")) > bytes.Count(header, []byte(",")) {
+        reader.Comma = ';'
+    }
+
+    records, err := reader.ReadAll()
+    if err != nil {
+        return nil, fmt.Errorf("invalid CSV: %v", err)
+    }
+    if len(records) < 2 {
+        return nil, errors.New("the document has no questions, the first line must name the columns")
+    }
+
+    columns := make(map[string]int)
+    for i, name := range records[0] {
+        name = strings.ToLower(strings.TrimSpace(name))
+        if !slices.Contains(csvColumns, name) {
+            return nil, fmt.Errorf("unknown column %q, expected %s", name, strings.Join(csvColumns, ", "))
+        }
+        columns[name] = i
+    }
+    if _, ok := columns["question"]; !ok {
+        return nil, errors.New("the question column is missing")
+    }
+    if _, ok := columns["answer"]; !ok {
+        return nil, errors.New("the answer column is missing")
+    }
+
+    var rows []importRow
+    for n, record := range records[1:] {
+        cell := func(column string) string {
+            i, ok := columns[column]
+            if !ok || i >= len(record) {
+                return ""
+            }
+            return record[i]
+        }
+
+        // Empty lines at the end of spreadsheets
+        if strings.TrimSpace(strings.Join(record, "")) == "" {
+            continue
+        }
+
+        row := importRow{
+            where: fmt.Sprintf("line %d", n+2),
+            question: database.Question{
+                Question:     cell("question"),
+                Answer:       cell("answer"),
+                Language:     cell("language"),
+                Difficulty:   cell("difficulty"),
+                Active:       true,
+            },
+        }
+
+        if value := cell("alternatives"); value != "" {
+            row.question.Alternatives = strings.Split(value, "|")
+        }
+
+        // An empty cell keeps the default steps, "none" turns them off
+        var steps []string
+        if value := strings.TrimSpace(cell("normalize")); value != "" {
+            steps = splitList(value)
+        }
+        tolerance := 0
+        if value := strings.TrimSpace(cell("tolerance")); value != "" {
+            var err error
+            if tolerance, err = strconv.Atoi(value); err != nil {
+                return nil, fmt.Errorf("line %d: expected a number in the tolerance column, got %q", n+2, value)
+            }
+        }
+        row.question.Check = newAnswerCheck(steps, tolerance)
+
+        if value := strings.TrimSpace(cell("active")); value != "" {
+            active, err := strconv.ParseBool(value)
+            if err != nil {
+                return nil, fmt.Errorf("line %d: expected true or false in the active column, got %q", n+2, value)
+            }
+            row.question.Active = active
+        }
+        rows = append(rows, row)
+    }
+
+    return rows, nil
+}
+
+// downloadFile fetches a file sent to the bot, at most limit bytes
+func (h *BotHandler) downloadFile(fileID string, limit int64) ([]byte, error) {
+    response, err := h.bot.Request(tgbotapi.FileConfig{FileID: fileID})
+    if err != nil {
+        return nil, err
+    }
+
+    var file tgbotapi.File
+    if err := json.Unmarshal(response.Result, &file); err != nil {
+        return nil, err
+    }
+    if int64(file.FileSize) > limit {
+        return nil, errors.New("the file is too big")
+    }
+
+    resp, err := fileClient.Get(file.Link(h.config.BotToken))
+    if err != nil {
+        // The URL contains the bot token, it must not end up in logs and messages
+        var urlErr *url.Error
+        if errors.As(err, &urlErr) {
+            err = urlErr.Err
+        }
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("unexpected status %s", resp.Status)
+    }
+
+    data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+    if err != nil {
+        return nil, err
+    }
+    if int64(len(data)) > limit {
+        return nil, errors.New("the file is too big")
+    }
+
+    return data, nil
+}
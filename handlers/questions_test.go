@@ -0,0 +1,228 @@
+package handlers
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+
+    "telegram-gatekeeper/config"
+    "telegram-gatekeeper/database"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestParseQuestionFile(t *testing.T) {
+    tests := []struct {
+        name    string
+        file    string
+        data    string
+        want    []database.Question
+        wantErr string
+    }{
+        {
+            name: "csv",
+            file: "questions.csv",
+            data: "\xef\xbb\xbfquestion,answer,difficulty\n\"Capital of France?\",Paris,easy\n\n5 + 5?,10,\n",
+            want: []database.Question{
+                {Question: "Capital of France?", Answer: "Paris", Difficulty: "easy", Active: true},
+                {Question: "5 + 5?", Answer: "10", Active: true},
+            },
+        },
+        {
+            name: "csv with semicolons",
+            file: "questions.csv",
+            data: "answer;question;language;active\nПариж;Столица Франции?;ru;false\n",
+            want: []database.Question{
+                {Question: "Столица Франции?", Answer: "Париж", Language: "ru"},
+            },
+        },
+        {
+            name: "json without an extension",
+            file: "questions",
+            data: `[{"question": "5 + 5?", "answer": "10", "language": "en", "difficulty": "hard"}]`,
+            want: []database.Question{
+                {Question: "5 + 5?", Answer: "10", Language: "en", Difficulty: "hard", Active: true},
+            },
+        },
+        {
+            name: "csv with alternatives and a check",
+            file: "questions.csv",
+            data: "question,answer,alternatives,normalize,tolerance\nCapital of USA?,Washington,Washington DC|DC,punctuation|numbers,1\nExact?,Yes,,none,\n",
+            want: []database.Question{
+                {
+                    Question: "Capital of USA?", Answer: "Washington", Alternatives: []string{"Washington DC", "DC"},
+                    Check: &database.AnswerCheck{Steps: []string{"punctuation", "numbers"}, Tolerance: 1}, Active: true,
+                },
+                {Question: "Exact?", Answer: "Yes", Check: &database.AnswerCheck{Steps: []string{}}, Active: true},
+            },
+        },
+        {
+            name: "json with alternatives",
+            file: "q.json",
+            data: `[{"question": "2 + 2?", "answer": "4", "alternatives": ["four"], "tolerance": 1}]`,
+            want: []database.Question{
+                {Question: "2 + 2?", Answer: "4", Alternatives: []string{"four"}, Check: &database.AnswerCheck{Steps: answerSteps, Tolerance: 1}, Active: true},
+            },
+        },
+        {name: "invalid tolerance", file: "q.csv", data: "question,answer,tolerance\na,b,some\n", wantErr: "tolerance column"},
+        {name: "unknown csv column", file: "q.csv", data: "question,answer,hint\na,b,c\n", wantErr: `unknown column "hint"`},
+        {name: "missing answer column", file: "q.csv", data: "question\na\n", wantErr: "answer column is missing"},
+        {name: "invalid active", file: "q.csv", data: "question,answer,active\na,b,maybe\n", wantErr: "line 2"},
+        {name: "unknown json field", file: "q.json", data: `[{"question": "a", "answers": ["b"]}]`, wantErr: "invalid JSON"},
+        {name: "empty", file: "q.json", data: `[]`, wantErr: "no questions"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rows, err := parseQuestionFile(tt.file, []byte(tt.data))
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("error = %v, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            if len(rows) != len(tt.want) {
+                t.Fatalf("got %d rows, want %d", len(rows), len(tt.want))
+            }
+            for i, row := range rows {
+                if !reflect.DeepEqual(row.question, tt.want[i]) {
+                    t.Errorf("%s = %+v, want %+v", row.where, row.question, tt.want[i])
+                }
+            }
+        })
+    }
+}
+
+func TestQuestionsCommand(t *testing.T) {
+    handler, sender, storage := newTestHandler()
+
+    handler.HandleUpdate(privateMessage(testAdminID, "/questions add ru easy Столица Франции? | Париж | Paris | париж"))
+    handler.HandleUpdate(privateMessage(testAdminID, "/questions add de easy Hauptstadt? | Paris"))
+
+    questions, _ := storage.GetQuestions()
+    if len(questions) != 1 || questions[0].Language != "ru" || questions[0].Answer != "Париж" || !questions[0].Active {
+        t.Fatalf("questions = %+v, want the ru question only", questions)
+    }
+    if !reflect.DeepEqual(questions[0].Alternatives, []string{"Paris"}) {
+        t.Errorf("alternatives = %q, want the repeated answer dropped", questions[0].Alternatives)
+    }
+    if texts := sender.textsTo(testAdminID); !strings.Contains(texts[len(texts)-1], "unknown language") {
+        t.Errorf("reply = %q, want an unknown language", texts[len(texts)-1])
+    }
+
+    id := questions[0].ID.Hex()
+    handler.HandleUpdate(privateMessage(testAdminID, "/questions disable "+id))
+    if _, ok := handler.pickQuestion("ru"); ok {
+        t.Error("a disabled question was drawn")
+    }
+
+    handler.HandleUpdate(privateMessage(testAdminID, "/questions enable "+id))
+    if q, ok := handler.pickQuestion("ru"); !ok || q.Answer != "Париж" {
+        t.Errorf("pickQuestion = %+v, %v, want the enabled question", q, ok)
+    }
+
+    handler.HandleUpdate(privateMessage(testAdminID, "/questions"))
+    if texts := sender.textsTo(testAdminID); !strings.Contains(texts[len(texts)-1], id) {
+        t.Errorf("list = %q, want the question ID", texts[len(texts)-1])
+    }
+
+    handler.HandleUpdate(privateMessage(testAdminID, "/questions check "+id+" punctuation,numbers 1"))
+    questions, _ = storage.GetQuestions()
+    if check := questions[0].Check; check == nil || !reflect.DeepEqual(check.Steps, []string{"punctuation", "numbers"}) || check.Tolerance != 1 {
+        t.Errorf("check = %+v, want punctuation and numbers with 1 typo", check)
+    }
+    handler.HandleUpdate(privateMessage(testAdminID, "/questions check "+id+" spelling"))
+    if texts := sender.textsTo(testAdminID); !strings.Contains(texts[len(texts)-1], "unknown normalization step") {
+        t.Errorf("reply = %q, want an unknown step", texts[len(texts)-1])
+    }
+    handler.HandleUpdate(privateMessage(testAdminID, "/questions check "+id+" default"))
+    if questions, _ = storage.GetQuestions(); questions[0].Check != nil {
+        t.Errorf("check = %+v, want the default", questions[0].Check)
+    }
+
+    handler.HandleUpdate(privateMessage(testAdminID, "/questions delete "+id))
+    if questions, _ := storage.GetQuestions(); len(questions) != 0 {
+        t.Errorf("questions = %+v after delete", questions)
+    }
+}
+
+func TestPickQuestion(t *testing.T) {
+    handler, _, storage := newTestHandler()
+
+    storage.AddQuestions([]database.Question{
+        {Question: "en easy", Answer: "a", Language: "en", Difficulty: database.DifficultyEasy, Active: true},
+        {Question: "en hard", Answer: "a", Language: "en", Difficulty: database.DifficultyHard, Active: true},
+        {Question: "ru hard", Answer: "a", Language: "ru", Difficulty: database.DifficultyHard, Active: true},
+    })
+
+    storage.SetSetting(testAdminID, "question_difficulty", database.DifficultyEasy)
+    handler.invalidateSettings()
+
+    for range 20 {
+        if q, _ := handler.pickQuestion("en"); q.Question != "en easy" {
+            t.Fatalf("en = %q, want the easy question", q.Question)
+        }
+        // The language matters more than the difficulty
+        if q, _ := handler.pickQuestion("ru"); q.Question != "ru hard" {
+            t.Fatalf("ru = %q, want the ru question", q.Question)
+        }
+    }
+}
+
+func TestSeedQuestions(t *testing.T) {
+    handler, _, storage := newTestHandler()
+    handler.config.Captcha.TextQuestions = []config.TextQuestion{
+        {Question: "Capital of USA?", Answer: "Washington"},
+        {Question: "Столица Франции?", Answer: "Париж", Language: "RU", Difficulty: "hard"},
+    }
+
+    for range 2 {
+        if err := handler.SeedQuestions(); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    questions, _ := storage.GetQuestions()
+    if len(questions) != 2 {
+        t.Fatalf("got %d questions, want 2 seeded once", len(questions))
+    }
+    if q := questions[0]; q.Language != "en" || q.Difficulty != database.DifficultyMedium || !q.Active {
+        t.Errorf("first question = %+v, want the defaults", q)
+    }
+    if q := questions[1]; q.Language != "ru" || q.Difficulty != database.DifficultyHard {
+        t.Errorf("second question = %+v", q)
+    }
+}
+
+func TestQuestionPromptIsEscaped(t *testing.T) {
+    handler, sender, storage := newTestHandler()
+
+    handler.HandleUpdate(privateMessage(testAdminID, "/questions add en easy What is 2*3 in [snake_case] <code>? | 6"))
+    if questions, _ := storage.GetQuestions(); len(questions) != 1 {
+        t.Fatalf("questions = %+v, want the question added", questions)
+    }
+
+    storage.SetSetting(testAdminID, "captcha_type", "text")
+    handler.invalidateSettings()
+    handler.HandleUpdate(privateMessage(testUserID, "/verify"))
+
+    var prompt *tgbotapi.MessageConfig
+    for _, c := range sender.sent {
+        if m, ok := c.(tgbotapi.MessageConfig); ok && m.ChatID == testUserID {
+            prompt = &m
+        }
+    }
+    if prompt == nil || prompt.ParseMode != "HTML" {
+        t.Fatalf("prompt = %+v, want an HTML message", prompt)
+    }
+    if !strings.Contains(prompt.Text, "What is 2*3 in [snake_case] &lt;code&gt;?") {
+        t.Errorf("prompt = %q, want the escaped question", prompt.Text)
+    }
+    if err := checkTelegramHTML(prompt.Text); err != nil {
+        t.Errorf("prompt = %q: %v", prompt.Text, err)
+    }
+}
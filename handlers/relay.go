@@ -0,0 +1,95 @@
+package handlers
+
+import (
+    "errors"
+    "log/slog"
+
+    "telegram-gatekeeper/database"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// saveRelayLink remembers who a message in the admin chat came from,
+// so that the admin can answer it with a Telegram reply
+func (h *BotHandler) saveRelayLink(sent tgbotapi.Message, userID int64) {
+    err := h.db.SaveRelayLink(sent.Chat.ID, sent.MessageID, userID)
+    if err != nil {
+        slog.Error("Error saving relay link", "chat_id", sent.Chat.ID, "user_id", userID, "error", err)
+    }
+}
+
+func (h *BotHandler) handleAdminReply(message *tgbotapi.Message) {
+    link, err := h.db.GetRelayLink(message.Chat.ID, message.ReplyToMessage.MessageID)
+    if err != nil {
+        if errors.Is(err, database.ErrNotFound) {
+            h.replyToAdmin(message, h.staffText("staff.reply.no_user"))
+            return
+        }
+        slog.Error("Error getting relay link", "error", err)
+        h.replyToAdmin(message, h.staffText("staff.reply.failed"))
+        return
+    }
+
+    relay, ok := buildRelayMessage(message, link.UserID)
+    if !ok {
+        h.replyToAdmin(message, h.staffText("staff.reply.unsupported"))
+        return
+    }
+
+    _, err = h.bot.Send(relay)
+    if err != nil {
+        slog.Error("Error relaying reply", "user_id", link.UserID, "error", err)
+        h.replyToAdmin(message, h.staffText("staff.reply.send_failed", err))
+        return
+    }
+
+    // Keeping the reply for /history
+    user, err := h.db.GetUserByTelegramID(link.UserID)
+    if err != nil {
+        slog.Error("Error getting user", "user_id", link.UserID, "error", err)
+    } else {
+        h.recordMessage(message, user, true, nil)
+    }
+
+    h.replyToAdmin(message, h.staffText("staff.reply.delivered"))
+}
+
+// buildRelayMessage copies the content of an admin reply into a message for the user
+func buildRelayMessage(message *tgbotapi.Message, userID int64) (tgbotapi.Chattable, bool) {
+    switch {
+    case message.Text != "":
+        return tgbotapi.NewMessage(userID, message.Text), true
+
+    case len(message.Photo) > 0:
+        // The last size is the largest one
+        photo := message.Photo[len(message.Photo)-1]
+        msg := tgbotapi.NewPhoto(userID, tgbotapi.FileID(photo.FileID))
+        msg.Caption = message.Caption
+        return msg, true
+
+    case message.Document != nil:
+        msg := tgbotapi.NewDocument(userID, tgbotapi.FileID(message.Document.FileID))
+        msg.Caption = message.Caption
+        return msg, true
+
+    case message.Voice != nil:
+        msg := tgbotapi.NewVoice(userID, tgbotapi.FileID(message.Voice.FileID))
+        msg.Caption = message.Caption
+        return msg, true
+
+    case message.Sticker != nil:
+        return tgbotapi.NewSticker(userID, tgbotapi.FileID(message.Sticker.FileID)), true
+    }
+
+    return nil, false
+}
+
+func (h *BotHandler) replyToAdmin(message *tgbotapi.Message, text string) {
+    msg := tgbotapi.NewMessage(message.Chat.ID, text)
+    msg.ReplyToMessageID = message.MessageID
+
+    _, err := h.bot.Send(msg)
+    if err != nil {
+        slog.Error("Error sending reply status to admin", "error", err)
+    }
+}
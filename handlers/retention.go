@@ -0,0 +1,92 @@
+package handlers
+
+import (
+    "context"
+    "log/slog"
+    "strings"
+    "time"
+)
+
+// retentionReport is what one cleanup run removed
+type retentionReport struct {
+    Messages  int64
+    Users     int64
+    Captchas  int64
+    Compacted int64
+    Failed    []string // Policies that failed
+}
+
+func (r *retentionReport) empty() bool {
+    return r.Messages == 0 && r.Users == 0 && r.Captchas == 0 && r.Compacted == 0 && len(r.Failed) == 0
+}
+
+// RunRetention applies the retention policies every interval until the context is cancelled
+func (h *BotHandler) RunRetention(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            h.applyRetention(time.Now())
+        }
+    }
+}
+
+// applyRetention runs every enabled policy and reports to the owner when
+// something was removed or a policy failed
+func (h *BotHandler) applyRetention(now time.Time) {
+    policies := h.config.Retention
+    report := &retentionReport{}
+
+    run := func(name string, period time.Duration, apply func(cutoff time.Time) (int64, error), count *int64) {
+        if period <= 0 {
+            return
+        }
+
+        n, err := apply(now.Add(-period))
+        *count += n
+        if err != nil {
+            slog.Error("Error applying retention policy", "policy", name, "error", err)
+            report.Failed = append(report.Failed, name)
+        }
+    }
+
+    run("messages", policies.Messages, h.db.DeleteMessagesBefore, &report.Messages)
+    run("stale captchas", policies.StaleCaptchas, h.db.ClearStaleCaptchas, &report.Captchas)
+    run("inactive users", policies.InactiveUsers, h.db.DeleteInactiveUsers, &report.Users)
+    run("blocked users", policies.CompactBlocked, func(cutoff time.Time) (int64, error) {
+        return h.db.CompactBlockedUsers(cutoff, h.adminID)
+    }, &report.Compacted)
+
+    if report.Compacted > 0 {
+        h.invalidateBlacklist()
+    }
+
+    slog.Info("Retention run finished",
+        "messages", report.Messages,
+        "users", report.Users,
+        "captchas", report.Captchas,
+        "compacted", report.Compacted,
+        "failed", len(report.Failed),
+    )
+
+    if !report.empty() {
+        h.sendMessage(h.adminID, h.formatRetentionReport(report))
+    }
+}
+
+func (h *BotHandler) formatRetentionReport(report *retentionReport) string {
+    var b strings.Builder
+
+    b.WriteString(h.staffText("staff.retention.report",
+        report.Messages, report.Users, report.Captchas, report.Compacted))
+
+    if len(report.Failed) > 0 {
+        b.WriteString(h.staffText("staff.retention.failed", strings.Join(report.Failed, ", ")))
+    }
+
+    return b.String()
+}
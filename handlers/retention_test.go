@@ -0,0 +1,125 @@
+package handlers
+
+import (
+    "errors"
+    "strconv"
+    "testing"
+    "time"
+
+    "telegram-gatekeeper/config"
+    "telegram-gatekeeper/database"
+)
+
+func TestApplyRetention(t *testing.T) {
+    const (
+        verifiedID = 201
+        blockedID  = 202
+        day        = 24 * time.Hour
+    )
+
+    handler, sender, storage := newTestHandler()
+    handler.config.Retention = config.RetentionConfig{
+        Messages:       30 * day,
+        InactiveUsers:  30 * day,
+        StaleCaptchas:  day,
+        CompactBlocked: 30 * day,
+    }
+
+    // An unverified user with a captcha that was never retired
+    giveCaptcha(t, storage, 1)
+
+    verified, _ := storage.GetOrCreateUser(verifiedID, "bob", "Bob", "", "", false)
+    storage.UpdateUserVerification(verifiedID, true)
+    storage.SaveMessage(&database.Message{UserID: verified.ID, Text: "old"})
+
+    storage.GetOrCreateUser(blockedID, "spam", "Spam", "", "", false)
+    storage.BlockUser(blockedID, nil, "Spam")
+
+    // Everything above happened 60 days before the run
+    handler.applyRetention(time.Now().Add(60 * day))
+
+    if _, err := storage.GetUserByTelegramID(testUserID); !errors.Is(err, database.ErrNotFound) {
+        t.Errorf("inactive unverified user was kept, err = %v", err)
+    }
+    if _, err := storage.GetUserByTelegramID(verifiedID); err != nil {
+        t.Errorf("verified user was deleted: %v", err)
+    }
+    if _, err := storage.GetUserByTelegramID(blockedID); !errors.Is(err, database.ErrNotFound) {
+        t.Errorf("blocked user was not compacted, err = %v", err)
+    }
+    if messages, _, _ := storage.GetUserMessages(verified.ID, 1, 10); len(messages) != 0 {
+        t.Errorf("old messages were kept: %v", messages)
+    }
+
+    entries, _ := storage.GetBlacklist()
+    if len(entries) != 1 || entries[0].TelegramID != blockedID || entries[0].Reason != "Spam" {
+        t.Errorf("blacklist = %+v, want the compacted user", entries)
+    }
+
+    assertSentContains(t, sender, testAdminID, "Messages purged: 1")
+    assertSentContains(t, sender, testAdminID, "Inactive unverified users deleted: 1")
+    assertSentContains(t, sender, testAdminID, "Stale captchas cleared: 1")
+    assertSentContains(t, sender, testAdminID, "Blocked users moved to the blacklist: 1")
+}
+
+func TestApplyRetentionReportsNothingWhenIdle(t *testing.T) {
+    handler, sender, storage := newTestHandler()
+    handler.config.Retention = config.RetentionConfig{InactiveUsers: 30 * 24 * time.Hour}
+
+    createUser(t, storage, false)
+    handler.applyRetention(time.Now())
+
+    if _, err := storage.GetUserByTelegramID(testUserID); err != nil {
+        t.Errorf("recent user was deleted: %v", err)
+    }
+    if len(sender.sent) != 0 {
+        t.Errorf("sent %d messages, want none", len(sender.sent))
+    }
+}
+
+func TestCompactBlockedUsersKeepsMessages(t *testing.T) {
+    handler, _, storage := newTestHandler()
+    handler.config.Retention = config.RetentionConfig{CompactBlocked: 30 * 24 * time.Hour}
+
+    user, _ := storage.GetOrCreateUser(testUserID, "spam", "Spam", "", "", false)
+    storage.SaveMessage(&database.Message{UserID: user.ID, Text: "spam"})
+    storage.BlockUser(testUserID, nil, "Spam")
+
+    handler.applyRetention(time.Now().Add(60 * 24 * time.Hour))
+
+    if _, err := storage.GetUserByTelegramID(testUserID); !errors.Is(err, database.ErrNotFound) {
+        t.Errorf("blocked user was not compacted, err = %v", err)
+    }
+    if entries, _ := storage.GetBlacklist(); len(entries) != 1 {
+        t.Errorf("blacklist = %+v, want the compacted user", entries)
+    }
+    // Messages are left to the message retention
+    if messages, _, _ := storage.GetUserMessages(user.ID, 1, 10); len(messages) != 1 {
+        t.Errorf("messages = %v, want them kept", messages)
+    }
+}
+
+func TestCompactBlockedUsersKeepsExistingBans(t *testing.T) {
+    handler, _, storage := newTestHandler()
+    handler.config.Retention = config.RetentionConfig{CompactBlocked: 30 * 24 * time.Hour}
+
+    storage.GetOrCreateUser(testUserID, "spam", "Spam", "", "", false)
+    storage.BlockUser(testUserID, nil, "Spam")
+    storage.AddBlacklistEntry(&database.BlacklistEntry{
+        Kind:       database.BlacklistByID,
+        Value:      strconv.FormatInt(testUserID, 10),
+        TelegramID: testUserID,
+        Reason:     "Scam links",
+        AddedBy:    300,
+    })
+
+    handler.applyRetention(time.Now().Add(60 * 24 * time.Hour))
+
+    if _, err := storage.GetUserByTelegramID(testUserID); !errors.Is(err, database.ErrNotFound) {
+        t.Errorf("blocked user was not compacted, err = %v", err)
+    }
+    entries, _ := storage.GetBlacklist()
+    if len(entries) != 1 || entries[0].Reason != "Scam links" || entries[0].AddedBy != 300 {
+        t.Errorf("blacklist = %+v, want the ban kept as it was", entries)
+    }
+}
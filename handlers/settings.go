@@ -0,0 +1,322 @@
+package handlers
+
+import (
+    "errors"
+    "fmt"
+    "html"
+    "log/slog"
+    "maps"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "telegram-gatekeeper/database"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// How long loaded settings are reused before they are read from the database again
+const settingsCacheTTL = 30 * time.Second
+
+var captchaTypes = []string{"math", "text", "button", "image"}
+
+// adminSettings are the effective settings: stored values on top of the config defaults
+type adminSettings struct {
+    AutoForwardEnabled bool
+    CaptchaType        string
+    MaxAttempts        int
+    CaptchaTTL         time.Duration
+    BlockDuration      time.Duration // 0 - forever
+    QuestionDifficulty string        // Empty - any
+    Templates          map[string]string
+}
+
+// settingField is a value of /set, described to staff by the "staff.settings.<key>" text
+type settingField struct {
+    key   string
+    field string // Key of the value in the stored settings
+    get   func(s *adminSettings) string
+    parse func(value string) (any, error) // The value to store
+}
+
+var settingFields = []settingField{
+    {
+        key:   "max_attempts",
+        field: "max_attempts",
+        get: func(s *adminSettings) string {
+            return strconv.Itoa(s.MaxAttempts)
+        },
+        parse: func(value string) (any, error) {
+            n, err := strconv.Atoi(value)
+            if err != nil || n < 1 || n > 20 {
+                return nil, errors.New("expected a number from 1 to 20")
+            }
+            return n, nil
+        },
+    },
+    {
+        key:   "captcha_type",
+        field: "captcha_type",
+        get: func(s *adminSettings) string {
+            return s.CaptchaType
+        },
+        parse: func(value string) (any, error) {
+            value = strings.ToLower(value)
+            if value != "random" && !slices.Contains(captchaTypes, value) {
+                return nil, fmt.Errorf("expected random or one of: %s", strings.Join(captchaTypes, ", "))
+            }
+            return value, nil
+        },
+    },
+    {
+        key:   "captcha_ttl",
+        field: "captcha_ttl",
+        get: func(s *adminSettings) string {
+            return formatDuration(s.CaptchaTTL)
+        },
+        parse: func(value string) (any, error) {
+            d, err := parseDuration(value)
+            if err != nil || d < 10*time.Second || d > 24*time.Hour {
+                return nil, errors.New("expected a duration from 10s to 24h, e.g. 2m")
+            }
+            return d, nil
+        },
+    },
+    {
+        key:   "block_duration",
+        field: "block_duration",
+        get: func(s *adminSettings) string {
+            if s.BlockDuration == 0 {
+                return "forever"
+            }
+            return formatDuration(s.BlockDuration)
+        },
+        parse: func(value string) (any, error) {
+            if value == "0" || strings.EqualFold(value, "forever") {
+                return time.Duration(0), nil
+            }
+            d, err := parseDuration(value)
+            if err != nil || d <= 0 {
+                return nil, errors.New("expected a duration such as 30m, 24h or 7d, or 0 for forever")
+            }
+            return d, nil
+        },
+    },
+    {
+        key:   "question_difficulty",
+        field: "question_difficulty",
+        get: func(s *adminSettings) string {
+            if s.QuestionDifficulty == "" {
+                return "any"
+            }
+            return s.QuestionDifficulty
+        },
+        parse: func(value string) (any, error) {
+            value = strings.ToLower(value)
+            if value == "any" {
+                return "", nil
+            }
+            if !slices.Contains(questionDifficulties, value) {
+                return nil, fmt.Errorf("expected any or one of: %s", strings.Join(questionDifficulties, ", "))
+            }
+            return value, nil
+        },
+    },
+    {
+        key:   "auto_forward",
+        field: "auto_forward_enabled",
+        get: func(s *adminSettings) string {
+            if s.AutoForwardEnabled {
+                return "on"
+            }
+            return "off"
+        },
+        parse: func(value string) (any, error) {
+            switch strings.ToLower(value) {
+            case "on", "true", "yes", "1":
+                return true, nil
+            case "off", "false", "no", "0":
+                return false, nil
+            }
+            return nil, errors.New("expected on or off")
+        },
+    },
+}
+
+// settings returns the effective admin settings: stored values on top of the config defaults
+func (h *BotHandler) settings() adminSettings {
+    h.settingsMu.Lock()
+    defer h.settingsMu.Unlock()
+
+    if h.settingsCache != nil && time.Since(h.settingsLoadedAt) < settingsCacheTTL {
+        return *h.settingsCache
+    }
+
+    settings := h.defaultSettings()
+
+    stored, err := h.db.GetSettings(h.adminID)
+    if err == nil {
+        mergeSettings(&settings, stored)
+    } else if !errors.Is(err, database.ErrNotFound) {
+        slog.Error("Error loading settings", "error", err)
+
+        // Better stale settings than defaults
+        if h.settingsCache != nil {
+            return *h.settingsCache
+        }
+    }
+
+    h.settingsCache = &settings
+    h.settingsLoadedAt = time.Now()
+
+    return settings
+}
+
+func (h *BotHandler) defaultSettings() adminSettings {
+    defaults := h.config.Defaults
+
+    settings := adminSettings{
+        AutoForwardEnabled: defaults.AutoForward,
+        CaptchaType:        defaults.CaptchaType,
+        MaxAttempts:        defaults.MaxAttempts,
+        CaptchaTTL:         defaults.CaptchaTTL,
+        BlockDuration:      defaults.BlockDuration,
+        Templates:          make(map[string]string),
+    }
+
+    if defaults.WelcomeMessage != "" {
+        settings.Templates[templateWelcome] = defaults.WelcomeMessage
+    }
+    if defaults.VerifiedMessage != "" {
+        settings.Templates[templateVerified] = defaults.VerifiedMessage
+    }
+
+    if settings.MaxAttempts < 1 {
+        settings.MaxAttempts = 3
+    }
+    if settings.CaptchaTTL <= 0 {
+        settings.CaptchaTTL = 2 * time.Minute
+    }
+    if settings.CaptchaType == "" {
+        settings.CaptchaType = "random"
+    }
+
+    return settings
+}
+
+// mergeSettings puts the stored values on top of the defaults, values that
+// were never set keep following the config
+func mergeSettings(settings *adminSettings, stored *database.AdminSettings) {
+    if stored.AutoForwardEnabled != nil {
+        settings.AutoForwardEnabled = *stored.AutoForwardEnabled
+    }
+    if stored.BlockDuration != nil {
+        settings.BlockDuration = *stored.BlockDuration
+    }
+    if stored.QuestionDifficulty != nil {
+        settings.QuestionDifficulty = *stored.QuestionDifficulty
+    }
+    if stored.MaxAttempts > 0 {
+        settings.MaxAttempts = stored.MaxAttempts
+    }
+    if stored.CaptchaTTL > 0 {
+        settings.CaptchaTTL = stored.CaptchaTTL
+    }
+    if stored.CaptchaType != "" {
+        settings.CaptchaType = stored.CaptchaType
+    }
+
+    // Texts saved before templates, they are dropped when the template is changed
+    if stored.WelcomeMessage != "" {
+        settings.Templates[templateWelcome] = stored.WelcomeMessage
+    }
+    if stored.VerifiedMessage != "" {
+        settings.Templates[templateVerified] = stored.VerifiedMessage
+    }
+    maps.Copy(settings.Templates, stored.Templates)
+}
+
+func (h *BotHandler) handleSettingsCommand(message *tgbotapi.Message) {
+    settings := h.settings()
+
+    var sb strings.Builder
+    sb.WriteString(h.staffText("staff.settings.title"))
+
+    for _, field := range settingFields {
+        fmt.Fprintf(&sb, "\n<code>%s</code>: %s\n<i>%s</i>\n",
+            field.key,
+            html.EscapeString(field.get(&settings)),
+            html.EscapeString(h.staffText("staff.settings."+field.key)),
+        )
+    }
+
+    sb.WriteString(h.staffText("staff.settings.footer"))
+
+    h.sendMessageHTML(message.Chat.ID, sb.String())
+}
+
+func (h *BotHandler) handleSetCommand(message *tgbotapi.Message) {
+    key, value, _ := strings.Cut(strings.TrimSpace(message.CommandArguments()), " ")
+    key = strings.ToLower(key)
+    value = strings.TrimSpace(value)
+
+    if key == "" || value == "" {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.set.usage"))
+        return
+    }
+
+    idx := slices.IndexFunc(settingFields, func(f settingField) bool { return f.key == key })
+    if idx < 0 {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.set.unknown", html.EscapeString(key)))
+        return
+    }
+    field := settingFields[idx]
+
+    // Only this value is stored, the others keep following the config
+    var stored any
+    if !strings.EqualFold(value, "default") {
+        var err error
+        if stored, err = field.parse(value); err != nil {
+            h.sendMessageHTML(message.Chat.ID, h.staffText("staff.set.invalid", field.key, html.EscapeString(err.Error())))
+            return
+        }
+    }
+
+    if err := h.db.SetSetting(h.adminID, field.field, stored); err != nil {
+        slog.Error("Error saving settings", "error", err)
+        h.sendMessage(message.Chat.ID, h.staffText("staff.set.failed"))
+        return
+    }
+
+    h.invalidateSettings()
+    settings := h.settings()
+
+    h.sendMessageHTML(message.Chat.ID, h.staffText("staff.set.done",
+        field.key, html.EscapeString(field.get(&settings))))
+}
+
+func (h *BotHandler) invalidateSettings() {
+    h.settingsMu.Lock()
+    h.settingsCache = nil
+    h.settingsMu.Unlock()
+}
+
+// parseDuration understands everything time.ParseDuration does plus days, e.g. "7d"
+func parseDuration(value string) (time.Duration, error) {
+    if days, ok := strings.CutSuffix(value, "d"); ok {
+        n, err := strconv.Atoi(days)
+        if err != nil {
+            return 0, err
+        }
+        return time.Duration(n) * 24 * time.Hour, nil
+    }
+    return time.ParseDuration(value)
+}
+
+func formatDuration(d time.Duration) string {
+    if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+        return fmt.Sprintf("%dd", d/(24*time.Hour))
+    }
+    return d.String()
+}
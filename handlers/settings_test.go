@@ -0,0 +1,43 @@
+package handlers
+
+import (
+    "testing"
+    "time"
+)
+
+func TestSetStoresOnlyTheChangedValue(t *testing.T) {
+    handler, _, storage := newTestHandler()
+
+    handler.HandleUpdate(privateMessage(testAdminID, "/set max_attempts 5"))
+    handler.HandleUpdate(privateMessage(testAdminID, "/set block_duration 0"))
+
+    stored, err := storage.GetSettings(testAdminID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stored.MaxAttempts != 5 || stored.BlockDuration == nil || *stored.BlockDuration != 0 {
+        t.Errorf("stored = %+v, want max_attempts and block_duration", stored)
+    }
+    if stored.AutoForwardEnabled != nil || stored.CaptchaTTL != 0 || stored.CaptchaType != "" {
+        t.Errorf("stored = %+v, want the other values left to the config", stored)
+    }
+
+    // Later changes of the config still apply to the values that were not set
+    handler.config.Defaults.AutoForward = false
+    handler.config.Defaults.CaptchaTTL = 5 * time.Minute
+    handler.config.Defaults.BlockDuration = time.Hour
+    handler.invalidateSettings()
+
+    settings := handler.settings()
+    if settings.MaxAttempts != 5 || settings.BlockDuration != 0 {
+        t.Errorf("settings = %+v, want the values set with /set", settings)
+    }
+    if settings.AutoForwardEnabled || settings.CaptchaTTL != 5*time.Minute {
+        t.Errorf("settings = %+v, want the new config defaults", settings)
+    }
+
+    handler.HandleUpdate(privateMessage(testAdminID, "/set block_duration default"))
+    if settings := handler.settings(); settings.BlockDuration != time.Hour {
+        t.Errorf("block_duration = %s, want the config default again", settings.BlockDuration)
+    }
+}
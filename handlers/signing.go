@@ -0,0 +1,90 @@
+package handlers
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "errors"
+    "strconv"
+    "strings"
+    "time"
+
+    "telegram-gatekeeper/config"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Callback data is limited to 64 bytes, so the signature is a truncated
+// HMAC-SHA256 and the expiry a base 36 Unix time:
+// <payload>_<expiry>_<signature>
+const callbackSignatureSize = 8
+
+// Length of the encoded signature. It may contain "_" itself, so it is cut off
+// by length rather than by separator
+var encodedSignatureLen = base64.RawURLEncoding.EncodedLen(callbackSignatureSize)
+
+var (
+    errCallbackForged  = errors.New("invalid callback signature")
+    errCallbackExpired = errors.New("callback has expired")
+)
+
+// deriveCallbackKey uses the configured secret. Without one the key is derived
+// from the bot token, which replicas of the same bot share anyway
+func deriveCallbackKey(cfg *config.Config) []byte {
+    secret := cfg.CallbackSecret
+    if secret == "" {
+        secret = "callback:" + cfg.BotToken
+    }
+
+    sum := sha256.Sum256([]byte(secret))
+    return sum[:]
+}
+
+func callbackSignature(key []byte, signed string) string {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(signed))
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:callbackSignatureSize])
+}
+
+func signCallbackData(key []byte, payload string, expiresAt time.Time) string {
+    signed := payload + "_" + strconv.FormatInt(expiresAt.Unix(), 36)
+    return signed + "_" + callbackSignature(key, signed)
+}
+
+// verifyCallbackData checks the signature and the expiry and returns the payload
+func verifyCallbackData(key []byte, data string, now time.Time) (string, error) {
+    i := len(data) - encodedSignatureLen - 1
+    if i < 0 || data[i] != '_' {
+        return "", errCallbackForged
+    }
+    signed, signature := data[:i], data[i+1:]
+
+    if !hmac.Equal([]byte(signature), []byte(callbackSignature(key, signed))) {
+        return "", errCallbackForged
+    }
+
+    j := strings.LastIndex(signed, "_")
+    if j < 0 {
+        return "", errCallbackForged
+    }
+
+    expiresAt, err := strconv.ParseInt(signed[j+1:], 36, 64)
+    if err != nil {
+        return "", errCallbackForged
+    }
+    if now.Unix() > expiresAt {
+        return "", errCallbackExpired
+    }
+
+    return signed[:j], nil
+}
+
+// callbackButton is an inline button whose data is signed and expires at expiresAt
+func (h *BotHandler) callbackButton(text, payload string, expiresAt time.Time) tgbotapi.InlineKeyboardButton {
+    return tgbotapi.NewInlineKeyboardButtonData(text, signCallbackData(h.callbackKey, payload, expiresAt))
+}
+
+// adminButton is a signed button of an admin card, it stays valid for CallbackTTL
+func (h *BotHandler) adminButton(text, payload string) tgbotapi.InlineKeyboardButton {
+    return h.callbackButton(text, payload, time.Now().Add(h.config.CallbackTTL))
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+    "cmp"
+    "fmt"
+    "log/slog"
+    "slices"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "telegram-gatekeeper/config"
+    "telegram-gatekeeper/database"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Permissions of staff roles
+const (
+    PermAccept   = "accept"   // Accept and reject users
+    PermBlock    = "block"    // Block users and manage the blacklist
+    PermHistory  = "history"  // Read conversations
+    PermReply    = "reply"    // Reply to users
+    PermSettings = "settings" // Change the bot settings
+    PermStaff    = "staff"    // Add and remove staff members
+)
+
+var rolePermissions = map[string][]string{
+    database.RoleOwner:     {PermAccept, PermBlock, PermHistory, PermReply, PermSettings, PermStaff},
+    database.RoleModerator: {PermAccept, PermBlock, PermHistory, PermReply},
+    database.RoleViewer:    {PermHistory},
+}
+
+// How long the loaded staff list is used before it is read from the database again
+const staffCacheTTL = 30 * time.Second
+
+type staffCache struct {
+    mu       sync.Mutex
+    members  map[int64]database.StaffMember
+    loadedAt time.Time
+}
+
+// staffMember returns the staff member with the given ID. The owner and the
+// staff from the config are always staff
+func (h *BotHandler) staffMember(telegramID int64) (database.StaffMember, bool) {
+    c := &h.staff
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.members == nil || time.Since(c.loadedAt) >= staffCacheTTL {
+        h.reloadStaffLocked()
+    }
+
+    member, ok := c.members[telegramID]
+    return member, ok
+}
+
+func (h *BotHandler) reloadStaffLocked() {
+    c := &h.staff
+
+    members, err := h.db.GetStaff()
+    if err != nil {
+        slog.Error("Error loading staff", "error", err)
+        if c.members != nil {
+            return
+        }
+        members = nil
+    }
+
+    // Like the owner, staff from the config keep their role, a stored record
+    // only changes their subscription
+    fixed := map[int64]string{h.adminID: database.RoleOwner}
+    for _, member := range h.config.Staff {
+        fixed[member.ID] = member.Role
+    }
+
+    c.members = make(map[int64]database.StaffMember, len(fixed)+len(members))
+    for telegramID, role := range fixed {
+        c.members[telegramID] = database.StaffMember{TelegramID: telegramID, Role: role, Subscribed: true}
+    }
+    for _, member := range members {
+        if role, ok := fixed[member.TelegramID]; ok {
+            member.Role = role
+        }
+        c.members[member.TelegramID] = member
+    }
+
+    c.loadedAt = time.Now()
+}
+
+func (h *BotHandler) invalidateStaff() {
+    h.staff.mu.Lock()
+    h.staff.members = nil
+    h.staff.mu.Unlock()
+}
+
+// isConfigStaff reports whether the staff member is defined in the config
+func (h *BotHandler) isConfigStaff(telegramID int64) bool {
+    return slices.ContainsFunc(h.config.Staff, func(member config.StaffConfig) bool {
+        return member.ID == telegramID
+    })
+}
+
+// isStaff reports whether the user is a staff member of any role
+func (h *BotHandler) isStaff(telegramID int64) bool {
+    _, ok := h.staffMember(telegramID)
+    return ok
+}
+
+// can reports whether the user's role has the permission
+func (h *BotHandler) can(telegramID int64, permission string) bool {
+    member, ok := h.staffMember(telegramID)
+    return ok && slices.Contains(rolePermissions[member.Role], permission)
+}
+
+// staffMembers returns all staff members, the owner first
+func (h *BotHandler) staffMembers() []database.StaffMember {
+    c := &h.staff
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.members == nil || time.Since(c.loadedAt) >= staffCacheTTL {
+        h.reloadStaffLocked()
+    }
+
+    members := make([]database.StaffMember, 0, len(c.members))
+    for _, member := range c.members {
+        members = append(members, member)
+    }
+
+    slices.SortFunc(members, func(a, b database.StaffMember) int {
+        if (a.TelegramID == h.adminID) != (b.TelegramID == h.adminID) {
+            if a.TelegramID == h.adminID {
+                return -1
+            }
+            return 1
+        }
+        return cmp.Compare(a.TelegramID, b.TelegramID)
+    })
+
+    return members
+}
+
+// staffRecipients returns the chats that receive forwards and notifications
+func (h *BotHandler) staffRecipients() []int64 {
+    var recipients []int64
+    for _, member := range h.staffMembers() {
+        if member.Subscribed {
+            recipients = append(recipients, member.TelegramID)
+        }
+    }
+    return recipients
+}
+
+// syncAdminCards marks every copy of the cards about the user as handled and
+// removes their buttons. The card that was clicked is updated by the caller
+func (h *BotHandler) syncAdminCards(userID int64, status string, clicked *tgbotapi.Message) {
+    cards, err := h.db.TakeAdminCards(userID)
+    if err != nil {
+        slog.Error("Error loading admin cards", "error", err)
+    }
+
+    for _, card := range cards {
+        if clicked != nil && card.ChatID == clicked.Chat.ID && card.MessageID == clicked.MessageID {
+            continue
+        }
+
+        editMsg := tgbotapi.NewEditMessageText(card.ChatID, card.MessageID, card.Text+"\n\n"+status)
+        if _, err := h.bot.Send(editMsg); err != nil {
+            slog.Error("Error updating admin card", "chat_id", card.ChatID, "user_id", userID, "error", err)
+        }
+    }
+}
+
+// staffName is how actions of a staff member are signed on admin cards
+func staffName(user *tgbotapi.User) string {
+    if user.UserName != "" {
+        return "@" + user.UserName
+    }
+    return user.FirstName
+}
+
+func (h *BotHandler) handleAddModCommand(message *tgbotapi.Message) {
+    args := strings.Fields(message.CommandArguments())
+    if len(args) == 0 || len(args) > 2 {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.addmod.usage"))
+        return
+    }
+
+    telegramID, err := strconv.ParseInt(args[0], 10, 64)
+    if err != nil {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.invalid_id"))
+        return
+    }
+
+    role := database.RoleModerator
+    if len(args) == 2 {
+        role = strings.ToLower(args[1])
+    }
+    if _, ok := rolePermissions[role]; !ok {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.addmod.unknown_role"))
+        return
+    }
+
+    if telegramID == h.adminID {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.addmod.owner"))
+        return
+    }
+    if h.isConfigStaff(telegramID) {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.addmod.config"))
+        return
+    }
+
+    err = h.db.SaveStaffMember(&database.StaffMember{
+        TelegramID: telegramID,
+        Role:       role,
+        Subscribed: true,
+        AddedBy:    message.From.ID,
+    })
+    if err != nil {
+        slog.Error("Error saving staff member", "error", err)
+        h.sendMessage(message.Chat.ID, h.staffText("error.server_message"))
+        return
+    }
+    h.invalidateStaff()
+
+    h.sendMessageHTML(message.Chat.ID, h.staffText("staff.addmod.done", telegramID, role))
+    h.sendMessage(telegramID, h.staffText("staff.addmod.welcome", role))
+}
+
+func (h *BotHandler) handleRemoveModCommand(message *tgbotapi.Message) {
+    args := strings.Fields(message.CommandArguments())
+    if len(args) != 1 {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.removemod.usage"))
+        return
+    }
+
+    telegramID, err := strconv.ParseInt(args[0], 10, 64)
+    if err != nil {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.invalid_id"))
+        return
+    }
+
+    if telegramID == h.adminID {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.removemod.owner"))
+        return
+    }
+    if h.isConfigStaff(telegramID) {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.removemod.config"))
+        return
+    }
+
+    removed, err := h.db.RemoveStaffMember(telegramID)
+    if err != nil {
+        slog.Error("Error removing staff member", "error", err)
+        h.sendMessage(message.Chat.ID, h.staffText("error.server_message"))
+        return
+    }
+    if !removed {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.removemod.not_staff", telegramID))
+        return
+    }
+    h.invalidateStaff()
+
+    h.sendMessageHTML(message.Chat.ID, h.staffText("staff.removemod.done", telegramID))
+    h.sendMessage(telegramID, h.staffText("staff.removemod.farewell"))
+}
+
+func (h *BotHandler) handleStaffCommand(message *tgbotapi.Message) {
+    var lines []string
+    for _, member := range h.staffMembers() {
+        line := fmt.Sprintf("• <code>%d</code> — %s", member.TelegramID, member.Role)
+        if !member.Subscribed {
+            line += h.staffText("staff.list.unsubscribed")
+        }
+        lines = append(lines, line)
+    }
+
+    h.sendMessageHTML(message.Chat.ID, h.staffText("staff.list.title")+strings.Join(lines, "\n"))
+}
+
+// handleNotificationsCommand lets staff members turn forwards and notifications off and on
+func (h *BotHandler) handleNotificationsCommand(message *tgbotapi.Message) {
+    arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+    if arg != "on" && arg != "off" {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.notifications.usage"))
+        return
+    }
+
+    member, _ := h.staffMember(message.From.ID)
+    member.Subscribed = arg == "on"
+    if member.AddedBy == 0 {
+        member.AddedBy = message.From.ID
+    }
+
+    if err := h.db.SaveStaffMember(&member); err != nil {
+        slog.Error("Error saving staff member", "error", err)
+        h.sendMessage(message.Chat.ID, h.staffText("error.server_message"))
+        return
+    }
+    h.invalidateStaff()
+
+    if member.Subscribed {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.notifications.on"))
+    } else {
+        h.sendMessage(message.Chat.ID, h.staffText("staff.notifications.off"))
+    }
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+    "log/slog"
+    "strings"
+    "time"
+
+    "telegram-gatekeeper/database"
+    "telegram-gatekeeper/metrics"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Period reported by /stats without an argument
+const defaultStatsPeriod = 7 * 24 * time.Hour
+
+// saveCaptcha stores the captcha for the user and records that it was issued
+func (h *BotHandler) saveCaptcha(telegramID int64, captcha *database.Captcha) error {
+    if err := h.db.SaveCaptcha(telegramID, captcha); err != nil {
+        return err
+    }
+    h.recordCaptchaIssued(telegramID, captcha)
+    return nil
+}
+
+// saveGroupCaptcha stores the captcha of a new member and records that it was issued
+func (h *BotHandler) saveGroupCaptcha(pending *database.GroupCaptcha) error {
+    if err := h.db.SaveGroupCaptcha(pending); err != nil {
+        return err
+    }
+    h.recordCaptchaIssued(pending.TelegramID, &pending.Captcha)
+    return nil
+}
+
+func (h *BotHandler) recordCaptchaIssued(telegramID int64, captcha *database.Captcha) {
+    metrics.CountCaptcha(captcha.Type, metrics.CaptchaIssued)
+
+    err := h.db.RecordCaptchaIssued(&database.CaptchaStat{
+        Nonce:      captcha.Nonce,
+        TelegramID: telegramID,
+        Type:       captcha.Type,
+        Group:      captcha.IsGroup(),
+        IssuedAt:   captcha.CreatedAt,
+    })
+    if err != nil {
+        slog.Error("Error recording captcha", "user_id", telegramID, "captcha_type", captcha.Type, "error", err)
+    }
+}
+
+// recordCaptchaOutcome records how the captcha ended. Callers only report an
+// outcome that the database accepted, so each captcha is counted once
+func (h *BotHandler) recordCaptchaOutcome(captcha *database.Captcha, outcome string) {
+    if outcome == database.OutcomeTimeout {
+        metrics.CountCaptcha(captcha.Type, "expired")
+    } else {
+        metrics.CountCaptcha(captcha.Type, outcome)
+    }
+
+    if err := h.db.RecordCaptchaOutcome(captcha.Nonce, outcome, time.Now()); err != nil {
+        slog.Error("Error recording captcha outcome", "captcha_type", captcha.Type, "outcome", outcome, "error", err)
+    }
+}
+
+// captchaOutcome is the outcome of an answer that was accepted by AnswerCaptcha
+func captchaOutcome(correct bool) string {
+    if correct {
+        return database.OutcomePassed
+    }
+    return database.OutcomeFailed
+}
+
+func (h *BotHandler) handleStatsCommand(message *tgbotapi.Message) {
+    arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+
+    var since time.Time
+    title := h.staffText("staff.stats.all_time")
+    switch arg {
+    case "":
+        since = time.Now().Add(-defaultStatsPeriod)
+        title = h.staffText("staff.stats.last", formatDuration(defaultStatsPeriod))
+    case "all":
+    default:
+        period, err := parseDuration(arg)
+        if err != nil || period <= 0 {
+            h.sendMessageHTML(message.Chat.ID, h.staffText("staff.stats.usage"))
+            return
+        }
+        since = time.Now().Add(-period)
+        title = h.staffText("staff.stats.last", formatDuration(period))
+    }
+
+    stats, err := h.db.GetStats(since)
+    if err != nil {
+        slog.Error("Error loading stats", "error", err)
+        h.sendMessage(message.Chat.ID, h.staffText("error.server_message"))
+        return
+    }
+
+    h.sendMessageHTML(message.Chat.ID, h.formatStats(title, stats))
+}
+
+func (h *BotHandler) formatStats(title string, stats *database.Stats) string {
+    var b strings.Builder
+
+    b.WriteString(h.staffText("staff.stats.summary", title,
+        stats.NewUsers, stats.Blocks, stats.Received, stats.Forwarded, stats.Replies))
+
+    b.WriteString(h.staffText("staff.stats.captchas"))
+    if len(stats.Captchas) == 0 {
+        b.WriteString(h.staffText("staff.stats.no_captchas"))
+    }
+    for _, c := range stats.Captchas {
+        b.WriteString(h.staffText("staff.stats.captcha",
+            c.Type, c.Issued, c.PassRate()*100, c.Passed, c.Failed, c.Timeout))
+    }
+
+    if stats.MedianSolveTime > 0 {
+        b.WriteString(h.staffText("staff.stats.median", stats.MedianSolveTime.Round(time.Second)))
+    }
+
+    return strings.TrimRight(b.String(), "\n")
+}
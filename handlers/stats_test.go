@@ -0,0 +1,81 @@
+package handlers
+
+import (
+    "testing"
+    "time"
+
+    "telegram-gatekeeper/database"
+    "telegram-gatekeeper/metrics"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// captchaCount reads gatekeeper_captchas_total, which is shared by every test
+// of the binary
+func captchaCount(t *testing.T, captchaType, event string) float64 {
+    t.Helper()
+
+    families, err := prometheus.DefaultGatherer.Gather()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for _, family := range families {
+        if family.GetName() != "gatekeeper_captchas_total" {
+            continue
+        }
+        for _, metric := range family.GetMetric() {
+            labels := map[string]string{}
+            for _, label := range metric.GetLabel() {
+                labels[label.GetName()] = label.GetValue()
+            }
+            if labels["type"] == captchaType && labels["event"] == event {
+                return metric.GetCounter().GetValue()
+            }
+        }
+    }
+    return 0
+}
+
+func TestCaptchaCounters(t *testing.T) {
+    const (
+        passingID = 201
+        failingID = 202
+        idleID    = 203
+    )
+    handler, _, storage := newTestHandler()
+
+    captchaOf := func(telegramID int64) *database.Captcha {
+        user, err := storage.GetUserByTelegramID(telegramID)
+        if err != nil || user.CaptchaData == nil {
+            t.Fatalf("no captcha for %d: %v", telegramID, err)
+        }
+        return user.CaptchaData
+    }
+
+    events := []string{metrics.CaptchaIssued, database.OutcomePassed, database.OutcomeFailed, "expired"}
+    before := map[string]float64{}
+    for _, event := range events {
+        before[event] = captchaCount(t, "math", event)
+    }
+
+    handler.HandleUpdate(privateMessage(passingID, "/verify"))
+    handler.HandleUpdate(privateMessage(passingID, captchaOf(passingID).Answer))
+
+    handler.HandleUpdate(privateMessage(failingID, "/verify"))
+    handler.HandleUpdate(privateMessage(failingID, captchaOf(failingID).Answer+"0"))
+
+    handler.HandleUpdate(privateMessage(idleID, "/verify"))
+    captcha := captchaOf(idleID)
+    captcha.ExpiresAt = time.Now().Add(-time.Second)
+    storage.SaveCaptcha(idleID, captcha)
+    handler.sweepExpiredCaptchas()
+
+    // A wrong answer is followed by a new captcha
+    want := map[string]float64{metrics.CaptchaIssued: 4, database.OutcomePassed: 1, database.OutcomeFailed: 1, "expired": 1}
+    for _, event := range events {
+        if got := captchaCount(t, "math", event) - before[event]; got != want[event] {
+            t.Errorf("%s = %v, want %v", event, got, want[event])
+        }
+    }
+}
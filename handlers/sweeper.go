@@ -0,0 +1,100 @@
+package handlers
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "time"
+
+    "telegram-gatekeeper/database"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// How many expired captchas are handled in one sweep
+const sweepBatchSize = 100
+
+// RunCaptchaSweeper retires expired captchas every interval until the context is cancelled
+func (h *BotHandler) RunCaptchaSweeper(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            h.sweepExpiredCaptchas()
+        }
+    }
+}
+
+func (h *BotHandler) sweepExpiredCaptchas() {
+    users, err := h.db.FindExpiredCaptchas(time.Now(), sweepBatchSize)
+    if err != nil {
+        slog.Error("Error finding expired captchas", "error", err)
+        return
+    }
+
+    for i := range users {
+        h.expireCaptcha(&users[i])
+    }
+
+    groups, err := h.db.FindExpiredGroupCaptchas(time.Now(), sweepBatchSize)
+    if err != nil {
+        slog.Error("Error finding expired group captchas", "error", err)
+        return
+    }
+
+    for i := range groups {
+        h.expireGroupCaptcha(&groups[i])
+    }
+}
+
+func (h *BotHandler) expireCaptcha(user *database.User) {
+    captcha := user.CaptchaData
+    countAttempt := h.config.Captcha.TimeoutIsAttempt
+
+    // Conditional, so that a captcha answered in the meantime is left alone
+    updated, err := h.db.ExpireCaptcha(user.TelegramID, captcha.ExpiresAt, countAttempt)
+    if err != nil {
+        if !errors.Is(err, database.ErrNotFound) {
+            slog.Error("Error expiring captcha", "user_id", user.TelegramID, "captcha_type", captcha.Type, "error", err)
+        }
+        return
+    }
+    h.recordCaptchaOutcome(captcha, database.OutcomeTimeout)
+
+    // Group captchas stored with the user before they had their own collection
+    if captcha.IsGroup() {
+        h.removeFromGroup(updated, captcha, h.staffText("reason.captcha_expired"))
+        return
+    }
+
+    lang := h.lang(updated)
+    text := h.tr(lang, "captcha.expired")
+
+    maxAttempts := h.settings().MaxAttempts
+    if countAttempt && !updated.IsVerified && updated.VerificationAttempts >= maxAttempts {
+        until, err := h.blockUser(updated.TelegramID, h.settings().BlockDuration, h.staffText("reason.attempts_exceeded"))
+        if err != nil {
+            slog.Error("Error blocking user", "user_id", updated.TelegramID, "error", err)
+        }
+        text = h.tr(lang, "captcha.blocked") + h.blockedUntilText(lang, until)
+        h.notifyAdmin(updated, false, h.attemptsBlockedReason(until))
+    }
+
+    if captcha.MessageID == 0 {
+        return
+    }
+
+    // Editing the text also drops the buttons, so they stop looking clickable
+    var editMsg tgbotapi.Chattable = tgbotapi.NewEditMessageText(captcha.ChatID, captcha.MessageID, text)
+    if captcha.Type == "image" {
+        editMsg = tgbotapi.NewEditMessageCaption(captcha.ChatID, captcha.MessageID, text)
+    }
+    if _, err := h.bot.Send(editMsg); err != nil {
+        slog.Error("Error editing expired captcha", "chat_id", captcha.ChatID, "user_id", user.TelegramID, "error", err)
+        h.deleteMessage(captcha.ChatID, captcha.MessageID)
+    }
+}
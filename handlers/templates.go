@@ -0,0 +1,353 @@
+package handlers
+
+import (
+    "errors"
+    "fmt"
+    "html"
+    "log/slog"
+    "regexp"
+    "slices"
+    "strings"
+    "text/template"
+    "time"
+    "unicode"
+    "unicode/utf8"
+
+    "telegram-gatekeeper/database"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Messages admins can replace with their own templates
+const (
+    templateWelcome      = "welcome"
+    templateVerified     = "verified"
+    templateBlocked      = "blocked"
+    templateRejected     = "rejected"
+    templateCaptcha      = "captcha"
+    templateConfirmation = "confirmation"
+)
+
+// Settings that held the texts before templates, see mergeSettings
+var legacyTemplateFields = map[string]string{
+    templateWelcome:  "welcome_message",
+    templateVerified: "verified_message",
+}
+
+// messageTemplate is a text staff can change, described to them by the
+// "staff.templates.<name>" text
+type messageTemplate struct {
+    name      string
+    maxLength int // Telegram limit of the rendered text
+}
+
+var messageTemplates = []messageTemplate{
+    {name: templateWelcome, maxLength: 4096},
+    {name: templateVerified, maxLength: 4096},
+    {name: templateBlocked, maxLength: 4096},
+    {name: templateRejected, maxLength: 4096},
+    // Image captchas send it as the caption of the picture
+    {name: templateCaptcha, maxLength: 1024},
+    {name: templateConfirmation, maxLength: 4096},
+}
+
+// templateData is what templates can use. Strings are HTML-escaped, templated
+// messages are sent in HTML mode
+type templateData struct {
+    FirstName    string
+    LastName     string
+    Username     string // Without @, empty when not set
+    ID           int64
+    Attempts     int
+    AttemptsLeft int
+    MaxAttempts  int
+    Question     string // Captcha question, empty for image and button captchas
+    ExpiresIn    string // Time left to answer the captcha
+    BlockedUntil string // Empty when blocked forever
+    BlockedFor   string // Time left until the block ends, empty when blocked forever
+}
+
+const templatePlaceholders = "FirstName, LastName, Username, ID, Attempts, AttemptsLeft, MaxAttempts, Question, ExpiresIn, BlockedUntil, BlockedFor"
+
+// Tags Telegram accepts in HTML mode
+var telegramTags = []string{"a", "b", "blockquote", "code", "del", "em", "i", "ins", "pre", "s", "span", "strike", "strong", "tg-emoji", "tg-spoiler", "u"}
+
+var (
+    htmlTagPattern      = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)[^<>]*>`)
+    unknownFieldPattern = regexp.MustCompile(`can't evaluate field (\w+)`)
+)
+
+// templateData describes the user for their templates
+func (h *BotHandler) templateData(user *database.User) templateData {
+    lang := h.lang(user)
+    maxAttempts := h.settings().MaxAttempts
+
+    data := templateData{
+        FirstName:    html.EscapeString(user.FirstName),
+        LastName:     html.EscapeString(user.LastName),
+        Username:     html.EscapeString(user.Username),
+        ID:           user.TelegramID,
+        Attempts:     user.VerificationAttempts,
+        AttemptsLeft: max(maxAttempts-user.VerificationAttempts, 0),
+        MaxAttempts:  maxAttempts,
+    }
+
+    if user.IsBlocked && user.BlockedUntil != nil {
+        data.BlockedUntil = user.BlockedUntil.Format("02.01.2006 15:04")
+        data.BlockedFor = html.EscapeString(h.formatRemaining(lang, time.Until(*user.BlockedUntil)))
+    }
+
+    return data
+}
+
+// sampleTemplateData is used to check templates and to preview them
+func (h *BotHandler) sampleTemplateData(from *tgbotapi.User) templateData {
+    user := &database.User{
+        TelegramID:           from.ID,
+        FirstName:            from.FirstName,
+        LastName:             from.LastName,
+        Username:             from.UserName,
+        LanguageCode:         from.LanguageCode,
+        VerificationAttempts: 1,
+        IsBlocked:            true,
+    }
+    until := time.Now().Add(24 * time.Hour)
+    user.BlockedUntil = &until
+
+    data := h.templateData(user)
+    data.Question = "3 + 4"
+    data.ExpiresIn = html.EscapeString(h.formatRemaining(h.lang(user), h.settings().CaptchaTTL))
+    return data
+}
+
+// customMessage renders the admin template of the message. It reports false
+// when there is none, or it fails, and the built-in text should be used
+func (h *BotHandler) customMessage(name string, data templateData) (string, bool) {
+    text := h.settings().Templates[name]
+    if text == "" {
+        return "", false
+    }
+
+    rendered, err := renderTemplate(findTemplate(name), text, data)
+    if err != nil {
+        slog.Error("Error rendering template", "template", name, "error", err)
+        return "", false
+    }
+
+    return rendered, true
+}
+
+func findTemplate(name string) messageTemplate {
+    idx := slices.IndexFunc(messageTemplates, func(t messageTemplate) bool { return t.name == name })
+    if idx < 0 {
+        return messageTemplate{name: name, maxLength: 4096}
+    }
+    return messageTemplates[idx]
+}
+
+// renderTemplate executes the template and checks that Telegram will accept the result
+func renderTemplate(t messageTemplate, text string, data templateData) (string, error) {
+    tmpl, err := template.New(t.name).Parse(text)
+    if err != nil {
+        return "", err
+    }
+
+    // Loops in a template could produce a message of any size
+    out := &limitedBuilder{limit: 4 * t.maxLength}
+    if err := tmpl.Execute(out, data); err != nil {
+        if m := unknownFieldPattern.FindStringSubmatch(err.Error()); m != nil {
+            return "", fmt.Errorf("unknown placeholder {{.%s}}, available: %s", m[1], templatePlaceholders)
+        }
+        return "", err
+    }
+
+    rendered := strings.TrimSpace(out.String())
+    if rendered == "" {
+        return "", errors.New("the message is empty")
+    }
+    if n := utf8.RuneCountInString(rendered); n > t.maxLength {
+        return "", fmt.Errorf("the message is %d characters long, the limit is %d", n, t.maxLength)
+    }
+    if err := checkTelegramHTML(rendered); err != nil {
+        return "", err
+    }
+
+    return rendered, nil
+}
+
+// checkTemplate reports why the template cannot be saved. The captcha prompt
+// replaces the built-in one, so without the question math and text captchas
+// could not be answered
+func checkTemplate(name, text string, data templateData) error {
+    rendered, err := renderTemplate(findTemplate(name), text, data)
+    if err != nil {
+        return err
+    }
+    if name == templateCaptcha && !strings.Contains(rendered, data.Question) {
+        return errors.New("the captcha prompt must show the question with {{.Question}}")
+    }
+    return nil
+}
+
+// checkTelegramHTML reports markup Telegram would refuse to send
+func checkTelegramHTML(text string) error {
+    var open []string
+    for _, m := range htmlTagPattern.FindAllStringSubmatch(text, -1) {
+        closing, tag := m[1] == "/", strings.ToLower(m[2])
+        if !slices.Contains(telegramTags, tag) {
+            return fmt.Errorf("tag <%s> is not supported by Telegram", tag)
+        }
+
+        if !closing {
+            open = append(open, tag)
+            continue
+        }
+        if len(open) == 0 || open[len(open)-1] != tag {
+            return fmt.Errorf("unexpected </%s>", tag)
+        }
+        open = open[:len(open)-1]
+    }
+
+    if len(open) > 0 {
+        return fmt.Errorf("<%s> is not closed", open[len(open)-1])
+    }
+    if strings.Contains(htmlTagPattern.ReplaceAllString(text, ""), "<") {
+        return errors.New("write < as &lt;")
+    }
+
+    return nil
+}
+
+// limitedBuilder fails writes once the limit is reached
+type limitedBuilder struct {
+    strings.Builder
+    limit int
+}
+
+func (b *limitedBuilder) Write(p []byte) (int, error) {
+    if b.Len()+len(p) > b.limit {
+        return 0, errors.New("the message is too long")
+    }
+    return b.Builder.Write(p)
+}
+
+func (h *BotHandler) handleTemplateCommand(message *tgbotapi.Message) {
+    action, rest := cutWord(message.CommandArguments())
+    name, text := cutWord(rest)
+    action, name = strings.ToLower(action), strings.ToLower(name)
+
+    if action == "" {
+        h.sendMessageHTML(message.Chat.ID, h.templateList())
+        return
+    }
+
+    if !slices.ContainsFunc(messageTemplates, func(t messageTemplate) bool { return t.name == name }) {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.template.usage")+"\n"+h.staffText("staff.template.see_list"))
+        return
+    }
+
+    switch action {
+    case "show":
+        h.showTemplate(message, name)
+    case "preview":
+        h.previewTemplate(message, name, text)
+    case "set":
+        h.setTemplate(message, name, text)
+    case "reset":
+        h.setTemplate(message, name, "")
+    default:
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.template.usage"))
+    }
+}
+
+func (h *BotHandler) templateList() string {
+    templates := h.settings().Templates
+
+    var sb strings.Builder
+    sb.WriteString(h.staffText("staff.templates.title"))
+
+    for _, t := range messageTemplates {
+        state := h.staffText("staff.templates.builtin")
+        if templates[t.name] != "" {
+            state = h.staffText("staff.templates.custom")
+        }
+        fmt.Fprintf(&sb, "\n<code>%s</code>: %s\n<i>%s</i>\n", t.name, state, html.EscapeString(h.staffText("staff.templates."+t.name)))
+    }
+
+    sb.WriteString(h.staffText("staff.templates.help", templatePlaceholders))
+
+    return sb.String()
+}
+
+func (h *BotHandler) showTemplate(message *tgbotapi.Message, name string) {
+    text := h.settings().Templates[name]
+    if text == "" {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.template.builtin", name))
+        return
+    }
+
+    h.sendMessageHTML(message.Chat.ID, fmt.Sprintf("<code>%s</code>:\n<pre>%s</pre>", name, html.EscapeString(text)))
+}
+
+// previewTemplate renders the given text, or the saved template, with the
+// data of the staff member who asked
+func (h *BotHandler) previewTemplate(message *tgbotapi.Message, name, text string) {
+    if text == "" {
+        text = h.settings().Templates[name]
+    }
+    if text == "" {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.template.nothing_to_preview", name))
+        return
+    }
+
+    rendered, err := renderTemplate(findTemplate(name), text, h.sampleTemplateData(message.From))
+    if err != nil {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.template.invalid", html.EscapeString(err.Error())))
+        return
+    }
+
+    h.sendMessageHTML(message.Chat.ID, rendered)
+}
+
+// setTemplate validates and saves the template, an empty text restores the built-in one
+func (h *BotHandler) setTemplate(message *tgbotapi.Message, name, text string) {
+    if text != "" {
+        if err := checkTemplate(name, text, h.sampleTemplateData(message.From)); err != nil {
+            h.sendMessageHTML(message.Chat.ID, h.staffText("staff.template.invalid", html.EscapeString(err.Error())))
+            return
+        }
+    }
+
+    // Only this template is stored, the others keep following the config
+    var value any
+    if text != "" {
+        value = text
+    }
+    err := h.db.SetSetting(h.adminID, "templates."+name, value)
+    if field, ok := legacyTemplateFields[name]; ok && err == nil {
+        err = h.db.SetSetting(h.adminID, field, nil)
+    }
+    if err != nil {
+        slog.Error("Error saving settings", "error", err)
+        h.sendMessage(message.Chat.ID, h.staffText("staff.template.failed"))
+        return
+    }
+
+    h.invalidateSettings()
+
+    if text == "" {
+        h.sendMessageHTML(message.Chat.ID, h.staffText("staff.template.reset", name))
+        return
+    }
+    h.sendMessageHTML(message.Chat.ID, h.staffText("staff.template.saved", name))
+}
+
+// cutWord splits off the first word, the rest keeps its line breaks
+func cutWord(s string) (string, string) {
+    s = strings.TrimLeftFunc(s, unicode.IsSpace)
+    end := strings.IndexFunc(s, unicode.IsSpace)
+    if end < 0 {
+        return s, ""
+    }
+    return s[:end], strings.TrimSpace(s[end:])
+}
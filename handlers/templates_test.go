@@ -0,0 +1,69 @@
+package handlers
+
+import (
+    "html"
+    "strings"
+    "testing"
+)
+
+func TestCheckTemplate(t *testing.T) {
+    data := templateData{FirstName: "Alice", Question: "3 + 4"}
+
+    if err := checkTemplate(templateCaptcha, "Please solve", data); err == nil || !strings.Contains(err.Error(), "{{.Question}}") {
+        t.Errorf("err = %v, want the question required", err)
+    }
+    if err := checkTemplate(templateCaptcha, "Solve <code>{{.Question}}</code>", data); err != nil {
+        t.Errorf("err = %v, want the prompt with the question accepted", err)
+    }
+    if err := checkTemplate(templateWelcome, "Hi {{.FirstName}}", data); err != nil {
+        t.Errorf("err = %v, want other templates free of the question", err)
+    }
+}
+
+func TestRenderTemplate(t *testing.T) {
+    welcome := findTemplate(templateWelcome)
+    data := templateData{FirstName: html.EscapeString("<script>"), AttemptsLeft: 2}
+
+    tests := []struct {
+        name    string
+        text    string
+        want    string
+        wantErr string
+    }{
+        {name: "placeholders", text: "Hi {{.FirstName}}, {{.AttemptsLeft}} left", want: "Hi &lt;script&gt;, 2 left"},
+        {name: "telegram markup", text: `<b>Hi</b> <a href="https://t.me">there</a>`, want: `<b>Hi</b> <a href="https://t.me">there</a>`},
+        {name: "unknown placeholder", text: "{{.Nickname}}", wantErr: "unknown placeholder {{.Nickname}}"},
+        {name: "syntax error", text: "{{.FirstName", wantErr: "unclosed action"},
+        {name: "unsupported tag", text: "<div>Hi</div>", wantErr: "not supported"},
+        {name: "unclosed tag", text: "<b>Hi", wantErr: "not closed"},
+        {name: "misnested tags", text: "<b><i>Hi</b></i>", wantErr: "unexpected </b>"},
+        {name: "bare angle bracket", text: "1 < 2", wantErr: "&lt;"},
+        {name: "empty", text: "{{if false}}Hi{{end}}", wantErr: "empty"},
+        {name: "too long", text: strings.Repeat("a", welcome.maxLength+1), wantErr: "limit"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := renderTemplate(welcome, tt.text, data)
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got != tt.want {
+                t.Errorf("got %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestCutWord(t *testing.T) {
+    word, rest := cutWord("  set welcome Hi\n{{.FirstName}}")
+    if word != "set" || rest != "welcome Hi\n{{.FirstName}}" {
+        t.Errorf("cutWord = %q, %q", word, rest)
+    }
+}
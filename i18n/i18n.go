@@ -0,0 +1,120 @@
+// Package i18n holds the texts of the bot in every supported language
+package i18n
+
+import (
+    "embed"
+    "encoding/json"
+    "fmt"
+    "path"
+    "slices"
+    "strings"
+)
+
+// One JSON object per locale, the file name is the locale code
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// Catalog looks up translated texts. Texts are fmt format strings, so a
+// translation can reorder the arguments with %[n]s
+type Catalog struct {
+    messages map[string]map[string]string // Locale -> key -> text
+    fallback string
+}
+
+// Load reads the built-in locales. Texts missing in a locale, and locales
+// the bot does not know, fall back to the fallback locale
+func Load(fallback string) (*Catalog, error) {
+    files, err := localeFiles.ReadDir("locales")
+    if err != nil {
+        return nil, err
+    }
+
+    c := &Catalog{messages: make(map[string]map[string]string), fallback: fallback}
+    for _, file := range files {
+        data, err := localeFiles.ReadFile("locales/" + file.Name())
+        if err != nil {
+            return nil, err
+        }
+
+        var texts map[string]string
+        if err := json.Unmarshal(data, &texts); err != nil {
+            return nil, fmt.Errorf("locale %s: %w", file.Name(), err)
+        }
+        c.messages[strings.TrimSuffix(file.Name(), path.Ext(file.Name()))] = texts
+    }
+
+    if !c.Has(fallback) {
+        return nil, fmt.Errorf("unknown locale %q, available: %s", fallback, strings.Join(c.Locales(), ", "))
+    }
+
+    return c, nil
+}
+
+// Fallback returns the locale used when nothing better is known
+func (c *Catalog) Fallback() string {
+    return c.fallback
+}
+
+// Has reports whether the locale is supported
+func (c *Catalog) Has(locale string) bool {
+    _, ok := c.messages[locale]
+    return ok
+}
+
+// Locales returns the supported locales in alphabetical order
+func (c *Catalog) Locales() []string {
+    locales := make([]string, 0, len(c.messages))
+    for locale := range c.messages {
+        locales = append(locales, locale)
+    }
+    slices.Sort(locales)
+    return locales
+}
+
+// Keys returns the keys of a locale in alphabetical order
+func (c *Catalog) Keys(locale string) []string {
+    keys := make([]string, 0, len(c.messages[locale]))
+    for key := range c.messages[locale] {
+        keys = append(keys, key)
+    }
+    slices.Sort(keys)
+    return keys
+}
+
+// Match returns the first supported locale of the given IETF language tags,
+// such as "pt-br" or "en". A tag matches its base language too. Empty and
+// unknown tags are skipped, and the fallback is used when none is left
+func (c *Catalog) Match(tags ...string) string {
+    for _, tag := range tags {
+        tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+        if tag == "" {
+            continue
+        }
+        if c.Has(tag) {
+            return tag
+        }
+        if base, _, ok := strings.Cut(tag, "-"); ok && c.Has(base) {
+            return base
+        }
+    }
+
+    return c.fallback
+}
+
+// T returns the text of the key in the locale, formatted with the arguments.
+// A missing text falls back to the fallback locale, and then to the key itself
+func (c *Catalog) T(locale, key string, args ...any) string {
+    text, ok := c.messages[locale][key]
+    if !ok {
+        text, ok = c.messages[c.fallback][key]
+    }
+    if !ok {
+        return key
+    }
+
+    if len(args) == 0 {
+        return text
+    }
+    return fmt.Sprintf(text, args...)
+}
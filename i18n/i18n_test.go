@@ -0,0 +1,87 @@
+package i18n
+
+import (
+    "regexp"
+    "slices"
+    "testing"
+)
+
+// Format verbs, with an optional explicit argument index
+var verbPattern = regexp.MustCompile(`%(\[\d+\])?[a-z]`)
+
+func TestLocalesHaveTheSameTexts(t *testing.T) {
+    c, err := Load("en")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    want := c.Keys("en")
+    for _, locale := range c.Locales() {
+        keys := c.Keys(locale)
+        for _, key := range want {
+            if !slices.Contains(keys, key) {
+                t.Errorf("%s: missing %q", locale, key)
+                continue
+            }
+
+            // A translation must take the same number of arguments
+            got, base := len(verbPattern.FindAllString(c.T(locale, key), -1)), len(verbPattern.FindAllString(c.T("en", key), -1))
+            if got != base {
+                t.Errorf("%s: %q has %d arguments, want %d", locale, key, got, base)
+            }
+        }
+        for _, key := range keys {
+            if !slices.Contains(want, key) {
+                t.Errorf("%s: %q is not in the en locale", locale, key)
+            }
+        }
+    }
+}
+
+func TestMatch(t *testing.T) {
+    c, err := Load("en")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        tags []string
+        want string
+    }{
+        {tags: []string{"ru"}, want: "ru"},
+        {tags: []string{"RU-ru"}, want: "ru"},
+        {tags: []string{"pt-br"}, want: "en"},
+        {tags: []string{"", "ru"}, want: "ru"},
+        {tags: []string{"de", "ru"}, want: "ru"},
+        {tags: nil, want: "en"},
+    }
+
+    for _, tt := range tests {
+        if got := c.Match(tt.tags...); got != tt.want {
+            t.Errorf("Match(%q) = %q, want %q", tt.tags, got, tt.want)
+        }
+    }
+}
+
+func TestT(t *testing.T) {
+    c, err := Load("en")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if got := c.T("ru", "captcha.wrong", 2, 3); got != "❌ Неверный ответ. Осталось попыток: 2/3" {
+        t.Errorf("ru text = %q", got)
+    }
+    if got := c.T("de", "captcha.outdated"); got != "Captcha is outdated" {
+        t.Errorf("unknown locale = %q, want the en text", got)
+    }
+    if got := c.T("en", "no.such.key"); got != "no.such.key" {
+        t.Errorf("missing key = %q, want the key", got)
+    }
+}
+
+func TestLoadRejectsUnknownFallback(t *testing.T) {
+    if _, err := Load("xx"); err == nil {
+        t.Error("Load(\"xx\") succeeded, want an error")
+    }
+}
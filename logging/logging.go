@@ -0,0 +1,56 @@
+// Package logging sets up log/slog for the bot
+package logging
+
+import (
+    "fmt"
+    "io"
+    "log/slog"
+    "strings"
+)
+
+// Redacted replaces user content in log records
+const Redacted = "[redacted]"
+
+// Attributes that carry message bodies and names of users. Log them only
+// under these keys, so that they are redacted unless content logging is on
+var sensitiveKeys = map[string]bool{
+    "text":     true,
+    "name":     true,
+    "username": true,
+}
+
+// Options of the logger
+type Options struct {
+    Level      string // "debug", "info", "warn" or "error"
+    Format     string // "text" or "json"
+    LogContent bool   // Keep message bodies and names in the log
+}
+
+// New returns a logger that writes to w
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+    var level slog.Level
+    if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+        return nil, fmt.Errorf("unknown log level %q", opts.Level)
+    }
+
+    handlerOpts := &slog.HandlerOptions{Level: level}
+    if !opts.LogContent {
+        handlerOpts.ReplaceAttr = redact
+    }
+
+    switch strings.ToLower(opts.Format) {
+    case "", "text":
+        return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+    case "json":
+        return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+    default:
+        return nil, fmt.Errorf("unknown log format %q", opts.Format)
+    }
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+    if sensitiveKeys[a.Key] && a.Value.String() != "" {
+        return slog.String(a.Key, Redacted)
+    }
+    return a
+}
@@ -0,0 +1,68 @@
+package logging
+
+import (
+    "bytes"
+    "encoding/json"
+    "strings"
+    "testing"
+)
+
+func TestNewRedactsContent(t *testing.T) {
+    tests := []struct {
+        name       string
+        logContent bool
+        wantText   string
+    }{
+        {name: "redacted by default", wantText: Redacted},
+        {name: "kept when enabled", logContent: true, wantText: "hello there"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var buf bytes.Buffer
+            logger, err := New(&buf, Options{Level: "info", Format: "json", LogContent: tt.logContent})
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            logger.Info("Message received", "user_id", 200, "name", "Alice", "text", "hello there")
+
+            var record map[string]any
+            if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+                t.Fatalf("output is not JSON: %v\n%s", err, buf.String())
+            }
+            if record["text"] != tt.wantText {
+                t.Errorf("text = %v, want %q", record["text"], tt.wantText)
+            }
+            if record["user_id"] != float64(200) {
+                t.Errorf("user_id = %v, want 200", record["user_id"])
+            }
+        })
+    }
+}
+
+func TestNewHonorsLevel(t *testing.T) {
+    var buf bytes.Buffer
+    logger, err := New(&buf, Options{Level: "WARN", Format: "text"})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    logger.Info("hidden")
+    logger.Warn("shown")
+
+    if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "shown") {
+        t.Errorf("unexpected output %q", out)
+    }
+}
+
+func TestNewRejectsInvalidOptions(t *testing.T) {
+    for _, opts := range []Options{
+        {Level: "verbose", Format: "text"},
+        {Level: "info", Format: "xml"},
+    } {
+        if _, err := New(&bytes.Buffer{}, opts); err == nil {
+            t.Errorf("New(%+v) succeeded, want an error", opts)
+        }
+    }
+}
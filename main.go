@@ -1,15 +1,22 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"telegram-gatekeeper/config"
 	"telegram-gatekeeper/database"
 	"telegram-gatekeeper/handlers"
+	"telegram-gatekeeper/i18n"
+	"telegram-gatekeeper/logging"
+	"telegram-gatekeeper/metrics"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -17,94 +24,239 @@ import (
 var (
     bot        *tgbotapi.BotAPI
     botHandler *handlers.BotHandler
+    dispatcher *handlers.Dispatcher
 )
 
 func main() {
-    // Loading configuration
-    cfg := config.Load()
+    // Loading configuration, the bot does not start with an invalid one
+    cfg, err := config.Load()
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+    setupLogging(cfg)
     
-    // Connecting to MongoDB
-    mongoDB, err := database.Connect(cfg.MongoURI, cfg.MongoDBName)
+    // Connecting to the storage
+    storage, err := connectStorage(cfg)
     if err != nil {
-        log.Fatalf("Failed to connect to MongoDB: %v", err)
+        fatal("Failed to connect to the storage", err)
     }
-    defer mongoDB.Disconnect()
     
-    // Bot initialization
-    bot, err = tgbotapi.NewBotAPI(cfg.BotToken)
+    // Bot initialization, failed API calls are counted for metrics
+    client := metrics.TelegramClient{Client: &http.Client{}}
+    bot, err = tgbotapi.NewBotAPIWithClient(cfg.BotToken, tgbotapi.APIEndpoint, client)
     if err != nil {
-        log.Fatalf("Failed to create bot: %v", err)
+        fatal("Failed to create bot", err)
     }
     
     bot.Debug = cfg.Debug
-    log.Printf("Authorized on account %s", bot.Self.UserName)
+    slog.Info("Authorized", "bot", bot.Self.UserName)
+    
+    // Texts of the bot, the default language is checked by config.Load
+    catalog, err := i18n.Load(cfg.DefaultLanguage)
+    if err != nil {
+        fatal("Failed to load texts", err)
+    }
     
     // Initialize the handler
-    botHandler = handlers.NewBotHandler(bot, mongoDB, cfg.AdminID)
+    botHandler = handlers.NewBotHandler(bot, storage, cfg, catalog)
+    
+    // Questions of the config are the first ones of a new question bank
+    if err := botHandler.SeedQuestions(); err != nil {
+        slog.Error("Failed to seed the question bank", "error", err)
+    }
+    
+    // Updates are handled by a fixed pool of workers, in order for each user
+    dispatcher = handlers.NewDispatcher(botHandler.HandleUpdate, cfg.Workers, cfg.QueueSize)
+    metrics.RegisterQueue(
+        func() float64 { return float64(dispatcher.Stats().Queued) },
+        func() float64 { return float64(dispatcher.Stats().Busy) },
+    )
+    
+    // The root context is cancelled on the first SIGINT or SIGTERM
+    ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+    defer stop()
+    
+    // Lifting expired blocks in the background
+    var jobs sync.WaitGroup
+    jobs.Add(3)
+    go func() {
+        defer jobs.Done()
+        botHandler.RunBlockExpiry(ctx, time.Minute)
+    }()
+    
+    // Retiring expired captchas in the background
+    go func() {
+        defer jobs.Done()
+        botHandler.RunCaptchaSweeper(ctx, cfg.Captcha.SweepInterval)
+    }()
+    
+    // Applying the retention policies in the background
+    go func() {
+        defer jobs.Done()
+        botHandler.RunRetention(ctx, cfg.Retention.Interval)
+    }()
+    
+    // Serving metrics on a separate listener, so they are not exposed next to the webhook
+    if cfg.MetricsListen != "" {
+        jobs.Add(1)
+        go func() {
+            defer jobs.Done()
+            metrics.Serve(ctx, cfg.MetricsListen)
+        }()
+    }
     
     // Installing commands
-    setupCommands()
+    setupCommands(catalog)
+    
+    // The whole shutdown has one deadline, counted when the first stage asks
+    // for it, so that all the stages together stay within the timeout
+    deadline := sync.OnceValue(func() time.Time {
+        return time.Now().Add(cfg.ShutdownTimeout)
+    })
     
-    // Setting up polling (long polling)
-    setupPolling()
+    // Receiving updates until the bot is asked to stop
+    slog.Info("Bot is running, press Ctrl+C to stop")
+    if cfg.UpdateMode == "webhook" {
+        runWebhook(ctx, cfg.Webhook, deadline)
+    } else {
+        runPolling(ctx)
+    }
     
-    // Waiting for completion signal
-    waitForShutdown()
+    // A second signal kills the bot without waiting
+    stop()
+    
+    shutdown(deadline(), &jobs, storage)
 }
 
-func setupCommands() {
-    commands := tgbotapi.NewSetMyCommands(
-        tgbotapi.BotCommand{
-            Command:     "start",
-            Description: "Start chatting with a bot",
-        },
-        tgbotapi.BotCommand{
-            Command:     "verify",
-            Description: "Pass verification",
-        },
-        tgbotapi.BotCommand{
-            Command:     "status",
-            Description: "Verification status",
-        },
-        tgbotapi.BotCommand{
-            Command:     "help",
-            Description: "Help by command",
-        },
-    )
-    
-    _, err := bot.Request(commands)
+// setupLogging makes the configured logger the default one, for the standard
+// log package and the Bot API library too
+func setupLogging(cfg *config.Config) {
+    logger, err := logging.New(os.Stderr, logging.Options{
+        Level:      cfg.LogLevel,
+        Format:     cfg.LogFormat,
+        LogContent: cfg.LogContent,
+    })
     if err != nil {
-        log.Printf("Failed to set commands: %v", err)
+        fatal("Invalid logging configuration", err)
     }
+    
+    slog.SetDefault(logger)
+    
+    // The library logs raw traffic when bot.Debug is set and retries otherwise
+    libraryLevel := slog.LevelWarn
+    if cfg.Debug {
+        libraryLevel = slog.LevelDebug
+    }
+    tgbotapi.SetLogger(slog.NewLogLogger(logger.Handler(), libraryLevel))
+}
+
+// fatal logs the error and exits
+func fatal(msg string, err error) {
+    slog.Error(msg, "error", err)
+    os.Exit(1)
 }
 
-func setupPolling() {
+func connectStorage(cfg *config.Config) (database.Storage, error) {
+    if cfg.Storage == "memory" {
+        slog.Warn("Using in-memory storage, all data will be lost on restart")
+        return database.NewMemoryStorage(), nil
+    }
+    
+    return database.Connect(cfg.MongoURI, cfg.MongoDBName)
+}
+
+// setupCommands installs the command menu in the default language, and
+// translated for users of every other supported language
+func setupCommands(catalog *i18n.Catalog) {
+    for _, locale := range catalog.Locales() {
+        var commands []tgbotapi.BotCommand
+        for _, name := range []string{"start", "verify", "status", "language", "help"} {
+            commands = append(commands, tgbotapi.BotCommand{
+                Command:     name,
+                Description: catalog.T(locale, "command."+name),
+            })
+        }
+
+        config := tgbotapi.NewSetMyCommands(commands...)
+        if locale != catalog.Fallback() {
+            config = tgbotapi.NewSetMyCommandsWithScopeAndLanguage(tgbotapi.NewBotCommandScopeDefault(), locale, commands...)
+        }
+
+        if _, err := bot.Request(config); err != nil {
+            slog.Error("Failed to set commands", "language", locale, "error", err)
+        }
+    }
+}
+
+func runPolling(ctx context.Context) {
     u := tgbotapi.NewUpdate(0)
     u.Timeout = 60
     
+    // getUpdates does not work while a webhook is set
+    if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+        slog.Error("Failed to delete webhook", "error", err)
+    }
+    
     updates := bot.GetUpdatesChan(u)
     
-    log.Println("Bot started polling for updates...")
+    slog.Info("Bot started polling for updates")
     
     // Processing updates
-    for update := range updates {
-        go botHandler.HandleUpdate(update)
+    for {
+        select {
+        case update := <-updates:
+            // Not cancelled with ctx: a received update must not be dropped
+            dispatcher.Submit(context.Background(), update)
+        case <-ctx.Done():
+            bot.StopReceivingUpdates()
+            
+            // Buffered updates are already confirmed to Telegram and would be
+            // lost, the ones still being fetched will be delivered again
+            for {
+                select {
+                case update, ok := <-updates:
+                    if !ok {
+                        return
+                    }
+                    dispatcher.Submit(context.Background(), update)
+                default:
+                    return
+                }
+            }
+        }
     }
 }
 
-func waitForShutdown() {
-    sigChan := make(chan os.Signal, 1)
-    signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+// shutdown waits for in-flight updates and background jobs until the deadline,
+// then closes the storage. Updates that do not finish in time are abandoned
+func shutdown(deadline time.Time, jobs *sync.WaitGroup, storage database.Storage) {
+    slog.Info("Shutting down bot")
     
-    log.Println("Bot is running. Press Ctrl+C to stop.")
-    
-    // Waiting for the completion signal
-    <-sigChan
+    if !dispatcher.Shutdown(time.Until(deadline)) {
+        slog.Warn("In-flight updates did not finish in time")
+    }
+    if !waitTimeout(jobs, time.Until(deadline)) {
+        slog.Warn("Background jobs did not finish in time")
+    }
     
-    log.Println("\nShutting down bot...")
+    storage.Disconnect()
     
-    // We give time to complete operations
-    time.Sleep(2 * time.Second)
+    slog.Info("Bot shutdown complete")
+}
+
+// waitTimeout reports whether wg was done before the timeout
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+    done := make(chan struct{})
+    go func() {
+        wg.Wait()
+        close(done)
+    }()
     
-    log.Println("Bot shutdown complete")
-}
\ No newline at end of file
+    select {
+    case <-done:
+        return true
+    case <-time.After(timeout):
+        return false
+    }
+}
@@ -0,0 +1,153 @@
+// Package metrics exposes the runtime state of the bot in the Prometheus text format
+package metrics
+
+import (
+    "context"
+    "log/slog"
+    "net/http"
+    "path"
+    "strconv"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promauto"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+    "go.mongodb.org/mongo-driver/event"
+)
+
+const namespace = "gatekeeper"
+
+// Captcha events, besides the outcomes stored in the database
+const CaptchaIssued = "issued"
+
+var (
+    updatesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Name:      "updates_received_total",
+        Help:      "Telegram updates received, by update type.",
+    }, []string{"type"})
+
+    handlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: namespace,
+        Name:      "handler_duration_seconds",
+        Help:      "Time spent handling an update, by update type.",
+        Buckets:   prometheus.DefBuckets,
+    }, []string{"type"})
+
+    captchas = promauto.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Name:      "captchas_total",
+        Help:      "Captchas by captcha type and event: issued, passed, failed or expired.",
+    }, []string{"type", "event"})
+
+    telegramErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Name:      "telegram_api_errors_total",
+        Help:      "Failed Telegram Bot API calls, by method and error code.",
+    }, []string{"method", "code"})
+
+    mongoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: namespace,
+        Name:      "mongo_operation_duration_seconds",
+        Help:      "Latency of MongoDB commands, by command name.",
+        Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+    }, []string{"operation"})
+
+    mongoErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+        Namespace: namespace,
+        Name:      "mongo_operation_errors_total",
+        Help:      "Failed MongoDB commands, by command name.",
+    }, []string{"operation"})
+)
+
+// ObserveUpdate counts an update and records how long handling it took
+func ObserveUpdate(updateType string, started time.Time) {
+    updatesReceived.WithLabelValues(updateType).Inc()
+    handlerDuration.WithLabelValues(updateType).Observe(time.Since(started).Seconds())
+}
+
+// CountCaptcha counts a captcha event: CaptchaIssued or an outcome
+func CountCaptcha(captchaType, captchaEvent string) {
+    captchas.WithLabelValues(captchaType, captchaEvent).Inc()
+}
+
+// RegisterQueue exposes the depth of the update queue, running updates included,
+// and the number of busy workers. The number of goroutines is reported by the Go
+// collector as go_goroutines
+func RegisterQueue(depth, busy func() float64) {
+    queueDepth, workersBusy := queueGauges(depth, busy)
+    prometheus.MustRegister(queueDepth, workersBusy)
+}
+
+// queueGauges builds the gauges of the update queue, they are read on every scrape
+func queueGauges(depth, busy func() float64) (queueDepth, workersBusy prometheus.GaugeFunc) {
+    queueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+        Namespace: namespace,
+        Name:      "update_queue_depth",
+        Help:      "Updates waiting for a worker or being handled.",
+    }, depth)
+    workersBusy = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+        Namespace: namespace,
+        Name:      "workers_busy",
+        Help:      "Workers handling an update right now.",
+    }, busy)
+    return queueDepth, workersBusy
+}
+
+// MongoMonitor times every command sent to MongoDB
+func MongoMonitor() *event.CommandMonitor {
+    return &event.CommandMonitor{
+        Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+            mongoDuration.WithLabelValues(e.CommandName).Observe(e.Duration.Seconds())
+        },
+        Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+            mongoDuration.WithLabelValues(e.CommandName).Observe(e.Duration.Seconds())
+            mongoErrors.WithLabelValues(e.CommandName).Inc()
+        },
+    }
+}
+
+// TelegramClient counts failed Bot API calls. The method is the last element of
+// the request path, the code is the HTTP status, which the Bot API sets to the
+// error_code of the response, or "network" when there was no response
+type TelegramClient struct {
+    Client *http.Client
+}
+
+func (c TelegramClient) Do(req *http.Request) (*http.Response, error) {
+    method := path.Base(req.URL.Path)
+
+    resp, err := c.Client.Do(req)
+    if err != nil {
+        telegramErrors.WithLabelValues(method, "network").Inc()
+        return resp, err
+    }
+    if resp.StatusCode != http.StatusOK {
+        telegramErrors.WithLabelValues(method, strconv.Itoa(resp.StatusCode)).Inc()
+    }
+    return resp, nil
+}
+
+// Serve exposes /metrics on the address until the context is cancelled
+func Serve(ctx context.Context, addr string) {
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", promhttp.Handler())
+
+    server := &http.Server{
+        Addr:    addr,
+        Handler: mux,
+    }
+
+    go func() {
+        <-ctx.Done()
+
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        server.Shutdown(shutdownCtx)
+    }()
+
+    slog.Info("Serving metrics", "addr", addr, "path", "/metrics")
+    if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+        slog.Error("Metrics server failed", "error", err)
+    }
+}
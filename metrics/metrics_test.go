@@ -0,0 +1,35 @@
+package metrics
+
+import (
+    "sync/atomic"
+    "testing"
+
+    "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestQueueGauges(t *testing.T) {
+    var queued, busy atomic.Int64
+    depthGauge, busyGauge := queueGauges(
+        func() float64 { return float64(queued.Load()) },
+        func() float64 { return float64(busy.Load()) },
+    )
+
+    // The gauges are read on every scrape, not when they are built
+    queued.Store(3)
+    busy.Store(2)
+    if got := testutil.ToFloat64(depthGauge); got != 3 {
+        t.Errorf("queue depth = %v, want 3", got)
+    }
+    if got := testutil.ToFloat64(busyGauge); got != 2 {
+        t.Errorf("busy workers = %v, want 2", got)
+    }
+
+    queued.Store(0)
+    busy.Store(0)
+    if got := testutil.ToFloat64(depthGauge); got != 0 {
+        t.Errorf("queue depth = %v after draining, want 0", got)
+    }
+    if got := testutil.ToFloat64(busyGauge); got != 0 {
+        t.Errorf("busy workers = %v after draining, want 0", got)
+    }
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"telegram-gatekeeper/config"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+    secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+    // Telegram updates are small, anything bigger is not from Telegram
+    maxUpdateSize = 1 << 20
+)
+
+// registerWebhook tells Telegram where to send updates. The library does not
+// know about secret_token yet, so the request is built by hand
+func registerWebhook(webhook config.WebhookConfig) error {
+    params := make(tgbotapi.Params)
+    params["url"] = webhook.URL
+    params["secret_token"] = webhook.SecretToken
+    params.AddNonZero("max_connections", webhook.MaxConnections)
+    params.AddBool("drop_pending_updates", webhook.DropPending)
+
+    var err error
+    if webhook.UploadCert {
+        files := []tgbotapi.RequestFile{{Name: "certificate", Data: tgbotapi.FilePath(webhook.CertFile)}}
+        _, err = bot.UploadFiles("setWebhook", params, files)
+    } else {
+        _, err = bot.MakeRequest("setWebhook", params)
+    }
+    return err
+}
+
+// webhookHandler accepts updates from Telegram and passes them to the same
+// pipeline as long polling. Updates are acknowledged once they are queued, so a
+// slow handler never makes Telegram retry the delivery
+func webhookHandler(secretToken string) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            w.Header().Set("Allow", http.MethodPost)
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+
+        token := r.Header.Get(secretTokenHeader)
+        if subtle.ConstantTimeCompare([]byte(token), []byte(secretToken)) != 1 {
+            slog.Warn("Rejected webhook request with a wrong secret token", "remote_addr", r.RemoteAddr)
+            http.Error(w, "forbidden", http.StatusForbidden)
+            return
+        }
+
+        var update tgbotapi.Update
+        if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateSize)).Decode(&update); err != nil {
+            slog.Error("Failed to decode webhook update", "error", err)
+            http.Error(w, "bad request", http.StatusBadRequest)
+            return
+        }
+
+        // Waits while the queue is full, Telegram then slows down deliveries
+        if !dispatcher.Submit(r.Context(), update) {
+            http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+            return
+        }
+
+        w.WriteHeader(http.StatusOK)
+    }
+}
+
+// runWebhook serves webhook requests until ctx is cancelled. Shutting the
+// server down waits for running requests, so every acknowledged update is
+// already queued when it returns, or the shutdown deadline has passed
+func runWebhook(ctx context.Context, webhook config.WebhookConfig, deadline func() time.Time) {
+    if webhook.Register {
+        if err := registerWebhook(webhook); err != nil {
+            fatal("Failed to set webhook", err)
+        }
+        slog.Info("Webhook registered", "url", webhook.URL)
+    }
+
+    mux := http.NewServeMux()
+    mux.Handle(webhook.Path, webhookHandler(webhook.SecretToken))
+
+    // Health check for load balancers
+    mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    })
+
+    server := &http.Server{
+        Addr:    webhook.Listen,
+        Handler: mux,
+    }
+
+    slog.Info("Bot started listening for webhook updates", "addr", webhook.Listen, "path", webhook.Path)
+
+    errs := make(chan error, 1)
+    go func() {
+        if webhook.TLS() {
+            errs <- server.ListenAndServeTLS(webhook.CertFile, webhook.KeyFile)
+        } else {
+            errs <- server.ListenAndServe()
+        }
+    }()
+
+    select {
+    case err := <-errs:
+        fatal("Webhook server failed", err)
+    case <-ctx.Done():
+    }
+
+    shutdownCtx, cancel := context.WithDeadline(context.Background(), deadline())
+    defer cancel()
+
+    if err := server.Shutdown(shutdownCtx); err != nil {
+        slog.Error("Error stopping webhook server", "error", err)
+    }
+}